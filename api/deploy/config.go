@@ -9,23 +9,58 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
 
+	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"we.com/dolphin/api/utils"
+	"we.com/dolphin/controllers/scheduler"
 	"we.com/dolphin/registry/etcdkey"
 	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/registry/history"
+	"we.com/dolphin/registry/instances"
 	"we.com/dolphin/types"
 	"we.com/jiabiao/common/fields"
 	"we.com/jiabiao/common/labels"
 )
 
 const (
-	envName  = "env"
-	typeName = "type"
-	nameName = "name"
+	envName      = "env"
+	typeName     = "type"
+	nameName     = "name"
+	revisionName = "revision"
+	hostName     = "host"
+	backupName   = "backup"
 )
 
+var (
+	lock       sync.RWMutex
+	schedulers = map[types.Stage]scheduler.Manager{}
+)
+
+// SetScheduler  set scheduler manager of stage, which is used to apply deploy changes
+func SetScheduler(stage types.Stage, m scheduler.Manager) {
+	lock.Lock()
+	defer lock.Unlock()
+	if m == nil {
+		delete(schedulers, stage)
+		return
+	}
+	schedulers[stage] = m
+}
+
+func getScheduler(stage types.Stage) (scheduler.Manager, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	m, ok := schedulers[stage]
+	if !ok {
+		return nil, errors.Errorf("no scheduler for env %v", stage)
+	}
+	return m, nil
+}
+
 // Install deploy config handler
 func Install(r *mux.Router) error {
 	s := r.PathPrefix("/deployconfig").Subrouter()
@@ -38,6 +73,22 @@ func Install(r *mux.Router) error {
 
 	s.HandleFunc("/{env}", utils.HandlefuncWrap(list)).Methods(http.MethodPost)
 
+	s.HandleFunc("/{env}/{type}/{name}/history", utils.HandlefuncWrap(listHistory)).Methods(http.MethodGet)
+
+	s.HandleFunc("/{env}/{type}/{name}/history/{revision}", utils.HandlefuncWrap(getHistory)).Methods(http.MethodGet)
+
+	s.HandleFunc("/{env}/{type}/{name}/rollback/{revision}", utils.HandlefuncWrap(rollback)).Methods(http.MethodPost)
+
+	s.HandleFunc("/{env}/{type}/{name}/rollout", utils.HandlefuncWrap(rolloutState)).Methods(http.MethodGet)
+
+	s.HandleFunc("/{env}/{type}/{name}/plan", utils.HandlefuncWrap(plan)).Methods(http.MethodPost)
+
+	s.HandleFunc("/{env}/{type}/{name}/status", utils.HandlefuncWrap(status)).Methods(http.MethodGet)
+
+	s.HandleFunc("/{env}/{type}/{name}/backups", utils.HandlefuncWrap(listBackups)).Methods(http.MethodGet)
+
+	s.HandleFunc("/{env}/{type}/{name}/backups/{host}/{backup}/restore", utils.HandlefuncWrap(restore)).Methods(http.MethodPost)
+
 	return nil
 }
 
@@ -87,6 +138,34 @@ func add(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
 	return nil, err
 }
 
+// /{env}/{type}/{name}/plan  host config changes of deploying the posted config, nothing is applied
+func plan(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, typ, name, err := getStageTypeAndName(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := types.DeployConfig{}
+	if err := utils.Receive(r, &dc); err != nil {
+		return nil, utils.BadData(err)
+	}
+
+	dc.Stage = stage
+	dc.Type = typ
+	dc.Name = name
+
+	if err := dc.Validate(); err != nil {
+		return nil, utils.BadData(errors.Wrap(err, "validate deploy config"))
+	}
+
+	m, err := getScheduler(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Plan(r.Context(), &dc)
+}
+
 func get(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
 	stage, typ, name, err := getStageTypeAndName(r)
 	if err != nil {
@@ -137,6 +216,158 @@ func list(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
 	return dcs, err
 }
 
+func getRevision(r *http.Request) (int64, error) {
+	str := mux.Vars(r)[revisionName]
+	rev, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || rev <= 0 {
+		return 0, utils.BadData(errors.Errorf("invalid revision: %v", str))
+	}
+	return rev, nil
+}
+
+func listHistory(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, typ, name, err := getStageTypeAndName(r)
+	if err != nil {
+		return nil, err
+	}
+
+	hr, err := history.NewRegistry(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	return hr.List(getDeploykey(typ, name))
+}
+
+func getHistory(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, typ, name, err := getStageTypeAndName(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err := getRevision(r)
+	if err != nil {
+		return nil, err
+	}
+
+	hr, err := history.NewRegistry(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	return hr.Get(getDeploykey(typ, name), rev)
+}
+
+// rollbackResp  a rollback accepted, it is rolled out in background
+type rollbackResp struct {
+	Revision *types.DeployRevision `json:"revision"`
+	// StatusURL  where progress of the rollout is got
+	StatusURL string `json:"statusURL"`
+}
+
+// /{env}/{type}/{name}/rollback/{revision}, the rollout runs in background, it is not canceled
+// when the request is done, 202 Accepted is responded with url of its progress
+func rollback(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, typ, name, err := getStageTypeAndName(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err := getRevision(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getScheduler(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	hr, err := history.NewRegistry(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	key := getDeploykey(typ, name)
+	ret, err := hr.Get(key, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.StartRollback(key, rev); err != nil {
+		return nil, errors.Wrapf(err, "rollback %v to revision %v", key, rev)
+	}
+
+	u := fmt.Sprintf("/deployconfig/%v/%v/%v/rollout", stage, typ, name)
+	w.Header().Set("Location", u)
+	return utils.Accepted(&rollbackResp{Revision: ret, StatusURL: u}), nil
+}
+
+// /{env}/{type}/{name}/rollout, progress of the current or the last rollout
+func rolloutState(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, typ, name, err := getStageTypeAndName(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getScheduler(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.RolloutState(getDeploykey(typ, name))
+}
+
+// /{env}/{type}/{name}/status, status of the latest deploy on every host
+func status(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, typ, name, err := getStageTypeAndName(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ir, err := instances.NewRegistry(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	return ir.GetDeployments(getDeploykey(typ, name))
+}
+
+// /{env}/{type}/{name}/backups, backups kept on every host
+func listBackups(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, typ, name, err := getStageTypeAndName(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ir, err := instances.NewRegistry(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	return ir.GetBackups(getDeploykey(typ, name))
+}
+
+// /{env}/{type}/{name}/backups/{host}/{backup}/restore, the agent on host restores it in background
+func restore(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, typ, name, err := getStageTypeAndName(r)
+	if err != nil {
+		return nil, err
+	}
+	vars := mux.Vars(r)
+
+	ir, err := instances.NewRegistry(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := ir.RequestRestore(getDeploykey(typ, name), types.HostID(vars[hostName]), types.UUID(vars[backupName]))
+	if err != nil {
+		return nil, utils.BadData(err)
+	}
+	return ret, nil
+}
+
 func getStore(stage types.Stage) (generic.Interface, error) {
 	prefix := etcdkey.StageBaseDir(stage)
 	return generic.GetStoreInstance(prefix, false)
@@ -148,7 +379,11 @@ func getDeployConfig(stage types.Stage, key types.DeployKey) (*types.DeployConfi
 		return nil, err
 	}
 
-	path := etcdkey.DeployInstanceDirOfKey(stage, key)
+	if err := migrateDeployConfig(context.Background(), store, stage, key); err != nil {
+		return nil, err
+	}
+
+	path := etcdkey.DepoyConfigOfKey(stage, key)
 
 	ret := types.DeployConfig{}
 
@@ -169,7 +404,11 @@ func addDeployConfig(dc *types.DeployConfig, ignoreExist bool) error {
 		return err
 	}
 
-	path := etcdkey.DeployInstanceDirOfKey(dc.Stage, dc.Key())
+	if err := migrateDeployConfig(context.Background(), store, dc.Stage, dc.Key()); err != nil {
+		return err
+	}
+
+	path := etcdkey.DepoyConfigOfKey(dc.Stage, dc.Key())
 
 	if ignoreExist {
 		return store.Update(context.Background(), path, dc, nil, 0)
@@ -184,9 +423,13 @@ func deleteDeployConfig(stage types.Stage, key types.DeployKey) (*types.DeployCo
 		return nil, err
 	}
 
+	if err := migrateDeployConfig(context.Background(), store, stage, key); err != nil {
+		return nil, err
+	}
+
 	dc := types.DeployConfig{}
 
-	path := etcdkey.DeployInstanceDirOfKey(stage, key)
+	path := etcdkey.DepoyConfigOfKey(stage, key)
 
 	if err := store.Delete(context.Background(), path, &dc); err != nil {
 		return nil, err
@@ -195,6 +438,36 @@ func deleteDeployConfig(stage types.Stage, key types.DeployKey) (*types.DeployCo
 	return &dc, nil
 }
 
+// legacyDeployConfigPath  where deploy configs were saved before they were moved under DeployConfigDir,
+// it is in the instance dir of key, and is listed as an instance
+func legacyDeployConfigPath(stage types.Stage, key types.DeployKey) string {
+	return etcdkey.DeployInstanceDirOfKey(stage, key)
+}
+
+// migrateDeployConfig  move deploy config of key from the legacy path to DeployConfigDir,
+// if there is already one under DeployConfigDir, the legacy one is dropped
+func migrateDeployConfig(ctx context.Context, store generic.Interface, stage types.Stage, key types.DeployKey) error {
+	legacy := legacyDeployConfigPath(stage, key)
+	dc := types.DeployConfig{}
+	if err := store.Get(ctx, legacy, &dc, false); err != nil {
+		if generic.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "get legacy deploy config of %v", key)
+	}
+
+	path := etcdkey.DepoyConfigOfKey(stage, key)
+	if err := store.Create(ctx, path, &dc, nil, 0); err != nil && !generic.IsNodeExist(err) {
+		return errors.Wrapf(err, "migrate deploy config of %v", key)
+	}
+
+	if err := store.Delete(ctx, legacy, nil); err != nil && !generic.IsNotFound(err) {
+		return errors.Wrapf(err, "delete legacy deploy config of %v", key)
+	}
+	glog.Infof("deploy: deploy config of %v migrated from %v to %v", key, legacy, path)
+	return nil
+}
+
 func queryDeployConfig(stage types.Stage, s labels.Selector) ([]*types.DeployConfig, error) {
 	store, err := getStore(stage)
 	if err != nil {
@@ -6,18 +6,64 @@ Sniperkit-Bot
 package host
 
 import (
+	"context"
 	"net/http"
+	"sync"
 
+	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"we.com/dolphin/api/utils"
+	"we.com/dolphin/controllers/scheduler"
+	"we.com/dolphin/registry/hosts"
+	"we.com/dolphin/types"
 )
 
-// Install deploy config handler
+const (
+	envName  = "env"
+	hostName = "host"
+)
+
+var (
+	lock       sync.RWMutex
+	schedulers = map[types.Stage]scheduler.Manager{}
+)
+
+// SetScheduler  set scheduler manager of stage, which is used to drain hosts
+func SetScheduler(stage types.Stage, m scheduler.Manager) {
+	lock.Lock()
+	defer lock.Unlock()
+	if m == nil {
+		delete(schedulers, stage)
+		return
+	}
+	schedulers[stage] = m
+}
+
+func getScheduler(stage types.Stage) (scheduler.Manager, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	m, ok := schedulers[stage]
+	if !ok {
+		return nil, errors.Errorf("no scheduler for env %v", stage)
+	}
+	return m, nil
+}
+
+// Install host handler
 func Install(r *mux.Router) error {
 	s := r.PathPrefix("/host").Subrouter()
 
 	s.HandleFunc("/{env}/{type}/{name}", utils.HandlefuncWrap(add)).Methods(http.MethodPut)
 
+	s.HandleFunc("/{env}/{host}/config", utils.HandlefuncWrap(getConfig)).Methods(http.MethodGet)
+
+	s.HandleFunc("/{env}/{host}/cordon", utils.HandlefuncWrap(cordon)).Methods(http.MethodPost)
+
+	s.HandleFunc("/{env}/{host}/uncordon", utils.HandlefuncWrap(uncordon)).Methods(http.MethodPost)
+
+	s.HandleFunc("/{env}/{host}/drain", utils.HandlefuncWrap(drain)).Methods(http.MethodPost)
+
 	return nil
 }
 
@@ -45,3 +91,79 @@ func query(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
 
 	return nil, nil
 }
+
+// getHost  stage, registry and host info of the host in request
+func getHost(r *http.Request) (types.Stage, *hosts.Registry, *types.HostInfo, error) {
+	vars := mux.Vars(r)
+	stage, err := types.ParseStage(vars[envName])
+	if err != nil {
+		return stage, nil, nil, utils.BadData(errors.Wrap(err, "parse env"))
+	}
+
+	hr, err := hosts.NewRegistry(stage)
+	if err != nil {
+		return stage, nil, nil, err
+	}
+
+	hi, err := hr.GetHostInfoOfHostID(types.HostID(vars[hostName]))
+	if err != nil {
+		return stage, nil, nil, errors.Wrapf(err, "get host info of %v", vars[hostName])
+	}
+
+	return stage, hr, hi, nil
+}
+
+func getConfig(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	_, hr, hi, err := getHost(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return hr.GetConfig(string(hi.HostName))
+}
+
+// /{env}/{host}/cordon?reason=xxx
+func cordon(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	_, hr, hi, err := getHost(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return hr.Cordon(string(hi.HostName), r.URL.Query().Get("reason"))
+}
+
+func uncordon(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	_, hr, hi, err := getHost(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return hr.Uncordon(string(hi.HostName))
+}
+
+// /{env}/{host}/drain   host is cordoned at once, instances are moved in background
+func drain(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, hr, hi, err := getHost(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getScheduler(stage)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := hr.Cordon(string(hi.HostName), "drain")
+	if err != nil {
+		return nil, err
+	}
+
+	// drain may take a long time, do not bind it to the request
+	go func() {
+		if err := m.Drain(context.Background(), hi.HostID); err != nil {
+			glog.Errorf("host: drain %v: %v", hi.HostID, err)
+		}
+	}()
+
+	return ret, nil
+}
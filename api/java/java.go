@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"we.com/dolphin/api/utils"
 )
 
 // Install java handler
@@ -21,9 +22,21 @@ func Install(r *mux.Router) error {
 
 	s.HandleFunc("/stop", nil)
 
-	s.HandleFunc("/probe/{type}/{name}", nil).Methods(http.MethodPost)
-	s.HandleFunc("/probe/{type}/{name}", nil).Methods(http.MethodDelete)
-	s.HandleFunc("/probe/{type}/{name}", nil).Methods(http.MethodGet)
+	s.HandleFunc("/probe/{env}/{name}", utils.HandlefuncWrap(probeList)).Methods(http.MethodGet)
+	s.HandleFunc("/probe/{env}/{name}/{iface}", utils.HandlefuncWrap(probeGet)).Methods(http.MethodGet)
+	s.HandleFunc("/probe/{env}/{name}/{iface}", utils.HandlefuncWrap(probeAdd)).Methods(http.MethodPut)
+	s.HandleFunc("/probe/{env}/{name}/{iface}", utils.HandlefuncWrap(probeDelete)).Methods(http.MethodDelete)
+	s.HandleFunc("/probe/{env}/{name}/{iface}/run", utils.HandlefuncWrap(probe)).Methods(http.MethodPost)
+
+	s.HandleFunc("/route/{env}/{name}", utils.HandlefuncWrap(getServiceRoute)).Methods(http.MethodGet)
+	s.HandleFunc("/route/{env}/{name}", utils.HandlefuncWrap(setServiceRoute)).Methods(http.MethodPut)
+	s.HandleFunc("/route/{env}/{name}", utils.HandlefuncWrap(createServiceRoute)).Methods(http.MethodPost)
+	s.HandleFunc("/route/{env}/{name}/validate", utils.HandlefuncWrap(validateServiceRoute)).Methods(http.MethodPost)
+	s.HandleFunc("/route/{env}/{name}/diff", utils.HandlefuncWrap(diffServiceRoute)).Methods(http.MethodPost)
+
+	s.HandleFunc("/sync/{env}/report", utils.HandlefuncWrap(syncReport)).Methods(http.MethodGet)
+	s.HandleFunc("/sync/{env}/conflicts", utils.HandlefuncWrap(syncConflicts)).Methods(http.MethodGet)
+	s.HandleFunc("/sync/{env}/resolve", utils.HandlefuncWrap(resolveSyncConflict)).Methods(http.MethodPost)
 
 	return nil
 }
@@ -52,13 +65,3 @@ func restartInstance(w http.ResponseWriter, r *http.Request) error {
 
 	return nil
 }
-
-func setServiceRoute(w http.ResponseWriter, r *http.Request) error {
-
-	return nil
-}
-
-func getServiceRoute(w http.ResponseWriter, r *http.Request) error {
-
-	return nil
-}
@@ -6,23 +6,245 @@ Sniperkit-Bot
 package java
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"we.com/dolphin/api/utils"
+	ctypes "we.com/dolphin/controllers/types"
+	rjava "we.com/dolphin/registry/java"
+	"we.com/dolphin/types"
+	"we.com/dolphin/types/ins/java"
 )
 
+const (
+	ifaceName = "iface"
+
+	defaultProbeTimeout = 5 * time.Second
+	maxProbeTimeout     = time.Minute
+)
+
+var (
+	insInfors = map[types.Stage]ctypes.InstanceInfor{}
+	esbs      = map[types.Stage]ESBChecker{}
+)
+
+// ESBChecker  tells which esbs a deployment may be probed through
+type ESBChecker interface {
+	// KnownESB  if addr, host:port, is an esb configured for deployment name
+	KnownESB(name types.DeployName, addr string) bool
+}
+
+// SetInstanceInfor  set instance infor of stage, which is used to find instances to probe
+func SetInstanceInfor(stage types.Stage, info ctypes.InstanceInfor) {
+	lock.Lock()
+	defer lock.Unlock()
+	if info == nil {
+		delete(insInfors, stage)
+		return
+	}
+	insInfors[stage] = info
+}
+
+// SetESBChecker  set esb checker of stage, only esbs it knows are probed
+func SetESBChecker(stage types.Stage, c ESBChecker) {
+	lock.Lock()
+	defer lock.Unlock()
+	if c == nil {
+		delete(esbs, stage)
+		return
+	}
+	esbs[stage] = c
+}
+
+// probeReq  where to run a probe interface, either an esb or a running instance of the deployment
+type probeReq struct {
+	// ESB  host:port of esb, it must be an esb configured for the api version of the deployment
+	ESB      string           `json:"esb,omitempty"`
+	Instance types.InstanceID `json:"instance,omitempty"`
+	// Timeout  5s if not set
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// probeList  probe interfaces of a deployment, and their version
+type probeList struct {
+	Version    int64                `json:"version"`
+	Interfaces java.ProbeInterfaces `json:"interfaces"`
+}
+
+func probeVars(r *http.Request) (types.Stage, types.DeployName, string, error) {
+	vars := mux.Vars(r)
+	stage, err := types.ParseStage(vars[envName])
+	if err != nil {
+		return stage, "", "", utils.BadData(errors.Wrap(err, "parse env"))
+	}
+	name := types.DeployName(vars[nameName])
+	if name == "" {
+		return stage, "", "", utils.BadData(errors.New("deploy name is required"))
+	}
+	return stage, name, vars[ifaceName], nil
+}
+
+func getProbeInterface(stage types.Stage, name types.DeployName, iface string) (*java.ProbeInterface, error) {
+	ifs, _, err := rjava.GetProbeInterfaces(stage, name)
+	if err != nil {
+		return nil, err
+	}
+	pi, ok := ifs[iface]
+	if !ok {
+		return nil, utils.BadData(errors.Errorf("probe interface %v of %v not exist", iface, name))
+	}
+	return pi, nil
+}
+
+// PUT /probe/{env}/{name}/{iface}, create or replace an interface
 func probeAdd(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
-	return nil, nil
+	stage, name, iface, err := probeVars(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pi := java.ProbeInterface{}
+	if err := utils.Receive(r, &pi); err != nil {
+		return nil, utils.BadData(err)
+	}
+	if pi.Name != "" && pi.Name != iface {
+		return nil, utils.BadData(errors.Errorf("interface name %v does not match %v", pi.Name, iface))
+	}
+	pi.Name = iface
+	if err := pi.Validate(); err != nil {
+		return nil, utils.BadData(err)
+	}
+
+	_, err = rjava.ModifyProbeInterfaces(stage, name, func(ifs java.ProbeInterfaces) error {
+		ifs[iface] = &pi
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pi, nil
 }
 
+// DELETE /probe/{env}/{name}/{iface}
 func probeDelete(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
-	return nil, nil
+	stage, name, iface, err := probeVars(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var pi *java.ProbeInterface
+	_, err = rjava.ModifyProbeInterfaces(stage, name, func(ifs java.ProbeInterfaces) error {
+		var ok bool
+		if pi, ok = ifs[iface]; !ok {
+			return utils.BadData(errors.Errorf("probe interface %v of %v not exist", iface, name))
+		}
+		delete(ifs, iface)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pi, nil
 }
 
+// GET /probe/{env}/{name}
 func probeList(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
-	return nil, nil
+	stage, name, _, err := probeVars(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ifs, ver, err := rjava.GetProbeInterfaces(stage, name)
+	if err != nil {
+		return nil, err
+	}
+	return &probeList{Version: ver, Interfaces: ifs}, nil
 }
 
+// GET /probe/{env}/{name}/{iface}
+func probeGet(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	stage, name, iface, err := probeVars(r)
+	if err != nil {
+		return nil, err
+	}
+	return getProbeInterface(stage, name, iface)
+}
+
+// POST /probe/{env}/{name}/{iface}/run, probe an esb or instance with the interface now
 func probe(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
-	return nil, nil
+	stage, name, iface, err := probeVars(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := probeReq{}
+	if err := utils.Receive(r, &req); err != nil {
+		return nil, utils.BadData(err)
+	}
+
+	timeout := defaultProbeTimeout
+	if req.Timeout != "" {
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil {
+			return nil, utils.BadData(errors.Wrap(err, "parse timeout"))
+		}
+		if timeout <= 0 || timeout > maxProbeTimeout {
+			return nil, utils.BadData(errors.Errorf("timeout should be within (0, %v]", maxProbeTimeout))
+		}
+	}
+
+	url, err := probeURL(stage, name, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	pi, err := getProbeInterface(stage, name, iface)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cf := context.WithTimeout(r.Context(), timeout)
+	defer cf()
+	return pi.Run(ctx, http.DefaultClient, url), nil
+}
+
+// probeURL  only known esbs and running instances of the deployment are probed,
+// so the api cannot be used to send requests to arbitrary addresses
+func probeURL(stage types.Stage, name types.DeployName, req *probeReq) (string, error) {
+	lock.RLock()
+	info := insInfors[stage]
+	ec := esbs[stage]
+	lock.RUnlock()
+
+	switch {
+	case req.ESB != "" && req.Instance != "":
+		return "", utils.BadData(errors.New("only one of esb and instance should be given"))
+	case req.ESB != "":
+		if ec == nil {
+			return "", errors.Errorf("no esb info for env %v", stage)
+		}
+		if !ec.KnownESB(name, req.ESB) {
+			return "", utils.BadData(errors.Errorf("%v is not an esb of %v", req.ESB, name))
+		}
+		return fmt.Sprintf("http://%v", req.ESB), nil
+	case req.Instance == "":
+		return "", utils.BadData(errors.New("esb or instance is required"))
+	}
+
+	if info == nil {
+		return "", errors.Errorf("no instance info for env %v", stage)
+	}
+
+	ins := info.GetInstance(types.DeployKey(fmt.Sprintf("java/%v", name)), req.Instance)
+	if ins == nil {
+		return "", utils.BadData(errors.Errorf("instance %v of %v not found", req.Instance, name))
+	}
+	if len(ins.Listening) != 1 {
+		return "", utils.BadData(errors.Errorf("instance %v is listening on %d addresses, probe esb instead", req.Instance, len(ins.Listening)))
+	}
+	addr := ins.Listening[0]
+	return fmt.Sprintf("http://%v:%v", addr.IP, addr.Port), nil
 }
@@ -0,0 +1,213 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package java
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"we.com/dolphin/api/utils"
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/controllers/java/router"
+	"we.com/dolphin/types"
+)
+
+const (
+	envName  = "env"
+	nameName = "name"
+)
+
+var (
+	lock     sync.RWMutex
+	backends = map[types.Stage]discovery.Backend{}
+)
+
+// SetBackend  set discovery backend of stage, which is used to read and write routes
+func SetBackend(stage types.Stage, b discovery.Backend) {
+	lock.Lock()
+	defer lock.Unlock()
+	if b == nil {
+		delete(backends, stage)
+		return
+	}
+	backends[stage] = b
+}
+
+func getBackend(r *http.Request) (discovery.Backend, types.DeployName, error) {
+	vars := mux.Vars(r)
+	stage, err := types.ParseStage(vars[envName])
+	if err != nil {
+		return nil, "", utils.BadData(errors.Wrap(err, "parse env"))
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+	m, ok := backends[stage]
+	if !ok {
+		return nil, "", errors.Errorf("no discovery backend for env %v", stage)
+	}
+	return m, types.DeployName(vars[nameName]), nil
+}
+
+// routeReq  route content to validate, diff or update
+type routeReq struct {
+	Content string `json:"content"`
+	// Version  node version the content is based on, update fails if the node has been changed,
+	// it is required by update, new routes are created by post
+	Version *int64 `json:"version,omitempty"`
+}
+
+// routeDiff  diff from current route to posted one
+type routeDiff struct {
+	Version int64         `json:"version"`
+	Diff    []string      `json:"diff"`
+	Issues  router.Issues `json:"issues,omitempty"`
+}
+
+// routeLint  posted route, and issues found by lint
+type routeLint struct {
+	Config *router.RouteCfg `json:"config"`
+	Issues router.Issues    `json:"issues"`
+}
+
+func receiveRoute(r *http.Request, m discovery.Backend, name types.DeployName) (*routeReq, *router.RouteCfg, error) {
+	req := routeReq{}
+	if err := utils.Receive(r, &req); err != nil {
+		return nil, nil, utils.BadData(err)
+	}
+
+	rc, err := m.ParseRoute(name, req.Content)
+	if err != nil {
+		return nil, nil, utils.BadData(errors.Wrap(err, "invalid route"))
+	}
+	return &req, rc, nil
+}
+
+// lintRoute  routes with lint errors are bad data
+func lintRoute(m discovery.Backend, name types.DeployName, rc *router.RouteCfg) (router.Issues, error) {
+	issues, err := m.LintRoute(name, rc)
+	if err != nil {
+		return nil, err
+	}
+	if err := issues.Err(); err != nil {
+		return issues, utils.BadData(err)
+	}
+	return issues, nil
+}
+
+// /route/{env}/{name}
+func getServiceRoute(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	m, name, err := getBackend(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetRouteNode(name)
+}
+
+// /route/{env}/{name}/validate
+func validateServiceRoute(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	m, name, err := getBackend(r)
+	if err != nil {
+		return nil, err
+	}
+
+	_, rc, err := receiveRoute(r, m, name)
+	if err != nil {
+		return nil, err
+	}
+
+	issues, err := lintRoute(m, name, rc)
+	if err != nil {
+		return nil, err
+	}
+	return &routeLint{Config: rc, Issues: issues}, nil
+}
+
+// /route/{env}/{name}/diff
+func diffServiceRoute(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	m, name, err := getBackend(r)
+	if err != nil {
+		return nil, err
+	}
+
+	_, rc, err := receiveRoute(r, m, name)
+	if err != nil {
+		return nil, err
+	}
+
+	issues, err := lintRoute(m, name, rc)
+	if err != nil {
+		return nil, err
+	}
+
+	// diff of a new route is against an empty one
+	cur, err := m.GetRouteNode(name)
+	if err != nil && errors.Cause(err) != discovery.ErrRouteNotExist {
+		return nil, err
+	}
+
+	return &routeDiff{
+		Version: cur.Version,
+		Diff:    router.Diff(cur.Config, rc),
+		Issues:  issues,
+	}, nil
+}
+
+// writeErr  map errors of route writes to status codes
+func writeErr(err error) error {
+	switch errors.Cause(err) {
+	case discovery.ErrRouteConflict, discovery.ErrRouteExist:
+		return utils.Conflict(err)
+	}
+	if _, ok := errors.Cause(err).(*router.LintError); ok {
+		return utils.BadData(err)
+	}
+	return err
+}
+
+// /route/{env}/{name}  create a new route
+func createServiceRoute(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	m, name, err := getBackend(r)
+	if err != nil {
+		return nil, err
+	}
+
+	_, rc, err := receiveRoute(r, m, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := m.CreateRouteConfig(name, rc)
+	if err != nil {
+		return nil, writeErr(err)
+	}
+	return ret, nil
+}
+
+// /route/{env}/{name}  update an existing route
+func setServiceRoute(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	m, name, err := getBackend(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req, rc, err := receiveRoute(r, m, name)
+	if err != nil {
+		return nil, err
+	}
+	if req.Version == nil {
+		return nil, utils.BadData(errors.New("version of the route to update is required"))
+	}
+
+	ret, err := m.UpdateRouteConfig(name, rc, *req.Version)
+	if err != nil {
+		return nil, writeErr(err)
+	}
+	return ret, nil
+}
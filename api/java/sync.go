@@ -0,0 +1,75 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package java
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"we.com/dolphin/api/utils"
+	"we.com/dolphin/controllers/java/zk"
+)
+
+// getZKManager  only zookeeper backends are synced with etcd
+func getZKManager(r *http.Request) (zk.Manager, error) {
+	b, _, err := getBackend(r)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := b.(zk.Manager)
+	if !ok {
+		return nil, utils.NotAllowed(errors.Errorf("discovery backend of env %v is not synced with etcd", mux.Vars(r)[envName]))
+	}
+	return m, nil
+}
+
+// /sync/{env}/report
+func syncReport(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	m, err := getZKManager(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.SyncReport()
+}
+
+// /sync/{env}/conflicts
+func syncConflicts(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	m, err := getZKManager(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Conflicts()
+}
+
+// /sync/{env}/resolve?path={zkPath}&use={zk|etcd}
+func resolveSyncConflict(w http.ResponseWriter, r *http.Request) (utils.Model, error) {
+	m, err := getZKManager(r)
+	if err != nil {
+		return nil, err
+	}
+
+	q := r.URL.Query()
+	path := q.Get("path")
+	if path == "" {
+		return nil, utils.BadData(errors.New("zk path is required"))
+	}
+
+	use := zk.SyncSide(q.Get("use"))
+	switch use {
+	case zk.SideZK, zk.SideEtcd:
+	default:
+		return nil, utils.BadData(errors.Errorf("use should be %v or %v, got %q", zk.SideZK, zk.SideEtcd, use))
+	}
+
+	err = m.ResolveConflict(path, use)
+	if errors.Cause(err) == zk.ErrNoConflict {
+		return nil, utils.BadData(err)
+	}
+	return nil, err
+}
@@ -21,6 +21,7 @@ const (
 	errorInternal             = "server_error"
 	errorBadData              = "bad_data"
 	errorPermission           = "not_allowed"
+	errorConflict             = "conflict"
 )
 
 type apiError struct {
@@ -61,6 +62,17 @@ func NotAllowed(err error) error {
 	}
 }
 
+// Conflict create a new Conflict error, the resource has been changed by others
+func Conflict(err error) error {
+	if err == nil {
+		return nil
+	}
+	return apiError{
+		typ: errorConflict,
+		err: err,
+	}
+}
+
 func (ae apiError) Error() string {
 	return ae.err.Error()
 }
@@ -79,9 +91,24 @@ type response struct {
 	Error     string      `json:"error,omitempty"`
 }
 
+// accepted  data of a request which is accepted, and processed in background
+type accepted struct {
+	data Model
+}
+
+// Accepted  respond data with status 202 Accepted, for requests processed in background
+func Accepted(data Model) Model {
+	return accepted{data: data}
+}
+
 func respond(w http.ResponseWriter, data interface{}, callback string) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
+	if a, ok := data.(accepted); ok {
+		w.WriteHeader(http.StatusAccepted)
+		data = a.data
+	} else {
+		w.WriteHeader(200)
+	}
 
 	b, err := json.Marshal(&response{
 		Status: statusSuccess,
@@ -137,6 +164,8 @@ func respondError(w http.ResponseWriter, apiErr apiError, data interface{}, call
 		w.WriteHeader(http.StatusInternalServerError)
 	case errorPermission:
 		w.WriteHeader(http.StatusUnauthorized)
+	case errorConflict:
+		w.WriteHeader(http.StatusConflict)
 	default:
 		panic(fmt.Sprintf("unknown error type %q", apiErr))
 	}
@@ -0,0 +1,63 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var server string
+
+// response  reply of dolphin server api
+type response struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ErrorType string          `json:"errorType,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// callAPI  send in as json to path of server, and decode data of response into out
+func callAPI(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	url := strings.TrimSuffix(server, "/") + path
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	ret := response{}
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return errors.Wrapf(err, "decode response of %v, status: %v", url, resp.Status)
+	}
+	if ret.Status != "success" {
+		return errors.Errorf("%v %v: %v", ret.ErrorType, url, ret.Error)
+	}
+
+	if out == nil || len(ret.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(ret.Data, out)
+}
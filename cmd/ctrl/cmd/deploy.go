@@ -7,7 +7,11 @@ package cmd
 
 import (
 	"fmt"
+	"net/http"
 	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
@@ -18,13 +22,21 @@ import (
 var (
 	env       string
 	deployKey string
+
+	watchStatus bool
 )
 
 func init() {
+	deployStatus.Flags().BoolVarP(&watchStatus, "watch", "w", false, "keep watching until deploys on all hosts finish")
+
 	cmdDeploy.AddCommand(deployAdd)
 	cmdDeploy.AddCommand(deployDelete)
 	cmdDeploy.AddCommand(deployEdit)
 	cmdDeploy.AddCommand(deployList)
+	cmdDeploy.AddCommand(deployPlan)
+	cmdDeploy.AddCommand(deployStatus)
+	cmdDeploy.AddCommand(deployBackups)
+	cmdDeploy.AddCommand(deployRestore)
 }
 
 var cmdDeploy = &cobra.Command{
@@ -96,3 +108,142 @@ var deployList = &cobra.Command{
 
 	},
 }
+
+var deployPlan = &cobra.Command{
+	Use:   "plan <env> <cfg.yml>",
+	Short: "show host config changes of a deploy config, without applying it",
+	Long:  `show host config changes of a deploy config, without applying it`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := os.Open(args[1])
+		if err != nil {
+			glog.Errorf("open %v err: %v", args[1], err)
+			return
+		}
+		defer file.Close()
+
+		dc := types.DeployConfig{}
+		decode := yaml.NewYAMLOrJSONDecoder(file, 4)
+		if err = decode.Decode(&dc); err != nil {
+			glog.Errorf("decode config file err: %v", err)
+			return
+		}
+
+		path := fmt.Sprintf("/deployconfig/%v/%v/%v/plan", args[0], dc.Type, dc.Name)
+		plan := types.DeployPlan{}
+		if err := callAPI(http.MethodPost, path, &dc, &plan); err != nil {
+			glog.Errorf("plan %v/%v err: %v", dc.Type, dc.Name, err)
+			return
+		}
+
+		printPlan(&plan)
+	},
+}
+
+var deployStatus = &cobra.Command{
+	Use:   "status <env> <deployKey>",
+	Short: "show deploy phase of a deployment on every host",
+	Long:  `show deploy phase of a deployment on every host`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := fmt.Sprintf("/deployconfig/%v/%v/status", args[0], args[1])
+		for {
+			ds := []*types.Deployment{}
+			if err := callAPI(http.MethodGet, path, nil, &ds); err != nil {
+				glog.Errorf("status of %v err: %v", args[1], err)
+				return
+			}
+
+			if printDeployStatus(ds) || !watchStatus {
+				return
+			}
+			time.Sleep(2 * time.Second)
+			fmt.Println()
+		}
+	},
+}
+
+// printDeployStatus  returns true if deploys on all hosts are finished
+func printDeployStatus(ds []*types.Deployment) bool {
+	finished := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tHOSTNAME\tVERSION\tPHASE\tUPDATED\tMESSAGE")
+	for _, d := range ds {
+		phase := d.Status.DeployPhase
+		if phase != types.PhaseDone && phase != types.PhaseFailed {
+			finished = false
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", d.Host, d.HostName, d.Version, phase,
+			d.UpdateTime.Local().Format("01-02 15:04:05"), d.Status.Message)
+	}
+	w.Flush()
+	return finished
+}
+
+var deployBackups = &cobra.Command{
+	Use:   "backups <env> <deployKey>",
+	Short: "list backups of a deployment kept on every host",
+	Long:  `list backups of a deployment kept on every host`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := fmt.Sprintf("/deployconfig/%v/%v/backups", args[0], args[1])
+		bs := []types.DeployBackup{}
+		if err := callAPI(http.MethodGet, path, nil, &bs); err != nil {
+			glog.Errorf("backups of %v err: %v", args[1], err)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tKEY\tVERSION\tTIME")
+		for _, b := range bs {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", b.Host, b.Key, b.Version, b.Time.Local().Format("01-02 15:04:05"))
+		}
+		w.Flush()
+	},
+}
+
+var deployRestore = &cobra.Command{
+	Use:   "restore <env> <deployKey> <host> <backupKey>",
+	Short: "restore a deployment on a host to one of its backups",
+	Long:  `restore a deployment on a host to one of its backups`,
+	Args:  cobra.MinimumNArgs(4),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := fmt.Sprintf("/deployconfig/%v/%v/backups/%v/%v/restore", args[0], args[1], args[2], args[3])
+		req := types.DeployRestore{}
+		if err := callAPI(http.MethodPost, path, nil, &req); err != nil {
+			glog.Errorf("restore %v on %v err: %v", args[1], args[2], err)
+			return
+		}
+		fmt.Printf("restore of %v on %v to %v requested at %v\n", args[1], args[2], req.Key,
+			req.RequestTime.Local().Format("01-02 15:04:05"))
+	},
+}
+
+func printPlan(plan *types.DeployPlan) {
+	fmt.Printf("deployment: %v, version: %v, policy: %v\n", plan.Key, plan.Version, plan.Policy)
+	if len(plan.Hosts) == 0 {
+		fmt.Println("no change")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tACTION\tBEFORE\tAFTER\tLEGACY TO STOP")
+	for _, hp := range plan.Hosts {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", hp.HostID, hp.Action,
+			formatVersions(hp.Before.Info), formatVersions(hp.After.Info), formatVersions(hp.Legacy))
+	}
+	w.Flush()
+}
+
+func formatVersions(info map[types.DeployVer]int) string {
+	if len(info) == 0 {
+		return "-"
+	}
+
+	vers := make([]string, 0, len(info))
+	for v, n := range info {
+		vers = append(vers, fmt.Sprintf("%v:%d", v, n))
+	}
+	sort.Strings(vers)
+	return fmt.Sprint(vers)
+}
@@ -6,13 +6,69 @@ Sniperkit-Bot
 package cmd
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/golang/glog"
 	"github.com/spf13/cobra"
+	"we.com/dolphin/types"
 )
 
+var cordonReason string
+
+func init() {
+	hostCordon.Flags().StringVar(&cordonReason, "reason", "", "why the host is cordoned")
+
+	cmdHost.AddCommand(hostCordon)
+	cmdHost.AddCommand(hostUncordon)
+	cmdHost.AddCommand(hostDrain)
+}
+
 var cmdHost = &cobra.Command{
 	Use:   "host",
-	Short: "manipulate deploy specific infos",
-	Long:  `manipulate deploy specific infos`,
+	Short: "manipulate hosts",
+	Long:  `manipulate hosts`,
 	Args:  cobra.MinimumNArgs(1),
 	Run:   nil,
 }
+
+var hostCordon = &cobra.Command{
+	Use:   "cordon <env> <hostID>",
+	Short: "mark host unschedulable",
+	Long:  `mark host unschedulable, running instances are not affected`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := fmt.Sprintf("/host/%v/%v/cordon?reason=%v", args[0], args[1], url.QueryEscape(cordonReason))
+		hostAction(path)
+	},
+}
+
+var hostUncordon = &cobra.Command{
+	Use:   "uncordon <env> <hostID>",
+	Short: "mark host schedulable",
+	Long:  `mark host schedulable`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		hostAction(fmt.Sprintf("/host/%v/%v/uncordon", args[0], args[1]))
+	},
+}
+
+var hostDrain = &cobra.Command{
+	Use:   "drain <env> <hostID>",
+	Short: "cordon host, and move all instances on it to other hosts",
+	Long:  `cordon host, and move all instances on it to other hosts, instances are moved in background as update policy of each deployment says`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		hostAction(fmt.Sprintf("/host/%v/%v/drain", args[0], args[1]))
+	},
+}
+
+func hostAction(path string) {
+	hc := types.HostConfig{}
+	if err := callAPI(http.MethodPost, path, nil, &hc); err != nil {
+		glog.Errorf("%v err: %v", path, err)
+		return
+	}
+	fmt.Printf("host: %v, unschedulable: %v, reason: %v\n", hc.HostName, hc.Unschedulable, hc.CordonReason)
+}
@@ -58,12 +58,15 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dolphin.yaml)")
+	RootCmd.PersistentFlags().StringVar(&server, "server", "http://127.0.0.1:8989", "address of dolphin server")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	RootCmd.AddCommand(cmdDeploy)
+	RootCmd.AddCommand(cmdHost)
+	RootCmd.AddCommand(cmdRoute)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -0,0 +1,159 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+	"we.com/dolphin/controllers/java/router"
+)
+
+func init() {
+	cmdRoute.AddCommand(routeGet)
+	cmdRoute.AddCommand(routeLint)
+	cmdRoute.AddCommand(routeSet)
+	cmdRoute.AddCommand(routeCreate)
+}
+
+type routeReq struct {
+	Content string `json:"content"`
+	Version *int64 `json:"version,omitempty"`
+}
+
+type routeNode struct {
+	Version int64         `json:"version"`
+	Content string        `json:"content"`
+	Diff    []string      `json:"diff"`
+	Issues  router.Issues `json:"issues"`
+}
+
+var cmdRoute = &cobra.Command{
+	Use:   "route",
+	Short: "manipulate java service routes",
+	Long:  `manipulate java service routes`,
+	Args:  cobra.MinimumNArgs(1),
+	Run:   nil,
+}
+
+var routeGet = &cobra.Command{
+	Use:   "get <env> <name>",
+	Short: "show route of a java service",
+	Long:  `show route of a java service, and version of its zk node`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ret := routeNode{}
+		if err := callAPI(http.MethodGet, routePath(args[0], args[1], ""), nil, &ret); err != nil {
+			glog.Errorf("get route err: %v", err)
+			return
+		}
+		fmt.Printf("# version: %d\n%s", ret.Version, ret.Content)
+	},
+}
+
+var routeLint = &cobra.Command{
+	Use:   "lint <env> <name> <route file>",
+	Short: "check route file against live instances",
+	Long:  `check route file against live instances: unreachable destinations, shadowed rules, unknown match keys and duplicates`,
+	Args:  cobra.MinimumNArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := readRoute(args[2])
+		if err != nil {
+			glog.Errorf("read route file err: %v", err)
+			return
+		}
+
+		ret := routeNode{}
+		if err := callAPI(http.MethodPost, routePath(args[0], args[1], "validate"), req, &ret); err != nil {
+			glog.Errorf("lint route err: %v", err)
+			return
+		}
+		printIssues(ret.Issues)
+	},
+}
+
+var routeSet = &cobra.Command{
+	Use:   "set <env> <name> <route file>",
+	Short: "lint route file, and write it to zk",
+	Long:  `lint route file, and write it to zk if there is no lint error, the diff to current route is shown`,
+	Args:  cobra.MinimumNArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := readRoute(args[2])
+		if err != nil {
+			glog.Errorf("read route file err: %v", err)
+			return
+		}
+
+		// diff lints the route, and returns version of current zk node
+		diff := routeNode{}
+		if err := callAPI(http.MethodPost, routePath(args[0], args[1], "diff"), req, &diff); err != nil {
+			glog.Errorf("lint route err: %v", err)
+			return
+		}
+		printIssues(diff.Issues)
+		for _, l := range diff.Diff {
+			fmt.Println(l)
+		}
+
+		req.Version = &diff.Version
+		ret := routeNode{}
+		if err := callAPI(http.MethodPut, routePath(args[0], args[1], ""), req, &ret); err != nil {
+			glog.Errorf("set route err: %v", err)
+			return
+		}
+		fmt.Printf("route updated, version: %d\n", ret.Version)
+	},
+}
+
+var routeCreate = &cobra.Command{
+	Use:   "create <env> <name> <route file>",
+	Short: "lint route file, and create it as a new route",
+	Long:  `lint route file, and create it as a new route, it fails if the service has a route already`,
+	Args:  cobra.MinimumNArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := readRoute(args[2])
+		if err != nil {
+			glog.Errorf("read route file err: %v", err)
+			return
+		}
+
+		ret := routeNode{}
+		if err := callAPI(http.MethodPost, routePath(args[0], args[1], ""), req, &ret); err != nil {
+			glog.Errorf("create route err: %v", err)
+			return
+		}
+		printIssues(ret.Issues)
+		fmt.Printf("route created, version: %d\n", ret.Version)
+	},
+}
+
+func routePath(env, name, action string) string {
+	if action == "" {
+		return fmt.Sprintf("/java/route/%v/%v", env, name)
+	}
+	return fmt.Sprintf("/java/route/%v/%v/%v", env, name, action)
+}
+
+func readRoute(file string) (*routeReq, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return &routeReq{Content: string(data)}, nil
+}
+
+func printIssues(issues router.Issues) {
+	if len(issues) == 0 {
+		fmt.Println("no issue found")
+		return
+	}
+	for _, i := range issues {
+		fmt.Println(i)
+	}
+}
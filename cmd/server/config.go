@@ -13,12 +13,15 @@ import (
 	"we.com/dolphin/controllers/java/zk/types"
 	"we.com/dolphin/registry/generic"
 	"we.com/dolphin/report"
+	dtypes "we.com/dolphin/types"
 )
 
 type config struct {
 	Etcd     clientv3.Config  `json:"etcd,omitempty"`
 	InfluxDB *report.InfluxDB `json:"influxDB,omitempty"`
 	ZKs      types.Config     `json:"zKs,omitempty"`
+	// ImageVerify  how agents verify images before deploy, published to every env
+	ImageVerify *dtypes.ImageVerify `json:"imageVerify,omitempty"`
 }
 
 func (c *config) UnmarshalJSON(dat []byte) error {
@@ -26,6 +29,8 @@ func (c *config) UnmarshalJSON(dat []byte) error {
 		Etcd     interface{}      `json:"etcd,omitempty"`
 		InfluxDB *report.InfluxDB `json:"influxDB,omitempty"`
 		ZKs      types.Config     `json:"zKs,omitempty"`
+
+		ImageVerify *dtypes.ImageVerify `json:"imageVerify,omitempty"`
 	}
 
 	var t tmp
@@ -36,6 +41,7 @@ func (c *config) UnmarshalJSON(dat []byte) error {
 
 	c.InfluxDB = t.InfluxDB
 	c.ZKs = t.ZKs
+	c.ImageVerify = t.ImageVerify
 
 	jstr, err := json.Marshal(t.Etcd)
 	if err != nil {
@@ -24,6 +24,10 @@ import (
 	"we.com/dolphin/api/deploy"
 	"we.com/dolphin/api/host"
 	"we.com/dolphin/api/java"
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/controllers/java/discovery/etcd"
+	"we.com/dolphin/controllers/java/service"
+	"we.com/dolphin/controllers/java/traffic"
 	"we.com/dolphin/controllers/java/zk"
 	zktypes "we.com/dolphin/controllers/java/zk/types"
 	"we.com/dolphin/controllers/scheduler"
@@ -31,6 +35,9 @@ import (
 	"we.com/dolphin/controllers/types/impl"
 	"we.com/dolphin/logger"
 	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/registry/instances"
+	rjava "we.com/dolphin/registry/java"
+	"we.com/dolphin/report"
 	"we.com/dolphin/types"
 	_ "we.com/dolphin/types/all"
 	"we.com/jiabiao/common/yaml"
@@ -50,7 +57,8 @@ type stageInfo struct {
 	hcManager ctypes.HostConfigManager
 	dcManager ctypes.DeployConfigManager
 	scheduler scheduler.Manager
-	zkSyner   zk.Manager
+	javaSvc   service.Manager
+	zkSyner   discovery.Backend
 	ctx       context.Context
 	df        context.CancelFunc
 }
@@ -65,9 +73,12 @@ func (si *stageInfo) destroy() error {
 		si.zkSyner.Destory()
 	}
 
-	// TODO: check
 	if si.scheduler != nil {
+		si.scheduler.Stop()
+	}
 
+	if si.javaSvc != nil {
+		si.javaSvc.Stop()
 	}
 
 	if si.hcManager != nil {
@@ -77,11 +88,10 @@ func (si *stageInfo) destroy() error {
 	return nil
 }
 
-func newStageInfo(env types.Stage, zkcfg *zktypes.EnvConfig, pi zk.PathInfor) (*stageInfo, error) {
+func newStageInfo(env types.Stage, zkcfg *zktypes.EnvConfig, pi zk.PathInfor, influx *report.InfluxDB) (*stageInfo, error) {
 	lease := time.Hour
-	m, err := zk.NewManager(zkcfg, pi)
+	m, err := newBackend(env, zkcfg, pi)
 	if err != nil {
-		err = errors.Wrap(err, "create zk sync manager")
 		return nil, err
 	}
 
@@ -125,11 +135,65 @@ func newStageInfo(env types.Stage, zkcfg *zktypes.EnvConfig, pi zk.PathInfor) (*
 		err = errors.Wrap(err, "create scheduler manager")
 		return nil, err
 	}
+	ret.scheduler = sm
+
+	js, err := newJavaService(ctx, env, zkcfg, insInfo, m, influx)
+	if err != nil {
+		return nil, err
+	}
+	ret.javaSvc = js
+	sm.SetHealthChecker(js)
+	sm.SetTrafficShifter(traffic.NewShifter(m))
+	deploy.SetScheduler(env, sm)
+	host.SetScheduler(env, sm)
+	java.SetBackend(env, m)
+	java.SetInstanceInfor(env, insInfo)
+	java.SetESBChecker(env, js)
 
 	envInfos[env] = ret
 	return ret, nil
 }
 
+// newJavaService  start probing java deployments of env, it judges canaries and traffic shifting
+func newJavaService(ctx context.Context, env types.Stage, zkcfg *zktypes.EnvConfig, insInfo ctypes.InstanceInfor,
+	backend discovery.Backend, influx *report.InfluxDB) (service.Manager, error) {
+	provider, err := rjava.NewDIProvider(env)
+	if err != nil {
+		return nil, errors.Wrap(err, "create java probe interface provider")
+	}
+
+	js, err := service.NewManager(env, provider, insInfo, backend, influx)
+	if err != nil {
+		return nil, errors.Wrap(err, "create java service manager")
+	}
+	if err := js.SetESBs(zkcfg.ESBs); err != nil {
+		return nil, err
+	}
+	js.Start(ctx)
+	go js.RunGC(ctx, zkcfg.GetGCInterval())
+	go js.RunSLO(ctx, zkcfg.GetSLOInterval())
+	return js, nil
+}
+
+// newBackend  service discovery backend of env, zk by default
+func newBackend(env types.Stage, zkcfg *zktypes.EnvConfig, pi zk.PathInfor) (discovery.Backend, error) {
+	if zkcfg.Backend == zktypes.BackendEtcd {
+		m, err := etcd.NewBackend(env)
+		return m, errors.Wrap(err, "create etcd discovery backend")
+	}
+
+	m, err := zk.NewManager(zkcfg, pi)
+	return m, errors.Wrap(err, "create zk sync manager")
+}
+
+func publishImageVerify(env types.Stage, iv *types.ImageVerify) error {
+	ir, err := instances.NewRegistry(env)
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(ir.SetImageVerify(iv), "publish image verify of %v", env)
+}
+
 func destroy() error {
 	for _, m := range envInfos {
 		m.zkSyner.Destory()
@@ -155,6 +219,11 @@ func reload() error {
 	generic.SetEtcdConfig(cfg.Etcd)
 
 	// influxdb
+	if cfg.InfluxDB != nil {
+		if err := cfg.InfluxDB.Connect(); err != nil {
+			return errors.Wrap(err, "connect influxdb")
+		}
+	}
 
 	// zk
 	pi, err := zk.NewSimplePathInfo()
@@ -165,7 +234,12 @@ func reload() error {
 	var merr *multierror.Error
 
 	for env, zkCfg := range cfg.ZKs.Envs {
-		if _, err := newStageInfo(env, &zkCfg, pi); err != nil {
+		if _, err := newStageInfo(env, &zkCfg, pi, cfg.InfluxDB); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+
+		// image verify, agents read it before deploy
+		if err := publishImageVerify(env, cfg.ImageVerify); err != nil {
 			merr = multierror.Append(merr, err)
 		}
 	}
@@ -0,0 +1,96 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+// Package discovery where java services register their instances, and read their routes.
+// zookeeper backend is provided by package zk, and etcd native backend by package discovery/etcd
+package discovery
+
+import (
+	"github.com/pkg/errors"
+	"we.com/dolphin/controllers/java/router"
+	"we.com/dolphin/types"
+)
+
+var (
+	// ErrRouteConflict  route has been changed since it is read
+	ErrRouteConflict = errors.New("discovery: route has been changed by others, please reload and try again")
+	// ErrRouteNotExist  service has no route node
+	ErrRouteNotExist = errors.New("discovery: route not exist")
+	// ErrRouteExist  route to create has been created already
+	ErrRouteExist = errors.New("discovery: route exists, please update it with its version")
+	// ErrInstanceChanged  instance node has been reregistered since it is read
+	ErrInstanceChanged = errors.New("discovery: instance has been reregistered")
+)
+
+// RouteNode  route config of a deployment, and version of the node it is stored in
+type RouteNode struct {
+	Name    types.DeployName `json:"name"`
+	Path    string           `json:"path"`
+	Version int64            `json:"version"`
+	Content string           `json:"content"`
+	Config  *router.RouteCfg `json:"config,omitempty"`
+	Issues  router.Issues    `json:"issues,omitempty"`
+}
+
+// Backend  service discovery backend of an env
+type Backend interface {
+	ListDeployment() []types.DeployName
+	GetInstanceList(name types.DeployName) ([]*router.ServiceNode, error)
+	GetRouteConfig(name types.DeployName) (*router.RouteCfg, error)
+	SetRouteConfig(name types.DeployName, rc *router.RouteCfg) error
+	// GetRouteNode read route from backend, with version of the node,
+	// ErrRouteNotExist is returned with an empty route of version 0 if there is no route node
+	GetRouteNode(name types.DeployName) (*RouteNode, error)
+	// CreateRouteConfig  create route of name, ErrRouteExist is returned if it exists
+	CreateRouteConfig(name types.DeployName, rc *router.RouteCfg) (*RouteNode, error)
+	// UpdateRouteConfig  write route only if node version is still version, or ErrRouteConflict is returned
+	UpdateRouteConfig(name types.DeployName, rc *router.RouteCfg, version int64) (*RouteNode, error)
+	// ParseRoute parse and validate route content of name
+	ParseRoute(name types.DeployName, content string) (*router.RouteCfg, error)
+	// LintRoute check route against live instances, routes with lint errors are refused by every write
+	LintRoute(name types.DeployName, rc *router.RouteCfg) (router.Issues, error)
+	// RemoveInstance  remove registration of node, only if it is still the same instance,
+	// or ErrInstanceChanged is returned
+	RemoveInstance(name types.DeployName, node *router.ServiceNode) error
+	Destory() error
+}
+
+// SameInstance  if a and b are registrations of the same process
+func SameInstance(a, b *router.ServiceNode) bool {
+	return a.NodeName == b.NodeName && a.Host == b.Host && a.Pid == b.Pid && a.StartTime.Equal(b.StartTime)
+}
+
+// LintRoute  lint rc against live instances of name in b
+func LintRoute(b Backend, name types.DeployName, rc *router.RouteCfg) (router.Issues, error) {
+	nodes, err := b.GetInstanceList(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "discovery: list instances of %v", name)
+	}
+	return router.Lint(rc, nodes), nil
+}
+
+// CheckWrite  routes with lint errors are not allowed to write to any backend
+func CheckWrite(b Backend, name types.DeployName, rc *router.RouteCfg) (router.Issues, error) {
+	issues, err := b.LintRoute(name, rc)
+	if err != nil {
+		return nil, err
+	}
+	if err := issues.Err(); err != nil {
+		return issues, errors.Wrapf(err, "discovery: route of %v", name)
+	}
+	return issues, nil
+}
+
+// ParseRoute  parse and validate route content
+func ParseRoute(content string, apiVersion string) (*router.RouteCfg, error) {
+	rc, err := router.Parse(content, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
@@ -0,0 +1,246 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+// Package etcd  discovery backend of java services which register in etcd,
+// instances are kept at {JavaDiscoveryInstanceDir}/{name}/{node}, and routes (api 4.0) at JavaDiscoveryRoutePath
+package etcd
+
+import (
+	"context"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/controllers/java/router"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+)
+
+type backend struct {
+	stage types.Stage
+}
+
+var _ discovery.Backend = &backend{}
+
+// NewBackend  create an etcd discovery backend of stage
+func NewBackend(stage types.Stage) (discovery.Backend, error) {
+	if _, err := generic.GetStoreInstance("", false); err != nil {
+		return nil, err
+	}
+	return &backend{stage: stage}, nil
+}
+
+func (b *backend) ListDeployment() []types.DeployName {
+	store, err := generic.GetStoreInstance(etcdkey.JavaDiscoveryInstanceDir(b.stage), false)
+	if err != nil {
+		glog.Errorf("discovery: %v list deployments: %v", b.stage, err)
+		return nil
+	}
+
+	keys, err := store.ListKeys(context.Background(), "")
+	if err != nil {
+		glog.Errorf("discovery: %v list deployments: %v", b.stage, err)
+		return nil
+	}
+
+	ret := make([]types.DeployName, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, types.DeployName(k))
+	}
+	return ret
+}
+
+func (b *backend) GetInstanceList(name types.DeployName) ([]*router.ServiceNode, error) {
+	store, err := generic.GetStoreInstance(etcdkey.JavaDiscoveryInstanceDir(b.stage)+string(name)+"/", false)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := map[string]router.ServiceNode{}
+	if err := store.List(context.Background(), "", generic.Everything, nodes); err != nil {
+		return nil, err
+	}
+
+	ret := make([]*router.ServiceNode, 0, len(nodes))
+	for k, v := range nodes {
+		n := v
+		n.NodeName = k
+		n.APIVersion = router.APIV4
+		ret = append(ret, &n)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].NodeName < ret[j].NodeName })
+	return ret, nil
+}
+
+func (b *backend) GetRouteConfig(name types.DeployName) (*router.RouteCfg, error) {
+	node, err := b.GetRouteNode(name)
+	if errors.Cause(err) == discovery.ErrRouteNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node.Config, nil
+}
+
+func (b *backend) SetRouteConfig(name types.DeployName, rc *router.RouteCfg) error {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return err
+	}
+
+	key := etcdkey.JavaDiscoveryRoutePath(b.stage, name)
+	if rc == nil {
+		err = store.Delete(context.Background(), key, nil)
+		if generic.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if _, err := b.checkWrite(name, rc); err != nil {
+		return err
+	}
+	return store.Update(context.Background(), key, []byte(rc.String()), nil, 0)
+}
+
+// GetRouteNode  a route not exist is returned as an empty route of version 0, with ErrRouteNotExist
+func (b *backend) GetRouteNode(name types.DeployName) (*discovery.RouteNode, error) {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return nil, err
+	}
+
+	key := etcdkey.JavaDiscoveryRoutePath(b.stage, name)
+	var dat []byte
+	ver, err := store.GetVersion(context.Background(), key, &dat)
+	if generic.IsNotFound(err) {
+		return &discovery.RouteNode{Name: name, Path: key}, errors.Wrapf(discovery.ErrRouteNotExist, "%v", name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "discovery: get route of %v", name)
+	}
+
+	ret := &discovery.RouteNode{
+		Name:    name,
+		Path:    key,
+		Version: ver,
+		Content: string(dat),
+	}
+
+	rc, err := router.Parse(ret.Content, router.APIV4)
+	if err != nil {
+		return ret, errors.Wrapf(err, "discovery: parse route of %v", name)
+	}
+	ret.Config = rc
+	return ret, nil
+}
+
+// CreateRouteConfig  create route of name, ErrRouteExist is returned if it exists
+func (b *backend) CreateRouteConfig(name types.DeployName, rc *router.RouteCfg) (*discovery.RouteNode, error) {
+	ret, err := b.writeRoute(name, rc, 0)
+	if errors.Cause(err) == discovery.ErrRouteConflict {
+		return nil, errors.Wrapf(discovery.ErrRouteExist, "%v", name)
+	}
+	return ret, err
+}
+
+// UpdateRouteConfig  update an existing route, whose mod revision is version
+func (b *backend) UpdateRouteConfig(name types.DeployName, rc *router.RouteCfg, version int64) (*discovery.RouteNode, error) {
+	if version <= 0 {
+		return nil, errors.Wrapf(discovery.ErrRouteConflict, "%v version %d", name, version)
+	}
+	return b.writeRoute(name, rc, version)
+}
+
+// writeRoute  write rc only if mod revision of the route is still version, 0 means it must not exist
+func (b *backend) writeRoute(name types.DeployName, rc *router.RouteCfg, version int64) (*discovery.RouteNode, error) {
+	issues, err := b.checkWrite(name, rc)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return nil, err
+	}
+
+	key := etcdkey.JavaDiscoveryRoutePath(b.stage, name)
+	content := rc.String()
+	nv, err := store.UpdateVersion(context.Background(), key, []byte(content), version)
+	if generic.IsTestFailed(err) {
+		return nil, errors.Wrapf(discovery.ErrRouteConflict, "%v version %d", name, version)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "discovery: set route of %v", name)
+	}
+
+	return &discovery.RouteNode{
+		Name:    name,
+		Path:    key,
+		Version: nv,
+		Content: content,
+		Config:  rc,
+		Issues:  issues,
+	}, nil
+}
+
+// checkWrite  only api 4.0 routes are supported
+func (b *backend) checkWrite(name types.DeployName, rc *router.RouteCfg) (router.Issues, error) {
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	if rc.APIVersion != router.APIV4 {
+		return nil, errors.Errorf("discovery: route of %v should be api %v, got %v", name, router.APIV4, rc.APIVersion)
+	}
+	return discovery.CheckWrite(b, name, rc)
+}
+
+func (b *backend) ParseRoute(name types.DeployName, content string) (*router.RouteCfg, error) {
+	return discovery.ParseRoute(content, router.APIV4)
+}
+
+func (b *backend) LintRoute(name types.DeployName, rc *router.RouteCfg) (router.Issues, error) {
+	return discovery.LintRoute(b, name, rc)
+}
+
+func (b *backend) RemoveInstance(name types.DeployName, node *router.ServiceNode) error {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return err
+	}
+
+	key := etcdkey.JavaDiscoveryInstanceDir(b.stage) + string(name) + "/" + node.NodeName
+	cur := router.ServiceNode{}
+	ver, err := store.GetVersion(context.Background(), key, &cur)
+	if generic.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "discovery: get instance %v of %v", node.NodeName, name)
+	}
+
+	cur.NodeName = node.NodeName
+	if !discovery.SameInstance(&cur, node) {
+		return errors.Wrapf(discovery.ErrInstanceChanged, "%v of %v", node.NodeName, name)
+	}
+
+	// the instance may reregister between the read and the delete, so the delete is conditional
+	glog.V(4).Infof("discovery: %v remove instance %v of %v, version %d", b.stage, node.NodeName, name, ver)
+	err = store.DeleteVersion(context.Background(), key, ver)
+	if generic.IsNotFound(err) {
+		return nil
+	}
+	if generic.IsTestFailed(err) {
+		return errors.Wrapf(discovery.ErrInstanceChanged, "%v of %v", node.NodeName, name)
+	}
+	return err
+}
+
+func (b *backend) Destory() error {
+	return nil
+}
@@ -0,0 +1,226 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package router
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// LintLevel  how serious an issue is, routes with errors are not written to zk
+type LintLevel string
+
+// LintKind  what is wrong with a route rule
+type LintKind string
+
+const (
+	LevelError   LintLevel = "error"
+	LevelWarning LintLevel = "warning"
+
+	// LintUnreachable  destination matches no live instance
+	LintUnreachable LintKind = "unreachable"
+	// LintShadowed  rule is overridden by an earlier rule
+	LintShadowed LintKind = "shadowed"
+	// LintUnknownKey  match key is not known by java services
+	LintUnknownKey LintKind = "unknownKey"
+	// LintDuplicate  rule is the same as an earlier rule
+	LintDuplicate LintKind = "duplicate"
+	// LintNoInstance  there is no live instance, reachability is not checked
+	LintNoInstance LintKind = "noInstance"
+)
+
+const (
+	KeyHost   = "host"
+	KeyMethod = "method"
+	KeyAlias  = "alias"
+	KeyPolicy = "policy"
+)
+
+var (
+	// known source and destination keys of each api version
+	srcKeys = map[string]map[string]bool{
+		APIV2: {},
+		APIV4: {KeyHost: true, KeyMethod: true},
+	}
+	dstKeys = map[string]map[string]bool{
+		APIV2: {KeyAlias: true, KeyPolicy: true, KeyVersion: true, KeyInstances: true},
+		APIV4: {KeyHost: true, KeyVersion: true, KeyInstances: true},
+	}
+)
+
+// Issue  a problem found by Lint, Rule is the 1 based index of the rule in route config, 0 for the whole config
+type Issue struct {
+	Rule    int       `json:"rule"`
+	Level   LintLevel `json:"level"`
+	Kind    LintKind  `json:"kind"`
+	Message string    `json:"message"`
+}
+
+func (i Issue) String() string {
+	if i.Rule == 0 {
+		return fmt.Sprintf("%v %v: %v", i.Level, i.Kind, i.Message)
+	}
+	return fmt.Sprintf("%v %v rule %d: %v", i.Level, i.Kind, i.Rule, i.Message)
+}
+
+// Issues  issues of a route config
+type Issues []Issue
+
+// Err  returns a *LintError if there are issues of level error
+func (is Issues) Err() error {
+	var errs Issues
+	for _, i := range is {
+		if i.Level == LevelError {
+			errs = append(errs, i)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &LintError{Issues: errs}
+}
+
+// LintError  route config has issues of level error
+type LintError struct {
+	Issues Issues
+}
+
+func (e *LintError) Error() string {
+	msgs := make([]string, 0, len(e.Issues))
+	for _, i := range e.Issues {
+		msgs = append(msgs, i.String())
+	}
+	return fmt.Sprintf("route lint failed: %v", strings.Join(msgs, "; "))
+}
+
+// Lint  check rc against live instances nodes: destinations that match no instance, rules overridden by
+// earlier ones, unknown match keys and duplicated rules. reachability is not checked if there is no instance
+func Lint(rc *RouteCfg, nodes []*ServiceNode) Issues {
+	ret := Issues{}
+	if rc == nil {
+		return ret
+	}
+
+	newIssue := func(rule int, level LintLevel, kind LintKind, format string, args ...interface{}) {
+		ret = append(ret, Issue{Rule: rule, Level: level, Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(nodes) == 0 {
+		newIssue(0, LevelWarning, LintNoInstance, "no live instance, reachability is not checked")
+	}
+
+	for i, item := range rc.RouteItems {
+		rule := i + 1
+		if item.Src.Key != "" && !srcKeys[rc.APIVersion][item.Src.Key] {
+			newIssue(rule, LevelError, LintUnknownKey, "unknown source key %q", item.Src.Key)
+		}
+		if !dstKeys[rc.APIVersion][item.Dst.Key] {
+			newIssue(rule, LevelError, LintUnknownKey, "unknown destination key %q", item.Dst.Key)
+		}
+
+		for j := 0; j < i; j++ {
+			prev := rc.RouteItems[j]
+			if itemEqual(prev, item) {
+				newIssue(rule, LevelWarning, LintDuplicate, "same as rule %d", j+1)
+				break
+			}
+			if prev.Dst.Key == item.Dst.Key && matchCovers(prev.Src, item.Src) {
+				newIssue(rule, LevelWarning, LintShadowed, "%v is already decided by rule %d", item.Dst.Key, j+1)
+				break
+			}
+		}
+
+		if len(nodes) > 0 && !reachable(item.Dst, nodes) {
+			newIssue(rule, LevelError, LintUnreachable, "%v%v%v matches no live instance",
+				item.Dst.Key, item.Dst.OP, strings.Join(item.Dst.Value, ","))
+		}
+	}
+
+	return ret
+}
+
+func itemEqual(a, b RouteItem) bool {
+	return matchEqual(a.Src, b.Src) && matchEqual(a.Dst, b.Dst)
+}
+
+func matchEqual(a, b Match) bool {
+	if a.Key != b.Key || a.OP != b.OP || len(a.Value) != len(b.Value) {
+		return false
+	}
+	for i := range a.Value {
+		if a.Value[i] != b.Value[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCovers  whether every request matched by b is also matched by a
+func matchCovers(a, b Match) bool {
+	if a.Key == "" {
+		return b.Key == ""
+	}
+	if a.Key != b.Key || a.OP != b.OP || len(b.Value) == 0 {
+		return false
+	}
+
+	switch a.OP {
+	case OPeq:
+		// every value of b is matched by a value of a
+		for _, bv := range b.Value {
+			if !matchAny(a.Value, bv) {
+				return false
+			}
+		}
+		return true
+	case OPne:
+		// every value excluded by b is excluded by a
+		for _, av := range a.Value {
+			if !matchAny(b.Value, av) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// matchAny  whether val matches any of patterns, patterns may contain '*'
+func matchAny(patterns []string, val string) bool {
+	for _, p := range patterns {
+		if p == val {
+			return true
+		}
+		if ok, _ := path.Match(p, val); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reachable  whether dst matches at least one of nodes, keys not about instances are always reachable
+func reachable(dst Match, nodes []*ServiceNode) bool {
+	var field func(n *ServiceNode) string
+	switch dst.Key {
+	case KeyVersion:
+		field = func(n *ServiceNode) string { return n.Version }
+	case KeyInstances:
+		field = func(n *ServiceNode) string { return n.NodeName }
+	case KeyHost:
+		field = func(n *ServiceNode) string { return n.Host }
+	default:
+		return true
+	}
+
+	for _, n := range nodes {
+		matched := matchAny(dst.Value, field(n))
+		if (dst.OP == OPeq) == matched {
+			return true
+		}
+	}
+	return false
+}
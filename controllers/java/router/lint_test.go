@@ -0,0 +1,106 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package router
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	nodes := []*ServiceNode{
+		{NodeName: "n1", Host: "10.0.0.1", Version: "14"},
+		{NodeName: "n2", Host: "10.0.0.2", Version: "14"},
+		{NodeName: "n3", Host: "10.0.0.3", Version: "15"},
+	}
+
+	type issue struct {
+		Rule int
+		Kind LintKind
+	}
+	tests := []struct {
+		name    string
+		content string
+		api     string
+		nodes   []*ServiceNode
+		want    []issue
+		wantErr bool
+	}{
+		{
+			name:    "ok",
+			content: "=> version=14\nhost=10.0.0.1 => version=15\nmethod=find* => instances=n1,n3",
+			api:     APIV4,
+			nodes:   nodes,
+		},
+		{
+			name:    "unreachable version",
+			content: "=> version=16",
+			api:     APIV4,
+			nodes:   nodes,
+			want:    []issue{{1, LintUnreachable}},
+			wantErr: true,
+		},
+		{
+			name:    "exclude all instances",
+			content: "=> host!=10.0.0.*",
+			api:     APIV4,
+			nodes:   nodes,
+			want:    []issue{{1, LintUnreachable}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown keys",
+			content: "app=a => zone=b",
+			api:     APIV4,
+			nodes:   nodes,
+			want:    []issue{{1, LintUnknownKey}, {1, LintUnknownKey}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate and shadowed",
+			content: "method=find*,get* => version=14\nmethod=find* => version=15\nmethod=find*,get* => version=14",
+			api:     APIV4,
+			nodes:   nodes,
+			want:    []issue{{2, LintShadowed}, {3, LintDuplicate}},
+		},
+		{
+			name:    "v2 overridden",
+			content: "policy=random\nversion=14\nversion=15",
+			api:     APIV2,
+			nodes:   nodes,
+			want:    []issue{{3, LintShadowed}},
+		},
+		{
+			name:    "no instance",
+			content: "=> version=16",
+			api:     APIV4,
+			want:    []issue{{0, LintNoInstance}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := Parse(tt.content, tt.api)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			issues := Lint(rc, tt.nodes)
+			got := []issue{}
+			for _, i := range issues {
+				got = append(got, issue{i.Rule, i.Kind})
+			}
+			if tt.want == nil {
+				tt.want = []issue{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lint() = %v, want %v", issues, tt.want)
+			}
+			if err := issues.Err(); (err != nil) != tt.wantErr {
+				t.Errorf("Issues.Err() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
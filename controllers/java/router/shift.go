@@ -0,0 +1,99 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package router
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// KeyVersion route to all instances of a version
+	KeyVersion = "version"
+	// KeyInstances route to listed instances
+	KeyInstances = "instances"
+)
+
+// ShiftVersion  returns a copy of rc, which routes about percent of traffic to instances of version to,
+// and the rest to instances in fromNodes. traffic is balanced among listed instances, so percent is
+// approximated by num of instances of each version, the actual percent is returned.
+// default destination rules (version and instances without source match) are replaced, others are kept
+func ShiftVersion(rc *RouteCfg, fromNodes, toNodes []string, to string, percent float64) (*RouteCfg, float64, error) {
+	if rc == nil {
+		return nil, 0, fmt.Errorf("route config is nil")
+	}
+	if percent <= 0 || percent > 1 {
+		return nil, 0, fmt.Errorf("invalid percent %v, must between (0, 1]", percent)
+	}
+
+	ret := &RouteCfg{
+		APIVersion: rc.APIVersion,
+		RouteItems: make([]RouteItem, 0, len(rc.RouteItems)+1),
+	}
+	for _, item := range rc.RouteItems {
+		if item.Src.Key == "" && (item.Dst.Key == KeyVersion || item.Dst.Key == KeyInstances) {
+			continue
+		}
+		ret.RouteItems = append(ret.RouteItems, item)
+	}
+
+	if percent >= 1 || len(fromNodes) == 0 {
+		ret.RouteItems = append(ret.RouteItems, RouteItem{
+			Dst: Match{Key: KeyVersion, OP: OPeq, Value: []string{to}},
+		})
+		return ret, 1, nil
+	}
+
+	if len(toNodes) == 0 {
+		return nil, 0, fmt.Errorf("no instance of version %v", to)
+	}
+
+	// k / (k + len(fromNodes)) = percent
+	k := int(math.Floor(percent*float64(len(fromNodes))/(1-percent) + 0.5))
+	if k < 1 {
+		k = 1
+	}
+	if k > len(toNodes) {
+		k = len(toNodes)
+	}
+
+	nodes := append([]string{}, toNodes...)
+	sort.Strings(nodes)
+	nodes = append(nodes[:k], fromNodes...)
+
+	ret.RouteItems = append(ret.RouteItems, RouteItem{
+		Dst: Match{Key: KeyInstances, OP: OPeq, Value: nodes},
+	})
+	return ret, float64(k) / float64(len(nodes)), nil
+}
+
+// PinsDefault  if default destination rules of rc route to version ver, or any of nodes
+func PinsDefault(rc *RouteCfg, ver string, nodes []string) bool {
+	if rc == nil {
+		return false
+	}
+	for _, item := range rc.RouteItems {
+		if item.Src.Key != "" {
+			continue
+		}
+		for _, v := range item.Dst.Value {
+			switch item.Dst.Key {
+			case KeyVersion:
+				if v == ver {
+					return true
+				}
+			case KeyInstances:
+				for _, n := range nodes {
+					if v == n {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
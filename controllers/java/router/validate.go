@@ -0,0 +1,123 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// Validate  check rc is well formed, and can be parsed back from what it writes to zk
+func (rc *RouteCfg) Validate() error {
+	if rc == nil {
+		return fmt.Errorf("route config is nil")
+	}
+
+	var merr *multierror.Error
+	switch rc.APIVersion {
+	case APIV2, APIV4:
+	default:
+		merr = multierror.Append(merr, fmt.Errorf("unknown api version %q", rc.APIVersion))
+	}
+
+	for i, item := range rc.RouteItems {
+		if item.Dst.Key == "" {
+			merr = multierror.Append(merr, fmt.Errorf("item %d: destination key is empty", i))
+		}
+		if strings.Join(item.Dst.Value, "") == "" {
+			merr = multierror.Append(merr, fmt.Errorf("item %d: destination %v has no value", i, item.Dst.Key))
+		}
+		switch item.Dst.OP {
+		case OPeq, OPne:
+		default:
+			merr = multierror.Append(merr, fmt.Errorf("item %d: unknown operator %q", i, item.Dst.OP))
+		}
+		if item.Src.Key == "" && len(item.Src.Value) > 0 {
+			merr = multierror.Append(merr, fmt.Errorf("item %d: source key is empty", i))
+		}
+		if rc.APIVersion == APIV2 && item.Src.Key != "" {
+			merr = multierror.Append(merr, fmt.Errorf("item %d: source match is not supported by api %v", i, APIV2))
+		}
+	}
+
+	if err := merr.ErrorOrNil(); err != nil {
+		return err
+	}
+
+	content := rc.String()
+	back, err := Parse(content, rc.APIVersion)
+	if err != nil {
+		return fmt.Errorf("route config cannot be parsed back: %v", err)
+	}
+	if back.String() != content {
+		return fmt.Errorf("route config changes after written: %q", content)
+	}
+	return nil
+}
+
+// Diff  line diff from route config a to b, lines removed are prefixed by "- ", lines added by "+ ",
+// and lines unchanged by "  "
+func Diff(a, b *RouteCfg) []string {
+	var al, bl []string
+	if a != nil {
+		al = splitLines(a.String())
+	}
+	if b != nil {
+		bl = splitLines(b.String())
+	}
+
+	// lcs[i][j]: length of longest common subsequence of al[i:] and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ret := []string{}
+	i, j := 0, 0
+	for i < len(al) && j < len(bl) {
+		switch {
+		case al[i] == bl[j]:
+			ret = append(ret, "  "+al[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ret = append(ret, "- "+al[i])
+			i++
+		default:
+			ret = append(ret, "+ "+bl[j])
+			j++
+		}
+	}
+	for ; i < len(al); i++ {
+		ret = append(ret, "- "+al[i])
+	}
+	for ; j < len(bl); j++ {
+		ret = append(ret, "+ "+bl[j])
+	}
+
+	return ret
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,122 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package router
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRouteCfg_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rc      *RouteCfg
+		wantErr bool
+	}{
+		{
+			name: "v4",
+			rc: &RouteCfg{
+				APIVersion: APIV4,
+				RouteItems: []RouteItem{
+					{Src: Match{Key: "host", OP: OPne, Value: []string{"localhost"}}, Dst: Match{Key: "version", OP: OPeq, Value: []string{"14"}}},
+				},
+			},
+		},
+		{
+			name:    "unknown api version",
+			rc:      &RouteCfg{APIVersion: "3.0"},
+			wantErr: true,
+		},
+		{
+			name: "empty destination",
+			rc: &RouteCfg{
+				APIVersion: APIV4,
+				RouteItems: []RouteItem{{Dst: Match{Key: "version", OP: OPeq}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "source in v2",
+			rc: &RouteCfg{
+				APIVersion: APIV2,
+				RouteItems: []RouteItem{
+					{Src: Match{Key: "host", OP: OPeq, Value: []string{"a"}}, Dst: Match{Key: "version", OP: OPeq, Value: []string{"14"}}},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rc.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("RouteCfg.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, _ := Parse("=> version=14\nhost=a => version=13", APIV4)
+	b, _ := Parse("=> version=15\nhost=a => version=13", APIV4)
+
+	want := []string{
+		"- => version=14",
+		"+ => version=15",
+		"  host=a => version=13",
+	}
+	if got := Diff(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %q, want %q", got, want)
+	}
+}
+
+func TestShiftVersion(t *testing.T) {
+	rc, _ := Parse("policy=random\nversion=14", APIV2)
+	from := []string{"1", "2", "3", "4"}
+	to := []string{"6", "5"}
+
+	tests := []struct {
+		name    string
+		percent float64
+		want    string
+		actual  float64
+	}{
+		{
+			name:    "10%",
+			percent: 0.1,
+			want:    "# auto generated, please donnot  modify\npolicy=random\ninstances=5;1;2;3;4\n",
+			actual:  0.2,
+		},
+		{
+			name:    "50%",
+			percent: 0.5,
+			want:    "# auto generated, please donnot  modify\npolicy=random\ninstances=5;6;1;2;3;4\n",
+			actual:  2.0 / 6,
+		},
+		{
+			name:    "100%",
+			percent: 1,
+			want:    "# auto generated, please donnot  modify\npolicy=random\nversion=15\n",
+			actual:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, actual, err := ShiftVersion(rc, from, to, "15", tt.percent)
+			if err != nil {
+				t.Fatalf("ShiftVersion() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ShiftVersion() = %q, want %q", got.String(), tt.want)
+			}
+			if actual != tt.actual {
+				t.Errorf("ShiftVersion() actual = %v, want %v", actual, tt.actual)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("ShiftVersion() invalid route: %v", err)
+			}
+		})
+	}
+}
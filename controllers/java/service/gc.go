@@ -0,0 +1,231 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/controllers/java/router"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+	"we.com/jiabiao/common/alert"
+	mytime "we.com/jiabiao/common/time"
+)
+
+const defaultGCGrace = 10 * time.Minute
+
+var errAllStale = errors.New("all instances are stale, refuse to remove")
+
+// GCPolicy  how stale instances of a deployment are collected, stored at etcdkey.JavaInstanceGCDir
+type GCPolicy struct {
+	// Delete  remove stale instances from discovery backend, they are only alerted if false
+	Delete bool `json:"delete,omitempty"`
+	// Grace  how long an instance should be stale before it is collected, 10m if not set
+	Grace mytime.Duration `json:"grace,omitempty"`
+}
+
+// StaleInstance  an instance registered in discovery backend, whose host and pid match no running instance
+type StaleInstance struct {
+	Name    types.DeployName    `json:"name"`
+	Node    *router.ServiceNode `json:"node"`
+	Since   time.Time           `json:"since"`
+	Removed bool                `json:"removed,omitempty"`
+	Err     error               `json:"-"`
+	alerted bool
+}
+
+type instanceGC struct {
+	backend  discovery.Backend
+	running  func(name types.DeployName) map[types.InstanceID]*types.Instance
+	policies func() (map[types.DeployName]GCPolicy, error)
+	now      func() time.Time
+	// stale instances of last round, by deployment and node name
+	stale map[types.DeployName]map[string]*StaleInstance
+}
+
+// collect  returns instances which have been stale longer than grace, and are not alerted yet,
+// or have been tried to remove
+func (g *instanceGC) collect() ([]*StaleInstance, error) {
+	policies, err := g.policies()
+	if err != nil {
+		return nil, errors.Wrap(err, "gc: load policies")
+	}
+
+	now := g.now()
+	var merr *multierror.Error
+	var ret []*StaleInstance
+	stale := map[types.DeployName]map[string]*StaleInstance{}
+	for _, name := range g.backend.ListDeployment() {
+		nodes, err := g.backend.GetInstanceList(name)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+
+		running := g.running(name)
+		prev := g.stale[name]
+		cur := map[string]*StaleInstance{}
+		for _, n := range nodes {
+			if matchRunning(n, running) {
+				continue
+			}
+			si := prev[n.NodeName]
+			if si == nil || !discovery.SameInstance(si.Node, n) {
+				si = &StaleInstance{Name: name, Node: n, Since: now}
+			}
+			cur[n.NodeName] = si
+		}
+		if len(cur) == 0 {
+			continue
+		}
+		stale[name] = cur
+
+		p := policies[name]
+		grace := time.Duration(p.Grace)
+		if grace <= 0 {
+			grace = defaultGCGrace
+		}
+
+		// instance info may be incomplete, never remove every instance of a deployment
+		all := len(cur) == len(nodes)
+		for k, si := range cur {
+			if now.Sub(si.Since) < grace {
+				continue
+			}
+
+			if !p.Delete {
+				if !si.alerted {
+					si.alerted = true
+					ret = append(ret, si)
+				}
+				continue
+			}
+
+			ret = append(ret, si)
+			if all {
+				si.Err = errAllStale
+				continue
+			}
+
+			si.Err = g.backend.RemoveInstance(name, si.Node)
+			if si.Err == nil {
+				si.Removed = true
+				delete(cur, k)
+			}
+		}
+	}
+	g.stale = stale
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Node.NodeName < ret[j].Node.NodeName
+	})
+	return ret, merr.ErrorOrNil()
+}
+
+// matchRunning  pid is not checked if either side does not know it
+func matchRunning(n *router.ServiceNode, running map[types.InstanceID]*types.Instance) bool {
+	host := n.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, ins := range running {
+		if ins.IP != host && ins.Host != host {
+			continue
+		}
+		if n.Pid == 0 || ins.Pid == 0 || n.Pid == ins.Pid {
+			return true
+		}
+	}
+	return false
+}
+
+func loadGCPolicies(stage types.Stage) func() (map[types.DeployName]GCPolicy, error) {
+	return func() (map[types.DeployName]GCPolicy, error) {
+		store, err := generic.GetStoreInstance(etcdkey.JavaInstanceGCDir(stage), false)
+		if err != nil {
+			return nil, err
+		}
+
+		dat := map[string]GCPolicy{}
+		if err := store.List(context.Background(), "", generic.Everything, dat); err != nil {
+			return nil, err
+		}
+
+		ret := make(map[types.DeployName]GCPolicy, len(dat))
+		for k, v := range dat {
+			ret[types.DeployName(k)] = v
+		}
+		return ret, nil
+	}
+}
+
+// CollectStaleInstances  alert stale instances, and remove them if enabled by GCPolicy of their deployment
+func (m *manager) CollectStaleInstances() ([]*StaleInstance, error) {
+	ret, err := m.gc.collect()
+
+	var alerts []alert.Message
+	for _, si := range ret {
+		msg := fmt.Sprintf("%v 实例 %v(%v, pid %v) 自 %v 起无对应运行实例", si.Name, si.Node.NodeName, si.Node.Host, si.Node.Pid, si.Since.Local().Format(time.Kitchen))
+		switch {
+		case si.Removed:
+			msg += ", 已删除"
+		case si.Err != nil:
+			msg += fmt.Sprintf(", 删除失败: %v", si.Err)
+		}
+
+		parts := strings.Split(string(si.Name), ":")
+		alerts = append(alerts, alert.Message{
+			Labels: map[string]string{
+				"proj": parts[0],
+				"env":  m.stage.String(),
+				"from": "dolphin",
+				"why":  "zk实例残留",
+			},
+			Annotations: map[string]string{
+				"time": time.Now().Local().Format(time.Kitchen),
+				"msg":  msg,
+			},
+		})
+	}
+
+	if len(alerts) > 0 {
+		go alert.SendAlerts(alerts...)
+	}
+
+	return ret, err
+}
+
+// RunGC  collect stale instances every interval, until ctx is done
+func (m *manager) RunGC(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.stopC:
+			return
+		case <-ticker.C:
+			if _, err := m.CollectStaleInstances(); err != nil {
+				glog.Errorf("java service: %v collect stale instances: %v", m.stage, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/controllers/java/router"
+	"we.com/dolphin/types"
+	mytime "we.com/jiabiao/common/time"
+)
+
+type fakeBackend struct {
+	discovery.Backend
+	nodes   map[types.DeployName][]*router.ServiceNode
+	removed []string
+}
+
+func (f *fakeBackend) ListDeployment() []types.DeployName {
+	ret := []types.DeployName{}
+	for k := range f.nodes {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func (f *fakeBackend) GetInstanceList(name types.DeployName) ([]*router.ServiceNode, error) {
+	return f.nodes[name], nil
+}
+
+func (f *fakeBackend) RemoveInstance(name types.DeployName, node *router.ServiceNode) error {
+	f.removed = append(f.removed, node.NodeName)
+	return nil
+}
+
+func Test_instanceGC_collect(t *testing.T) {
+	const name = types.DeployName("crm")
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	nodes := []*router.ServiceNode{
+		{NodeName: "1_1", Host: "10.0.0.1", Pid: 100},
+		{NodeName: "1_2", Host: "10.0.0.2:8080", Pid: 200},
+	}
+	running := map[types.InstanceID]*types.Instance{
+		"a": {IP: "10.0.0.1", Pid: 100},
+		"b": {IP: "10.0.0.2", Pid: 201},
+	}
+
+	tests := []struct {
+		name        string
+		policy      GCPolicy
+		running     map[types.InstanceID]*types.Instance
+		after       []time.Duration
+		wantStale   []int
+		wantRemoved []string
+	}{
+		{
+			name:      "alert once after grace",
+			running:   running,
+			after:     []time.Duration{0, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute},
+			wantStale: []int{0, 0, 1, 0},
+		},
+		{
+			name:        "remove after grace",
+			policy:      GCPolicy{Delete: true, Grace: mytime.Duration(time.Minute)},
+			running:     running,
+			after:       []time.Duration{0, 2 * time.Minute},
+			wantStale:   []int{0, 1},
+			wantRemoved: []string{"1_2"},
+		},
+		{
+			name:      "never remove all",
+			policy:    GCPolicy{Delete: true, Grace: mytime.Duration(time.Minute)},
+			after:     []time.Duration{0, 2 * time.Minute},
+			wantStale: []int{0, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := &fakeBackend{nodes: map[types.DeployName][]*router.ServiceNode{name: nodes}}
+			now := start
+			g := &instanceGC{
+				backend: fb,
+				running: func(types.DeployName) map[types.InstanceID]*types.Instance { return tt.running },
+				policies: func() (map[types.DeployName]GCPolicy, error) {
+					return map[types.DeployName]GCPolicy{name: tt.policy}, nil
+				},
+				now: func() time.Time { return now },
+			}
+
+			for i, d := range tt.after {
+				now = start.Add(d)
+				got, err := g.collect()
+				if err != nil {
+					t.Fatalf("collect() error = %v", err)
+				}
+				if len(got) != tt.wantStale[i] {
+					t.Errorf("collect() after %v = %d stale, want %d", d, len(got), tt.wantStale[i])
+				}
+			}
+			if len(fb.removed) != len(tt.wantRemoved) || (len(fb.removed) > 0 && fb.removed[0] != tt.wantRemoved[0]) {
+				t.Errorf("removed = %v, want %v", fb.removed, tt.wantRemoved)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"we.com/dolphin/report/metric"
+	"we.com/dolphin/types"
+	"we.com/dolphin/types/ins/java"
+	"we.com/jiabiao/common/probe"
+)
+
+const (
+	// insMinProbes  an instance is not marked before it is probed this many times
+	insMinProbes = 6
+	// insMarkRatio  an instance is marked with probe condition when its fail ratio exceeds this
+	insMarkRatio = 0.5
+	// insClearRatio  the probe condition is removed when fail ratio drops below this
+	insClearRatio = 0.2
+)
+
+// insProbe  probe state of an instance which is probed directly
+type insProbe struct {
+	ID          types.InstanceID  `json:"id"`
+	Version     string            `json:"version,omitempty"`
+	Addr        string            `json:"addr"`
+	FailRatio   failRatio         `json:"failRatio"`
+	LastFailure *java.ProbeDetail `json:"lastFailure,omitempty"`
+	Marked      bool              `json:"marked,omitempty"`
+	iter        *interfaceIterator
+}
+
+// update  add a probe result, returns true if instance should be marked or unmarked
+func (p *insProbe) update(result probe.Result) bool {
+	p.FailRatio.update(result)
+	switch {
+	case !p.Marked && p.FailRatio.Count >= insMinProbes && p.FailRatio.AVG1 > insMarkRatio:
+		p.Marked = true
+		return true
+	case p.Marked && p.FailRatio.AVG1 < insClearRatio:
+		p.Marked = false
+		return true
+	}
+	return false
+}
+
+// insAddr  the address to probe an instance, instances not listening on exactly one address are not probed
+func insAddr(ins *types.Instance) (string, bool) {
+	if len(ins.Listening) != 1 {
+		return "", false
+	}
+	addr := ins.Listening[0]
+	return fmt.Sprintf("%v:%v", addr.IP, addr.Port), true
+}
+
+// StartInstanceProbe  probe every running instance of name by its listening address, instead of going through an esb.
+// fail ratio is kept per instance, instances failing are marked with a probe condition, so the scheduler may replace them
+func (m *manager) StartInstanceProbe(ctx context.Context, name types.DeployName, ch chan<- probeResult) {
+	s := m.getService(name)
+	if s == nil {
+		ch <- probeResult{
+			name:   name,
+			err:    errors.Errorf("java deployment %v not exist", name),
+			result: probe.Unknown,
+		}
+		return
+	}
+
+	key := types.DeployKey(fmt.Sprintf("java/%v", name))
+	defer m.clearInstanceProbes(key, s)
+
+	ifs := m.provider.GetProbeInterfaces(name)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	updateTimer := time.NewTicker(5 * time.Minute)
+	defer updateTimer.Stop()
+
+	for {
+		select {
+		case <-updateTimer.C:
+			ifs = m.provider.GetProbeInterfaces(name)
+			if ifs == nil {
+				ch <- probeResult{
+					name:   name,
+					err:    errors.Errorf("no dial interface configed to prbe"),
+					result: probe.Unknown,
+				}
+			}
+			m.lock.Lock()
+			for _, p := range s.insProbes {
+				p.iter = toIterator(ifs)
+			}
+			m.lock.Unlock()
+		case <-ticker.C:
+			if len(ifs) == 0 {
+				continue
+			}
+			m.probeInstances(ctx, key, s, ifs)
+		case <-ctx.Done():
+			return
+		case <-m.stopC:
+			return
+		}
+	}
+}
+
+// probeInstances  probe each running instance of key once, with the next interface of it
+func (m *manager) probeInstances(ctx context.Context, key types.DeployKey, s *service, ifs java.ProbeInterfaces) {
+	running := m.insInfor.RunningInstance(key)
+
+	m.lock.Lock()
+	if s.insProbes == nil {
+		s.insProbes = map[types.InstanceID]*insProbe{}
+	}
+	for id := range s.insProbes {
+		if _, ok := running[id]; !ok {
+			delete(s.insProbes, id)
+		}
+	}
+
+	type job struct {
+		p     *insProbe
+		iface *java.ProbeInterface
+	}
+	jobs := make([]job, 0, len(running))
+	for id, ins := range running {
+		addr, ok := insAddr(ins)
+		if !ok {
+			glog.V(4).Infof("java probe: %v instance %v listening on %d addresses, skipped", s.Name, id, len(ins.Listening))
+			continue
+		}
+		p := s.insProbes[id]
+		if p == nil || p.Addr != addr {
+			p = &insProbe{ID: id, Addr: addr, iter: toIterator(ifs)}
+			s.insProbes[id] = p
+		}
+		p.Version = ins.Version
+		if iface := p.iter.Next(); iface != nil {
+			jobs = append(jobs, job{p: p, iface: iface})
+		}
+	}
+	m.lock.Unlock()
+
+	pds := make([]*java.ProbeDetail, len(jobs))
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		go func(i int, iface *java.ProbeInterface, addr string) {
+			defer wg.Done()
+			pctx, cf := context.WithTimeout(ctx, probeTimeout)
+			defer cf()
+			pds[i] = iface.Run(pctx, probeClient, "http://"+addr)
+		}(i, j.iface, j.p.Addr)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	mtrs := make([]metric.Metric, 0, len(jobs))
+	m.lock.Lock()
+	for i, j := range jobs {
+		p, pd := j.p, pds[i]
+		if err := pd.Err(); err != nil {
+			glog.Errorf("java probe: %v instance %v err: %v", s.Name, p.ID, err)
+			p.LastFailure = pd
+		}
+
+		if p.update(pd.Result) {
+			if p.Marked {
+				msg := fmt.Sprintf("probe fail ratio %.2f", p.FailRatio.AVG1)
+				if p.LastFailure != nil {
+					msg = fmt.Sprintf("%v, last failure: %v", msg, p.LastFailure.Err())
+				}
+				glog.Warningf("java probe: mark %v instance %v: %v", s.Name, p.ID, msg)
+				m.insInfor.SetCondition(key, p.ID, &types.Condition{Type: types.ProbeCondition, Message: msg})
+			} else {
+				glog.Infof("java probe: %v instance %v recovered", s.Name, p.ID)
+				m.insInfor.ClearCondition(key, p.ID, types.ProbeCondition)
+			}
+		}
+
+		label, field := m.newLabelsAndFields(s.Name, "none", &p.FailRatio)
+		label["instance"] = string(p.ID)
+		label["version"] = p.Version
+		mtr, _ := metric.New(measurement, label, field, now)
+		mtrs = append(mtrs, mtr)
+	}
+	m.lock.Unlock()
+
+	for _, mtr := range mtrs {
+		m.mchan <- mtr
+	}
+}
+
+// clearInstanceProbes  remove probe conditions set on instances of key when probing stops
+func (m *manager) clearInstanceProbes(key types.DeployKey, s *service) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for id, p := range s.insProbes {
+		if p.Marked {
+			m.insInfor.ClearCondition(key, id, types.ProbeCondition)
+		}
+	}
+	s.insProbes = nil
+}
+
+// InstanceFailRatio  recent probe fail ratio of an instance probed directly
+func (m *manager) InstanceFailRatio(key types.DeployKey, insID types.InstanceID) (float64, bool) {
+	s := m.serviceOf(key)
+	if s == nil {
+		return 0, false
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	p := s.insProbes[insID]
+	if p == nil || p.FailRatio.Count == 0 {
+		return 0, false
+	}
+	return p.FailRatio.AVG1, true
+}
+
+// VersionFailRatio  mean fail ratio of instances of version ver which are probed directly
+func (m *manager) VersionFailRatio(key types.DeployKey, ver types.DeployVer) (float64, bool) {
+	s := m.serviceOf(key)
+	if s == nil {
+		return 0, false
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return versionFailRatio(s.insProbes, string(ver))
+}
+
+func versionFailRatio(probes map[types.InstanceID]*insProbe, ver string) (float64, bool) {
+	sum, n := 0.0, 0
+	for _, p := range probes {
+		if p.Version != ver || p.FailRatio.Count == 0 {
+			continue
+		}
+		sum += p.FailRatio.AVG1
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+func (m *manager) serviceOf(key types.DeployKey) *service {
+	pt, name, err := types.ParseDeployKey(key)
+	if err != nil || pt != types.ProjectType("java") {
+		return nil
+	}
+	return m.getService(types.DeployName(name))
+}
@@ -0,0 +1,78 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package service
+
+import (
+	"testing"
+
+	"we.com/dolphin/types"
+	"we.com/jiabiao/common/probe"
+)
+
+func Test_insProbe_update(t *testing.T) {
+	repeat := func(r probe.Result, n int) []probe.Result {
+		ret := make([]probe.Result, n)
+		for i := range ret {
+			ret[i] = r
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name       string
+		results    []probe.Result
+		wantMarked bool
+		wantChange int
+	}{
+		{"healthy", repeat(probe.Success, 20), false, 0},
+		{"not enough probes", repeat(probe.Failure, insMinProbes-1), false, 0},
+		{"failing", repeat(probe.Failure, 20), true, 1},
+		{"single failure", append(repeat(probe.Success, 10), probe.Failure), false, 0},
+		{"recovered", append(repeat(probe.Failure, 20), repeat(probe.Success, 40)...), false, 2},
+		{"not yet recovered", append(repeat(probe.Failure, 20), repeat(probe.Success, 2)...), true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &insProbe{}
+			changes := 0
+			for _, r := range tt.results {
+				if p.update(r) {
+					changes++
+				}
+			}
+			if p.Marked != tt.wantMarked || changes != tt.wantChange {
+				t.Errorf("update() marked = %v, changed %d times, want %v, %d times (ratio %.2f)",
+					p.Marked, changes, tt.wantMarked, tt.wantChange, p.FailRatio.AVG1)
+			}
+		})
+	}
+}
+
+func Test_versionFailRatio(t *testing.T) {
+	probes := map[types.InstanceID]*insProbe{
+		"a": {Version: "v1", FailRatio: failRatio{Count: 3, AVG1: 0.2}},
+		"b": {Version: "v1", FailRatio: failRatio{Count: 3, AVG1: 0.6}},
+		"c": {Version: "v2", FailRatio: failRatio{Count: 3, AVG1: 1}},
+		"d": {Version: "v3"},
+	}
+
+	tests := []struct {
+		ver    string
+		want   float64
+		wantOK bool
+	}{
+		{"v1", 0.4, true},
+		{"v2", 1, true},
+		{"v3", 0, false},
+		{"v4", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := versionFailRatio(probes, tt.ver)
+		if ok != tt.wantOK || got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("versionFailRatio(%v) = %v, %v, want %v, %v", tt.ver, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
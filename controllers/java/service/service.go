@@ -8,6 +8,8 @@ package service
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"sort"
 	"strings"
 	"sync"
@@ -16,8 +18,8 @@ import (
 	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
+	"we.com/dolphin/controllers/java/discovery"
 	"we.com/dolphin/controllers/java/router"
-	"we.com/dolphin/controllers/java/zk"
 	ctypes "we.com/dolphin/controllers/types"
 	"we.com/dolphin/report"
 	"we.com/dolphin/report/metric"
@@ -56,11 +58,15 @@ type service struct {
 	LastVersion   string           `json:"lastVersion,omitempty"`
 	ExpectVersion string           `json:"expectVersion,omitempty"`
 	FailRatio     failRatio
+	// LastFailure  last failed probe, with assertions it failed
+	LastFailure *java.ProbeDetail `json:"lastFailure,omitempty"`
 
 	ExpectInstance int                         `json:"expectInstance,omitempty"`
 	Conditions     map[conditionType]condition `json:"conditions,omitempty"`
 	esbFailRatio   map[string]*failRatio
 	instances      []*types.Instance
+	// insProbes  instances probed directly by StartInstanceProbe
+	insProbes map[types.InstanceID]*insProbe
 }
 
 type esb struct {
@@ -78,6 +84,27 @@ type failRatio struct {
 
 // Manager java service  checker
 type Manager interface {
+	ctypes.HealthChecker
+	ctypes.VersionHealthChecker
+	ctypes.ProbedHealthChecker
+	// InstanceFailRatio  recent fail ratio of an instance probed directly, ok is false if it is unknown
+	InstanceFailRatio(key types.DeployKey, insID types.InstanceID) (ratio float64, ok bool)
+	// CollectStaleInstances  find instances registered in discovery backend which match no running instance
+	CollectStaleInstances() ([]*StaleInstance, error)
+	// RunGC  collect stale instances every interval, until ctx is done
+	RunGC(ctx context.Context, interval time.Duration)
+	// CheckSLOs  error budgets and burn rates of deployments having an SLO, alert fast burning ones
+	CheckSLOs() ([]*SLOStatus, error)
+	// RunSLO  check SLOs every interval, until ctx is done
+	RunSLO(ctx context.Context, interval time.Duration)
+	// SetESBs  set esbs, host:port by api version, java deployments are probed through them
+	SetESBs(esbs map[string][]string) error
+	// KnownESB  if addr, host:port, is an esb configured for the api version of deployment name
+	KnownESB(name types.DeployName, addr string) bool
+	// Start  load java deployments and probe them, until ctx is done or Stop is called
+	Start(ctx context.Context)
+	// Stop  stop probing
+	Stop()
 }
 
 type manager struct {
@@ -87,11 +114,16 @@ type manager struct {
 	provider      java.ProbeInterfaceProvider
 	esbs          map[apiVersion][]*esb
 	insInfor      ctypes.InstanceInfor
-	zkManager     zk.Manager
+	zkManager     discovery.Backend
 	services      map[types.DeployName]*service
 	mchan         chan metric.Metric
 	stopC         chan struct{}
+	stopOnce      sync.Once
 	inflluxClient *report.InfluxDB
+	gc            *instanceGC
+	slos          map[types.DeployName]*sloTracker
+	sloPolicies   func() (map[types.DeployName]SLO, error)
+	sloStates     sloStateStore
 }
 
 /*
@@ -108,9 +140,9 @@ type manager struct {
 		5. zk实例的版本，怎么处理多个版本的情况
 */
 
-// NewManager create a new manager
+// NewManager create a new manager, if reporter is nil, metrics are dropped
 func NewManager(stage types.Stage, diPV java.ProbeInterfaceProvider, info ctypes.InstanceInfor,
-	zk zk.Manager, reporter *report.InfluxDB) (Manager, error) {
+	backend discovery.Backend, reporter *report.InfluxDB) (Manager, error) {
 	if diPV == nil {
 		return nil, errors.Errorf("controler: java service checker, javaprobeinterfaceProvider cannot be nil")
 	}
@@ -119,12 +151,8 @@ func NewManager(stage types.Stage, diPV java.ProbeInterfaceProvider, info ctypes
 		return nil, errors.New("controler: java service checker, instanceInfor cannot be nil")
 	}
 
-	if zk == nil {
-		return nil, errors.New("controler: java service checker, zk.Manager cannot be nil")
-	}
-
-	if reporter == nil {
-		return nil, errors.New("controler: java service checker, influxdb client cannot be nil")
+	if backend == nil {
+		return nil, errors.New("controler: java service checker, discovery backend cannot be nil")
 	}
 
 	ret := manager{
@@ -133,11 +161,23 @@ func NewManager(stage types.Stage, diPV java.ProbeInterfaceProvider, info ctypes
 		provider:      diPV,
 		esbs:          map[apiVersion][]*esb{},
 		insInfor:      info,
-		zkManager:     zk,
+		zkManager:     backend,
 		services:      map[types.DeployName]*service{},
 		mchan:         make(chan metric.Metric, 200),
 		stopC:         make(chan struct{}),
 		inflluxClient: reporter,
+		slos:          map[types.DeployName]*sloTracker{},
+		sloPolicies:   loadSLOs(stage),
+		sloStates:     etcdSLOStateStore{stage: stage},
+	}
+
+	ret.gc = &instanceGC{
+		backend: backend,
+		running: func(name types.DeployName) map[types.InstanceID]*types.Instance {
+			return info.RunningInstance(types.DeployKey(fmt.Sprintf("java/%v", name)))
+		},
+		policies: loadGCPolicies(stage),
+		now:      time.Now,
 	}
 
 	return &ret, nil
@@ -288,10 +328,13 @@ func (m *manager) checkZKVersion() error {
 	return nil
 }
 
+// load  load java deployments registered in discovery backend, services of deployments already loaded
+// are updated in place, so that running probes keep updating them
 func (m *manager) load() error {
 	// 获取zk上所有的java服务
 	names := m.zkManager.ListDeployment()
 	var merr *multierror.Error
+	loaded := map[types.DeployName]*service{}
 	for _, v := range names {
 		ss, err := m.zkManager.GetInstanceList(v)
 		if err != nil {
@@ -329,11 +372,30 @@ func (m *manager) load() error {
 			instances:      ins,
 		}
 
-		m.services[v] = &serv
+		loaded[v] = &serv
+	}
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for n, s := range loaded {
+		if old := m.services[n]; old != nil {
+			old.Types = s.Types
+			old.APIVersion = s.APIVersion
+			old.Route = s.Route
+			old.instances = s.instances
+			continue
+		}
+		m.services[n] = s
+	}
+	if merr.ErrorOrNil() == nil {
+		for n := range m.services {
+			if _, ok := loaded[n]; !ok {
+				delete(m.services, n)
+			}
+		}
 	}
 
-	return nil
+	return merr.ErrorOrNil()
 }
 
 func (m *manager) lg(name types.DeployName, esb *esb) (probe.LoadGenerator, error) {
@@ -370,6 +432,31 @@ func (m *manager) getService(name types.DeployName) *service {
 	return ret
 }
 
+// FailRatio  recent probe fail ratio of a java deployment
+func (m *manager) FailRatio(key types.DeployKey) (float64, bool) {
+	pt, name, err := types.ParseDeployKey(key)
+	if err != nil || pt != types.ProjectType("java") {
+		return 0, false
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	s := m.services[types.DeployName(name)]
+	if s == nil || s.FailRatio.Count == 0 {
+		return 0, false
+	}
+	return s.FailRatio.AVG1, true
+}
+
+// Probed  if probe interfaces are configured for the java deployment of key
+func (m *manager) Probed(key types.DeployKey) bool {
+	pt, name, err := types.ParseDeployKey(key)
+	if err != nil || pt != types.ProjectType("java") || m.provider == nil {
+		return false
+	}
+	return len(m.provider.GetProbeInterfaces(types.DeployName(name))) > 0
+}
+
 func (m *manager) getEsbs(ver apiVersion) []*esb {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
@@ -439,21 +526,7 @@ func (m *manager) StartProbe(ctx context.Context, name types.DeployName, ch chan
 
 	ifs := m.provider.GetProbeInterfaces(name)
 	iter := toIterator(ifs)
-	lg := func(e *esb, di *java.ProbeInterface) func() interface{} {
-		return func() interface{} {
-			url := fmt.Sprintf("http://%v:%v", e.Host, e.Port)
-			ret := make([]*pjava.Args, 1)
-
-			ret[0] = &pjava.Args{
-				Name:    di.Name,
-				Data:    strings.NewReader(di.Data),
-				URL:     url,
-				Headers: di.Headers,
-			}
-
-			return ret
-		}
-	}
+	slo := m.sloTracker(name)
 
 	ticker := time.NewTicker(m.interval)
 	defer ticker.Stop()
@@ -493,29 +566,39 @@ func (m *manager) StartProbe(ctx context.Context, name types.DeployName, ch chan
 			}
 
 			e := esbs[idx]
-			l := lg(e, iface)
+			url := fmt.Sprintf("%v:%v", e.Host, e.Port)
 
-			ret, msg, err := pjava.Probe(l)
-			if err != nil {
-				glog.Errorf("java probe: %v err: %v, msg: %v", name, err.Error(), msg)
+			pctx, cf := context.WithTimeout(ctx, probeTimeout)
+			pd := iface.Run(pctx, probeClient, "http://"+url)
+			cf()
+			ret := pd.Result
+			perr := pd.Err()
+			if perr != nil {
+				glog.Errorf("java probe: %v err: %v", name, perr)
 			}
+			slo.record(ret, time.Now())
 
+			// fail ratios are read by schedulers and reporters, guard them as instance probes do
+			m.lock.Lock()
+			if perr != nil {
+				s.LastFailure = pd
+			}
 			s.FailRatio.update(ret)
-
-			url := fmt.Sprintf("%v:%v", e.Host, e.Port)
 			ef := s.esbFailRatio[url]
 			if ef == nil {
 				ef = &failRatio{}
 				s.esbFailRatio[url] = ef
 			}
 			ef.update(ret)
+			e.Service.FailRatio.update(ret)
 			label, field := m.newLabelsAndFields(name, url, ef)
 			label["version"] = string(s.APIVersion)
 			field["numInstances"] = len(s.instances)
 			field["numVersions"] = s.getNumVersion()
+			m.lock.Unlock()
+
 			mtr, _ := metric.New(measurement, label, field, time.Now())
 			m.mchan <- mtr
-			e.Service.FailRatio.update(ret)
 
 		case <-ctx.Done():
 			return
@@ -547,6 +630,11 @@ const (
 	measurement = "java_service"
 )
 
+// probeTimeout  timeout of probing an interface once
+const probeTimeout = 5 * time.Second
+
+var probeClient = &http.Client{Timeout: probeTimeout}
+
 func (s *service) getNumVersion() int {
 	verMap := map[string]int{}
 	for _, v := range s.instances {
@@ -582,6 +670,158 @@ func (m *manager) check() {
 
 }
 
+// loadInterval  interval of reloading java deployments, deployments found are probed
+const loadInterval = time.Minute
+
+// SetESBs  set esbs by api version, an esb is given as host:port
+func (m *manager) SetESBs(esbs map[string][]string) error {
+	ret := map[apiVersion][]*esb{}
+	for ver, addrs := range esbs {
+		for _, addr := range addrs {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return errors.Wrapf(err, "controler: esb of api version %v", ver)
+			}
+			ret[apiVersion(ver)] = append(ret[apiVersion(ver)], &esb{
+				Service: service{Stage: m.stage, APIVersion: apiVersion(ver)},
+				Host:    host,
+				Port:    port,
+			})
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.esbs = ret
+	return nil
+}
+
+// KnownESB  implements Manager, deployments not loaded yet have no known esb
+func (m *manager) KnownESB(name types.DeployName, addr string) bool {
+	s := m.getService(name)
+	if s == nil {
+		return false
+	}
+	for _, e := range m.getEsbs(s.APIVersion) {
+		if net.JoinHostPort(e.Host, e.Port) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Start  load java deployments, and probe each of them through esbs and its instances, deployments found later
+// are probed too, until ctx is done or Stop is called
+func (m *manager) Start(ctx context.Context) {
+	if err := m.load(); err != nil {
+		glog.Errorf("controler: java service checker, load deployments of %v: %v", m.stage, err)
+	}
+
+	m.report()
+	go m.run(ctx)
+}
+
+// Stop  stop probing and reporting
+func (m *manager) Stop() {
+	m.stopOnce.Do(func() { close(m.stopC) })
+}
+
+// probeRun  probes of a deployment started by run loop
+type probeRun struct {
+	name   types.DeployName
+	cancel context.CancelFunc
+}
+
+func (m *manager) run(ctx context.Context) {
+	ch := make(chan probeResult, 100)
+	done := make(chan *probeRun)
+	probing := map[types.DeployName]*probeRun{}
+	defer func() {
+		for _, p := range probing {
+			p.cancel()
+		}
+	}()
+
+	ticker := time.NewTicker(loadInterval)
+	defer ticker.Stop()
+
+	m.startProbes(ctx, probing, ch, done)
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.load(); err != nil {
+				glog.Errorf("controler: java service checker, load deployments of %v: %v", m.stage, err)
+			}
+			m.startProbes(ctx, probing, ch, done)
+		case r := <-ch:
+			if r.err != nil {
+				glog.Warningf("java probe: %v: %v", r.name, r.err)
+			}
+		case p := <-done:
+			// probes gave up, e.g. no esb or interface yet, retry them in next pass
+			if probing[p.name] == p {
+				delete(probing, p.name)
+			}
+		case <-ctx.Done():
+			return
+		case <-m.stopC:
+			return
+		}
+	}
+}
+
+// startProbes  start probes of deployments not probed yet, through esbs and to each instance directly,
+// and stop probes of removed deployments. a run is sent to done after both of its probes exit
+func (m *manager) startProbes(ctx context.Context, probing map[types.DeployName]*probeRun, ch chan<- probeResult, done chan<- *probeRun) {
+	m.lock.RLock()
+	names := make(map[types.DeployName]struct{}, len(m.services))
+	for n := range m.services {
+		names[n] = struct{}{}
+	}
+	m.lock.RUnlock()
+
+	for n, p := range probing {
+		if _, ok := names[n]; !ok {
+			p.cancel()
+			delete(probing, n)
+		}
+	}
+
+	for n := range names {
+		if _, ok := probing[n]; ok {
+			continue
+		}
+		pctx, cancel := context.WithCancel(ctx)
+		p := &probeRun{name: n, cancel: cancel}
+		probing[n] = p
+		go m.runProbes(ctx, pctx, p, ch, done)
+	}
+}
+
+// runProbes  run probes of p with pctx until either of them exits, then stop the other and report p to done,
+// unless run loop has exited with ctx
+func (m *manager) runProbes(ctx, pctx context.Context, p *probeRun, ch chan<- probeResult, done chan<- *probeRun) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer p.cancel()
+		m.StartProbe(pctx, p.name, ch)
+	}()
+	go func() {
+		defer wg.Done()
+		defer p.cancel()
+		m.StartInstanceProbe(pctx, p.name, ch)
+	}()
+	wg.Wait()
+
+	select {
+	case done <- p:
+	case <-ctx.Done():
+	case <-m.stopC:
+	}
+}
+
 func (m *manager) report() {
 	go func() {
 		for {
@@ -627,18 +867,24 @@ func (m *manager) report() {
 
 func (fr *failRatio) update(result probe.Result) {
 	fr.Count++
-	r := 0.0
-	if result == probe.Failure {
-		r = 1.0
-	} else if result == probe.Warning {
-		r = 0.5
-	}
+	r := failWeight(result)
 
 	fr.AVG1 = calFailRatio(fr.AVG1, exp1, r)
 	fr.AVG5 = calFailRatio(fr.AVG5, exp5, r)
 	fr.AVG15 = calFailRatio(fr.AVG15, exp15, r)
 }
 
+// failWeight  how much a probe result counts as failure
+func failWeight(result probe.Result) float64 {
+	switch result {
+	case probe.Failure:
+		return 1.0
+	case probe.Warning:
+		return 0.5
+	}
+	return 0.0
+}
+
 const (
 	fshift = 11
 	fixed1 = 1 << fshift
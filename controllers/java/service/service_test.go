@@ -6,9 +6,11 @@ Sniperkit-Bot
 package service
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"testing"
+	"time"
 )
 
 func mean(d []float64) float64 {
@@ -54,3 +56,26 @@ func Test_calFailRatio(t *testing.T) {
 		fmt.Printf("%d: %v, %.2f, %.2f, %.2f, %.2f, %.2f, %.2f\n", i, v, f1, mean(arr[s1:i+1]), f5, mean(arr[s2:i+1]), f15, mean(arr[:i+1]))
 	}
 }
+
+func Test_manager_runProbes(t *testing.T) {
+	m := &manager{stopC: make(chan struct{})}
+	ch := make(chan probeResult, 2)
+	done := make(chan *probeRun)
+
+	// probes of a deployment not loaded yet give up at once, the run should be reported for a retry
+	pctx, cancel := context.WithCancel(context.Background())
+	p := &probeRun{name: "crm", cancel: cancel}
+	go m.runProbes(context.Background(), pctx, p, ch, done)
+
+	select {
+	case got := <-done:
+		if got != p {
+			t.Errorf("runProbes() reported %v, want %v", got.name, p.name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("runProbes() did not report the run after probes exit")
+	}
+	if pctx.Err() == nil {
+		t.Errorf("runProbes() did not cancel probes of the run")
+	}
+}
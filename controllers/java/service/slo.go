@@ -0,0 +1,455 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"we.com/dolphin/controllers/alert"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+	"we.com/jiabiao/common/probe"
+	mytime "we.com/jiabiao/common/time"
+)
+
+const (
+	maxSLOWindow = 30 * 24 * time.Hour
+	// probes are counted in 5m buckets for the last 6h, and in 1h buckets for the whole window
+	sloFineBucket   = 5 * time.Minute
+	sloFineSpan     = 6 * time.Hour
+	sloCoarseBucket = time.Hour
+	// sloMinProbes  burn rate of a window with fewer probes is unknown
+	sloMinProbes = 10
+	// sloRealert  interval of alerting a burn rule which keeps firing
+	sloRealert = time.Hour
+)
+
+// SLO  service level objective of a java deployment, stored at etcdkey.JavaSLODir
+type SLO struct {
+	// Objective  expected ratio of successful probes, e.g. 0.999
+	Objective float64 `json:"objective"`
+	// Window  period the objective applies to, 30d if not set, and at most 30d
+	Window mytime.Duration `json:"window,omitempty"`
+}
+
+// Validate  check if objective and window are in range
+func (slo *SLO) Validate() error {
+	if slo.Objective <= 0 || slo.Objective >= 1 {
+		return errors.Errorf("slo: objective should be within (0, 1), got %v", slo.Objective)
+	}
+	if w := time.Duration(slo.Window); w < 0 || w > maxSLOWindow {
+		return errors.Errorf("slo: window should be within [0, %v], 0 for %v, got %v", maxSLOWindow, maxSLOWindow, w)
+	}
+	return nil
+}
+
+func (slo *SLO) window() time.Duration {
+	if slo.Window <= 0 {
+		return maxSLOWindow
+	}
+	return time.Duration(slo.Window)
+}
+
+// burnRule  alert if Budget of error budget would be consumed within Long at the burn rate of both
+// the long and the short window, the short window makes the alert reset soon after the burning stops
+type burnRule struct {
+	Long     time.Duration
+	Short    time.Duration
+	Budget   float64
+	Severity string
+}
+
+// burnRules  multi-window burn rate alerts, for a 30d window the thresholds are 14.4, 6, 3 and 1
+var burnRules = []burnRule{
+	{Long: time.Hour, Short: 5 * time.Minute, Budget: 0.02, Severity: "page"},
+	{Long: 6 * time.Hour, Short: 30 * time.Minute, Budget: 0.05, Severity: "page"},
+	{Long: 24 * time.Hour, Short: 2 * time.Hour, Budget: 0.1, Severity: "ticket"},
+	{Long: 72 * time.Hour, Short: 6 * time.Hour, Budget: 0.1, Severity: "ticket"},
+}
+
+func (r burnRule) name() string {
+	return windowName(r.Long)
+}
+
+// windowName  e.g. 5m, 6h, 3d
+func windowName(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+// threshold  burn rate at which Budget of the error budget of window is consumed within Long
+func (r burnRule) threshold(window time.Duration) float64 {
+	return r.Budget * float64(window) / float64(r.Long)
+}
+
+type sloBucket struct {
+	idx    int64
+	total  float64
+	failed float64
+}
+
+// sloRing  probe counts in fixed size time buckets
+type sloRing struct {
+	size    time.Duration
+	buckets []sloBucket
+}
+
+func newSLORing(size, span time.Duration) *sloRing {
+	return &sloRing{
+		size:    size,
+		buckets: make([]sloBucket, int(span/size)+1),
+	}
+}
+
+func (r *sloRing) add(now time.Time, failed float64) {
+	idx := now.UnixNano() / int64(r.size)
+	b := &r.buckets[idx%int64(len(r.buckets))]
+	if b.idx != idx {
+		*b = sloBucket{idx: idx}
+	}
+	b.total++
+	b.failed += failed
+}
+
+// sloBucketState  a bucket of sloRing, as it is persisted
+type sloBucketState struct {
+	Idx    int64   `json:"idx"`
+	Total  float64 `json:"total"`
+	Failed float64 `json:"failed"`
+}
+
+// snapshot  non empty buckets of r
+func (r *sloRing) snapshot() []sloBucketState {
+	ret := []sloBucketState{}
+	for _, b := range r.buckets {
+		if b.total > 0 {
+			ret = append(ret, sloBucketState{Idx: b.idx, Total: b.total, Failed: b.failed})
+		}
+	}
+	return ret
+}
+
+// restore  merge bs into r, counts of a bucket r already has are added to it,
+// buckets older than the ones in r are dropped
+func (r *sloRing) restore(bs []sloBucketState) {
+	for _, s := range bs {
+		b := &r.buckets[s.Idx%int64(len(r.buckets))]
+		switch {
+		case b.idx > s.Idx:
+			continue
+		case b.idx < s.Idx:
+			*b = sloBucket{idx: s.Idx}
+		}
+		b.total += s.Total
+		b.failed += s.Failed
+	}
+}
+
+// sum  probes within d before now, rounded up to bucket size
+func (r *sloRing) sum(now time.Time, d time.Duration) (total, failed float64) {
+	cur := now.UnixNano() / int64(r.size)
+	from := now.Add(-d).UnixNano() / int64(r.size)
+	for _, b := range r.buckets {
+		if b.idx >= from && b.idx <= cur {
+			total += b.total
+			failed += b.failed
+		}
+	}
+	return total, failed
+}
+
+// sloTracker  probe results of a deployment for evaluating its SLO
+type sloTracker struct {
+	lock   sync.Mutex
+	fine   *sloRing
+	coarse *sloRing
+	// alerted  when each firing burn rule was alerted last
+	alerted map[string]time.Time
+}
+
+func newSLOTracker() *sloTracker {
+	return &sloTracker{
+		fine:    newSLORing(sloFineBucket, sloFineSpan),
+		coarse:  newSLORing(sloCoarseBucket, maxSLOWindow),
+		alerted: map[string]time.Time{},
+	}
+}
+
+func (t *sloTracker) record(result probe.Result, now time.Time) {
+	if result == probe.Unknown {
+		return
+	}
+	w := failWeight(result)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.fine.add(now, w)
+	t.coarse.add(now, w)
+}
+
+func (t *sloTracker) sum(now time.Time, d time.Duration) (total, failed float64) {
+	if d <= sloFineSpan {
+		return t.fine.sum(now, d)
+	}
+	return t.coarse.sum(now, d)
+}
+
+// coarseState  hourly buckets of t, which are persisted
+func (t *sloTracker) coarseState() []sloBucketState {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.coarse.snapshot()
+}
+
+// restoreCoarse  merge persisted hourly buckets into t
+func (t *sloTracker) restoreCoarse(bs []sloBucketState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.coarse.restore(bs)
+}
+
+// burnRate  error rate within d relative to the error rate slo allows, ok is false if too few probes
+func (t *sloTracker) burnRate(slo *SLO, now time.Time, d time.Duration) (float64, bool) {
+	total, failed := t.sum(now, d)
+	if total < sloMinProbes {
+		return 0, false
+	}
+	return failed / total / (1 - slo.Objective), true
+}
+
+// SLOStatus  error budget and burn rates of a deployment
+type SLOStatus struct {
+	Name      types.DeployName `json:"name"`
+	SLO       SLO              `json:"slo"`
+	Probes    float64          `json:"probes"`
+	ErrorRate float64          `json:"errorRate"`
+	// BudgetRemaining  ratio of error budget left in window, negative if overspent.
+	// hourly probe counts are persisted each time SLOs are checked, so it survives restart,
+	// but probes since the last check before a restart are lost, and windows up to 6h start empty
+	BudgetRemaining float64 `json:"budgetRemaining"`
+	// BurnRates  by window, e.g. 1h
+	BurnRates map[string]float64 `json:"burnRates,omitempty"`
+	// Firing  burn rules firing, by their long window
+	Firing []string `json:"firing,omitempty"`
+	// alert  firing rules which should be alerted now
+	alert []burnRule
+}
+
+// evaluate  compute error budget and burn rates of slo at now
+func (t *sloTracker) evaluate(name types.DeployName, slo SLO, now time.Time) *SLOStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	window := slo.window()
+	ret := &SLOStatus{
+		Name:            name,
+		SLO:             slo,
+		BudgetRemaining: 1,
+		BurnRates:       map[string]float64{},
+	}
+
+	total, failed := t.sum(now, window)
+	ret.Probes = total
+	if total > 0 {
+		ret.ErrorRate = failed / total
+		ret.BudgetRemaining = 1 - ret.ErrorRate/(1-slo.Objective)
+	}
+
+	firing := map[string]bool{}
+	for _, r := range burnRules {
+		if r.Long > window {
+			continue
+		}
+		long, ok := t.burnRate(&slo, now, r.Long)
+		if !ok {
+			continue
+		}
+		ret.BurnRates[r.name()] = long
+		short, ok := t.burnRate(&slo, now, r.Short)
+		if !ok {
+			continue
+		}
+		ret.BurnRates[windowName(r.Short)] = short
+
+		thr := r.threshold(window)
+		if long < thr || short < thr {
+			continue
+		}
+		firing[r.name()] = true
+		ret.Firing = append(ret.Firing, r.name())
+		if last, ok := t.alerted[r.name()]; !ok || now.Sub(last) >= sloRealert {
+			t.alerted[r.name()] = now
+			ret.alert = append(ret.alert, r)
+		}
+	}
+
+	for k := range t.alerted {
+		if !firing[k] {
+			delete(t.alerted, k)
+		}
+	}
+	return ret
+}
+
+func loadSLOs(stage types.Stage) func() (map[types.DeployName]SLO, error) {
+	return func() (map[types.DeployName]SLO, error) {
+		store, err := generic.GetStoreInstance(etcdkey.JavaSLODir(stage), false)
+		if err != nil {
+			return nil, err
+		}
+
+		dat := map[string]SLO{}
+		if err := store.List(context.Background(), "", generic.Everything, dat); err != nil {
+			return nil, err
+		}
+
+		ret := make(map[types.DeployName]SLO, len(dat))
+		for k, v := range dat {
+			if err := v.Validate(); err != nil {
+				glog.Warningf("java service: %v slo of %v ignored: %v", stage, k, err)
+				continue
+			}
+			ret[types.DeployName(k)] = v
+		}
+		return ret, nil
+	}
+}
+
+// sloStateStore  persist hourly probe counts of deployments
+type sloStateStore interface {
+	load(name types.DeployName) ([]sloBucketState, error)
+	save(name types.DeployName, bs []sloBucketState) error
+}
+
+// etcdSLOStateStore  sloStateStore at etcdkey.JavaSLOStateDir
+type etcdSLOStateStore struct {
+	stage types.Stage
+}
+
+func (s etcdSLOStateStore) load(name types.DeployName) ([]sloBucketState, error) {
+	store, err := generic.GetStoreInstance(etcdkey.JavaSLOStateDir(s.stage), false)
+	if err != nil {
+		return nil, err
+	}
+	ret := []sloBucketState{}
+	if err := store.Get(context.Background(), string(name), &ret, true); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s etcdSLOStateStore) save(name types.DeployName, bs []sloBucketState) error {
+	store, err := generic.GetStoreInstance(etcdkey.JavaSLOStateDir(s.stage), false)
+	if err != nil {
+		return err
+	}
+	return store.Update(context.Background(), string(name), bs, nil, 0)
+}
+
+// sloTracker  tracker of name, a new tracker restores persisted probe counts
+func (m *manager) sloTracker(name types.DeployName) *sloTracker {
+	m.lock.Lock()
+	t := m.slos[name]
+	if t != nil {
+		m.lock.Unlock()
+		return t
+	}
+	t = newSLOTracker()
+	m.slos[name] = t
+	m.lock.Unlock()
+
+	if m.sloStates == nil {
+		return t
+	}
+	bs, err := m.sloStates.load(name)
+	if err != nil {
+		glog.Warningf("java service: %v restore slo probes of %v: %v", m.stage, name, err)
+		return t
+	}
+	t.restoreCoarse(bs)
+	return t
+}
+
+// CheckSLOs  evaluate SLOs of deployments, alert those whose error budget is burning too fast
+func (m *manager) CheckSLOs() ([]*SLOStatus, error) {
+	slos, err := m.sloPolicies()
+	if err != nil {
+		return nil, errors.Wrap(err, "slo: load objectives")
+	}
+
+	now := time.Now()
+	ret := make([]*SLOStatus, 0, len(slos))
+	var alerts []alert.Message
+	for name, slo := range slos {
+		t := m.sloTracker(name)
+		st := t.evaluate(name, slo, now)
+		ret = append(ret, st)
+		if m.sloStates != nil {
+			if err := m.sloStates.save(name, t.coarseState()); err != nil {
+				glog.Warningf("java service: %v save slo probes of %v: %v", m.stage, name, err)
+			}
+		}
+
+		parts := strings.Split(string(name), ":")
+		for _, r := range st.alert {
+			thr := r.threshold(slo.window())
+			alerts = append(alerts, alert.Message{
+				Labels: map[string]string{
+					"proj":     parts[0],
+					"env":      m.stage.String(),
+					"from":     "dolphin",
+					"why":      "SLO错误预算消耗过快",
+					"severity": r.Severity,
+				},
+				Annotations: map[string]string{
+					"time": now.Local().Format(time.Kitchen),
+					"msg": fmt.Sprintf("%v 拨测成功率目标 %v%%, 近%v 消耗速率 %.1f, 近%v 消耗速率 %.1f, 超过 %.1f, 剩余错误预算 %.1f%%",
+						name, slo.Objective*100, r.name(), st.BurnRates[r.name()], windowName(r.Short),
+						st.BurnRates[windowName(r.Short)], thr, st.BudgetRemaining*100),
+				},
+			})
+		}
+	}
+
+	if len(alerts) > 0 {
+		go alert.SendAlerts(alerts...)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+// RunSLO  check SLOs every interval, until ctx is done
+func (m *manager) RunSLO(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.stopC:
+			return
+		case <-ticker.C:
+			if _, err := m.CheckSLOs(); err != nil {
+				glog.Errorf("java service: %v check slo: %v", m.stage, err)
+			}
+		}
+	}
+}
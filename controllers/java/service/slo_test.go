@@ -0,0 +1,145 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/probe"
+	mytime "we.com/jiabiao/common/time"
+)
+
+func Test_sloTracker_evaluate(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	slo := SLO{Objective: 0.99}
+
+	// period  probed every 5s from begin to end, with fail ratio ratio
+	type period struct {
+		begin, end time.Duration
+		ratio      float64
+	}
+
+	tests := []struct {
+		name        string
+		periods     []period
+		at          time.Duration
+		wantFiring  []string
+		wantBudget  float64
+		wantAlerted int
+	}{
+		{
+			name:       "healthy",
+			periods:    []period{{0, 24 * time.Hour, 0}},
+			at:         24 * time.Hour,
+			wantBudget: 1,
+		},
+		{
+			name:        "outage",
+			periods:     []period{{0, 23 * time.Hour, 0}, {23 * time.Hour, 24 * time.Hour, 1}},
+			at:          24 * time.Hour,
+			wantFiring:  []string{"1h", "6h", "1d", "3d"},
+			wantBudget:  1 - (1.0/24)/0.01,
+			wantAlerted: 4,
+		},
+		{
+			name:       "outage ended",
+			periods:    []period{{0, 23 * time.Hour, 0}, {23 * time.Hour, 24 * time.Hour, 1}, {24 * time.Hour, 31 * time.Hour, 0}},
+			at:         31 * time.Hour,
+			wantBudget: 1 - (1.0/31)/0.01,
+		},
+		{
+			name:        "slow burn",
+			periods:     []period{{0, 48 * time.Hour, 0.04}},
+			at:          48 * time.Hour,
+			wantFiring:  []string{"1d", "3d"},
+			wantBudget:  1 - 0.04/0.01,
+			wantAlerted: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newSLOTracker()
+			for _, p := range tt.periods {
+				n, failed := 0, 0.0
+				for d := p.begin; d < p.end; d += 5 * time.Second {
+					n++
+					result := probe.Success
+					if float64(n)*p.ratio-failed >= 1 {
+						failed++
+						result = probe.Failure
+					}
+					tr.record(result, start.Add(d))
+				}
+			}
+
+			now := start.Add(tt.at)
+			st := tr.evaluate("crm", slo, now)
+			if len(st.Firing) != len(tt.wantFiring) {
+				t.Fatalf("evaluate() firing %v, want %v, burn rates %v", st.Firing, tt.wantFiring, st.BurnRates)
+			}
+			for i := range st.Firing {
+				if st.Firing[i] != tt.wantFiring[i] {
+					t.Errorf("evaluate() firing %v, want %v", st.Firing, tt.wantFiring)
+				}
+			}
+			if math.Abs(st.BudgetRemaining-tt.wantBudget) > 0.05 {
+				t.Errorf("evaluate() budget remaining %v, want %v", st.BudgetRemaining, tt.wantBudget)
+			}
+			if len(st.alert) != tt.wantAlerted {
+				t.Errorf("evaluate() alerts %d rules, want %d", len(st.alert), tt.wantAlerted)
+			}
+
+			if st = tr.evaluate("crm", slo, now.Add(time.Minute)); len(st.alert) != 0 {
+				t.Errorf("evaluate() alerts %d rules again within %v", len(st.alert), sloRealert)
+			}
+		})
+	}
+}
+
+func Test_sloRing_restore(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	saved := newSLORing(time.Hour, 3*time.Hour)
+	for i := 0; i < 5; i++ {
+		saved.add(start.Add(time.Duration(i)*time.Hour), float64(i%2))
+	}
+
+	// probes after restart fall in the same bucket as the last saved one
+	r := newSLORing(time.Hour, 3*time.Hour)
+	now := start.Add(4*time.Hour + time.Minute)
+	r.add(now, 1)
+	r.restore(saved.snapshot())
+
+	total, failed := r.sum(now, 3*time.Hour)
+	if total != 5 || failed != 3 {
+		t.Errorf("sum() after restore = %v, %v, want 5, 3", total, failed)
+	}
+	if total, _ := r.sum(now, 10*time.Hour); total != 5 {
+		t.Errorf("sum() of buckets dropped by the ring = %v, want 5", total)
+	}
+}
+
+func TestSLO_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		slo     SLO
+		wantErr bool
+	}{
+		{"default window", SLO{Objective: 0.999}, false},
+		{"7d", SLO{Objective: 0.99, Window: mytime.Duration(7 * 24 * time.Hour)}, false},
+		{"objective 1", SLO{Objective: 1}, true},
+		{"percentage", SLO{Objective: 99.9}, true},
+		{"window too long", SLO{Objective: 0.99, Window: mytime.Duration(90 * 24 * time.Hour)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.slo.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
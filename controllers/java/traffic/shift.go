@@ -0,0 +1,218 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+// Package traffic shift traffic of java services between versions by rewriting routes
+package traffic
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/controllers/java/router"
+	ctypes "we.com/dolphin/controllers/types"
+	"we.com/dolphin/types"
+)
+
+var (
+	// ErrReverted  new version is unhealthy, traffic is reverted to the legacy version
+	ErrReverted = errors.New("traffic: new version unhealthy, route reverted")
+	// ErrHealthUnknown  no fail ratio of the new version is known during a step, though the service is probed
+	ErrHealthUnknown = errors.New("traffic: health of new version unknown, route reverted")
+)
+
+const (
+	defaultMaxFailRatio  = 0.2
+	defaultStepDuration  = 2 * time.Minute
+	defaultCheckInterval = 10 * time.Second
+)
+
+var (
+	defaultSteps = []float64{0.1, 0.5, 1}
+)
+
+// Shifter  shift traffic of java services step by step, by rewriting routes
+type Shifter struct {
+	backend discovery.Backend
+	// Steps percents of traffic to the new version, the last one should be 1
+	Steps []float64
+	// StepDuration  time to watch the new version after each step
+	StepDuration  time.Duration
+	CheckInterval time.Duration
+	// MaxFailRatio  route is reverted when fail ratio exceeds it
+	MaxFailRatio float64
+}
+
+var _ ctypes.TrafficShifter = &Shifter{}
+
+// NewShifter create a shifter with default steps: 10%, 50%, 100%
+func NewShifter(backend discovery.Backend) *Shifter {
+	return &Shifter{
+		backend:       backend,
+		Steps:         defaultSteps,
+		StepDuration:  defaultStepDuration,
+		CheckInterval: defaultCheckInterval,
+		MaxFailRatio:  defaultMaxFailRatio,
+	}
+}
+
+// Shift  implements ctypes.TrafficShifter, deployments other than java are ignored.
+// traffic is not shifted if health is nil, or the service has no route node.
+// the original default rules are restored once all traffic is shifted to version to
+func (s *Shifter) Shift(ctx context.Context, key types.DeployKey, from, to types.DeployVer, health ctypes.HealthChecker) error {
+	pt, dn, err := types.ParseDeployKey(key)
+	if err != nil {
+		return err
+	}
+	if pt != types.ProjectType("java") {
+		return nil
+	}
+	name := types.DeployName(dn)
+
+	if health == nil {
+		glog.Warningf("traffic: %v no health checker, traffic is not shifted", name)
+		return nil
+	}
+
+	orig, err := s.backend.GetRouteNode(name)
+	if errors.Cause(err) == discovery.ErrRouteNotExist {
+		glog.V(4).Infof("traffic: %v has no route node, traffic is not shifted", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var (
+		version   = orig.Version
+		fromNodes []string
+		toNodes   []string
+	)
+	for _, p := range s.Steps {
+		fromNodes, toNodes, err = s.nodes(name, from, to)
+		if err != nil {
+			return s.revert(name, orig, version, err)
+		}
+
+		rc, actual, err := router.ShiftVersion(orig.Config, fromNodes, toNodes, string(to), p)
+		if err != nil {
+			return s.revert(name, orig, version, err)
+		}
+
+		node, err := s.backend.UpdateRouteConfig(name, rc, version)
+		if err != nil {
+			return s.revert(name, orig, version, err)
+		}
+		version = node.Version
+		glog.Infof("traffic: %v %.0f%% to version %v, from %v", name, actual*100, to, from)
+
+		if err := s.watch(ctx, key, to, health); err != nil {
+			return s.revert(name, orig, version, err)
+		}
+	}
+
+	return s.restore(name, orig, version, from, fromNodes)
+}
+
+// restore  write the original route back after traffic is shifted, so the version pin of the last step
+// does not bypass later shifts. it is kept if the original default rules route to the legacy version
+func (s *Shifter) restore(name types.DeployName, orig *discovery.RouteNode, version int64, from types.DeployVer, fromNodes []string) error {
+	if version == orig.Version {
+		return nil
+	}
+	if router.PinsDefault(orig.Config, string(from), fromNodes) {
+		glog.Warningf("traffic: %v default route pins legacy version %v, route to the new version is kept", name, from)
+		return nil
+	}
+
+	if _, err := s.backend.UpdateRouteConfig(name, orig.Config, version); err != nil {
+		return errors.Wrapf(err, "traffic: restore route of %v", name)
+	}
+	glog.Infof("traffic: %v original route restored", name)
+	return nil
+}
+
+// nodes instance node names of version from and to
+func (s *Shifter) nodes(name types.DeployName, from, to types.DeployVer) ([]string, []string, error) {
+	ss, err := s.backend.GetInstanceList(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fromNodes, toNodes []string
+	for _, n := range ss {
+		switch types.DeployVer(n.Version) {
+		case from:
+			fromNodes = append(fromNodes, n.NodeName)
+		case to:
+			toNodes = append(toNodes, n.NodeName)
+		}
+	}
+	return fromNodes, toNodes, nil
+}
+
+// watch  check fail ratio of the new version for a step, prefer fail ratio of the version
+// if health knows it, or the fail ratio of the whole service.
+// if no fail ratio is known during the step, the step passes with a warning if health says the service
+// is not probed, as canary updates do, otherwise it fails closed with ErrHealthUnknown
+func (s *Shifter) watch(ctx context.Context, key types.DeployKey, ver types.DeployVer, health ctypes.HealthChecker) error {
+	deadline := time.NewTimer(s.StepDuration)
+	defer deadline.Stop()
+	ticker := time.NewTicker(s.CheckInterval)
+	defer ticker.Stop()
+
+	known := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			if known {
+				return nil
+			}
+			if ph, ok := health.(ctypes.ProbedHealthChecker); ok && !ph.Probed(key) {
+				glog.Warningf("traffic: %v is not probed, version %v is not health checked", key, ver)
+				return nil
+			}
+			return errors.Wrapf(ErrHealthUnknown, "version %v", ver)
+		case <-ticker.C:
+			r, ok := failRatio(key, ver, health)
+			if !ok {
+				continue
+			}
+			known = true
+			if r > s.MaxFailRatio {
+				return errors.Wrapf(ErrReverted, "fail ratio %.2f exceeds %.2f", r, s.MaxFailRatio)
+			}
+		}
+	}
+}
+
+func failRatio(key types.DeployKey, ver types.DeployVer, health ctypes.HealthChecker) (float64, bool) {
+	if health == nil {
+		return 0, false
+	}
+	if vh, ok := health.(ctypes.VersionHealthChecker); ok {
+		if r, ok := vh.VersionFailRatio(key, ver); ok {
+			return r, true
+		}
+	}
+	return health.FailRatio(key)
+}
+
+// revert  write the original route back, cause is returned with revert error if any
+func (s *Shifter) revert(name types.DeployName, orig *discovery.RouteNode, version int64, cause error) error {
+	glog.Errorf("traffic: %v revert route: %v", name, cause)
+	if version == orig.Version {
+		return cause
+	}
+
+	if _, err := s.backend.UpdateRouteConfig(name, orig.Config, version); err != nil {
+		return errors.Wrapf(cause, "revert route failed: %v", err)
+	}
+	return cause
+}
@@ -0,0 +1,115 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package traffic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/controllers/java/router"
+	"we.com/dolphin/types"
+)
+
+type fakeBackend struct {
+	discovery.Backend
+	route   *discovery.RouteNode
+	nodes   []*router.ServiceNode
+	written []*router.RouteCfg
+}
+
+func (f *fakeBackend) GetRouteNode(name types.DeployName) (*discovery.RouteNode, error) {
+	if f.route == nil {
+		return &discovery.RouteNode{Name: name}, discovery.ErrRouteNotExist
+	}
+	return f.route, nil
+}
+
+func (f *fakeBackend) GetInstanceList(name types.DeployName) ([]*router.ServiceNode, error) {
+	return f.nodes, nil
+}
+
+func (f *fakeBackend) UpdateRouteConfig(name types.DeployName, rc *router.RouteCfg, version int64) (*discovery.RouteNode, error) {
+	if version != f.route.Version {
+		return nil, discovery.ErrRouteConflict
+	}
+	f.written = append(f.written, rc)
+	f.route = &discovery.RouteNode{Name: name, Version: version + 1, Config: rc}
+	return f.route, nil
+}
+
+type fakeHealth struct {
+	ratio    float64
+	known    bool
+	noProbes bool
+}
+
+func (f fakeHealth) FailRatio(key types.DeployKey) (float64, bool) {
+	return f.ratio, f.known
+}
+
+func (f fakeHealth) Probed(key types.DeployKey) bool {
+	return !f.noProbes
+}
+
+func newTestShifter(b *fakeBackend) *Shifter {
+	s := NewShifter(b)
+	s.Steps = []float64{0.5, 1}
+	s.StepDuration = 30 * time.Millisecond
+	s.CheckInterval = 5 * time.Millisecond
+	return s
+}
+
+func TestShifter_Shift(t *testing.T) {
+	const key = types.DeployKey("java/crm")
+	nodes := []*router.ServiceNode{
+		{NodeName: "1_1", Version: "v1"},
+		{NodeName: "1_2", Version: "v2"},
+	}
+	orig := &router.RouteCfg{
+		APIVersion: router.APIV4,
+		RouteItems: []router.RouteItem{{
+			Src: router.Match{Key: "host", OP: router.OPeq, Value: []string{"10.0.0.1"}},
+			Dst: router.Match{Key: router.KeyVersion, OP: router.OPeq, Value: []string{"v1"}},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		route   *router.RouteCfg
+		health  fakeHealth
+		wantErr error
+		// writes  num of routes written
+		writes int
+	}{
+		{"no route node", nil, fakeHealth{0, true, false}, nil, 0},
+		{"health unknown", orig, fakeHealth{0, false, false}, ErrHealthUnknown, 2},
+		{"not probed", orig, fakeHealth{0, false, true}, nil, 3},
+		{"unhealthy", orig, fakeHealth{0.5, true, false}, ErrReverted, 2},
+		{"restored", orig, fakeHealth{0, true, false}, nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &fakeBackend{nodes: nodes}
+			if tt.route != nil {
+				b.route = &discovery.RouteNode{Version: 1, Config: tt.route}
+			}
+
+			err := newTestShifter(b).Shift(context.Background(), key, "v1", "v2", tt.health)
+			if errors.Cause(err) != tt.wantErr {
+				t.Fatalf("Shift() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(b.written) != tt.writes {
+				t.Fatalf("Shift() wrote %d routes, want %d", len(b.written), tt.writes)
+			}
+			if tt.writes > 0 && b.route.Config != tt.route {
+				t.Errorf("route after Shift() = %v, want the original one", b.route.Config)
+			}
+		})
+	}
+}
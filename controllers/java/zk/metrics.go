@@ -0,0 +1,70 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package zk
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"we.com/dolphin/types"
+)
+
+var (
+	sessionConnected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dolphin",
+		Subsystem: "java_zk",
+		Name:      "session_connected",
+		Help:      "1 if zk session of stage is established, 0 otherwise",
+	}, []string{"stage"})
+
+	sessionExpired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dolphin",
+		Subsystem: "java_zk",
+		Name:      "session_expired_total",
+		Help:      "number of expired zk sessions",
+	}, []string{"stage"})
+
+	resyncTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dolphin",
+		Subsystem: "java_zk",
+		Name:      "resync_total",
+		Help:      "number of full syncs from zk to etcd, by result",
+	}, []string{"stage", "result"})
+
+	lastSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dolphin",
+		Subsystem: "java_zk",
+		Name:      "last_sync_timestamp_seconds",
+		Help:      "unix time of the last successful full sync from zk to etcd",
+	}, []string{"stage"})
+
+	syncLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dolphin",
+		Subsystem: "java_zk",
+		Name:      "sync_lag_seconds",
+		Help:      "time between the last mirrored zk change and its write to etcd, based on zk mtime",
+	}, []string{"stage"})
+
+	etcdRewatchTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dolphin",
+		Subsystem: "java_zk",
+		Name:      "etcd_rewatch_total",
+		Help:      "number of times a closed watch of an etcd dir synced to zk is reestablished",
+	}, []string{"stage", "dir"})
+)
+
+func init() {
+	prometheus.MustRegister(sessionConnected, sessionExpired, resyncTotal, lastSync, syncLag, etcdRewatchTotal)
+}
+
+// observeLag  mtime is modify time of zk node in milliseconds
+func observeLag(stage types.Stage, mtime int64) {
+	lag := time.Since(time.Unix(0, mtime*int64(time.Millisecond)))
+	if lag < 0 {
+		lag = 0
+	}
+	syncLag.WithLabelValues(stage.String()).Set(lag.Seconds())
+}
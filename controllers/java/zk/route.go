@@ -0,0 +1,185 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package zk
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/controllers/java/router"
+	"we.com/dolphin/types"
+)
+
+func (m *manager) routePath(name types.DeployName) (string, error) {
+	path, err := m.zkPathInfor.GetRoutePath(name)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return "", errors.Errorf("dont known zk path form %v", name)
+	}
+	return path, nil
+}
+
+// ParseRoute  parse and validate route content of name
+func (m *manager) ParseRoute(name types.DeployName, content string) (*router.RouteCfg, error) {
+	return discovery.ParseRoute(content, m.zkPathInfor.GetAPIVersion(name))
+}
+
+// LintRoute  lint rc against live zk instances of name
+func (m *manager) LintRoute(name types.DeployName, rc *router.RouteCfg) (router.Issues, error) {
+	return discovery.LintRoute(m, name, rc)
+}
+
+// GetRouteNode  read route of name from zk
+func (m *manager) GetRouteNode(name types.DeployName) (*discovery.RouteNode, error) {
+	path, err := m.routePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, ver, err := m.zkClient.GetNode(path)
+	if err == zk.ErrNoNode {
+		return &discovery.RouteNode{Name: name, Path: path}, errors.Wrapf(discovery.ErrRouteNotExist, "%v", name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "zk: get route of %v", name)
+	}
+
+	ret := &discovery.RouteNode{
+		Name:    name,
+		Path:    path,
+		Version: int64(ver),
+		Content: content,
+	}
+
+	rc, err := router.Parse(content, m.zkPathInfor.GetAPIVersion(name))
+	if err != nil {
+		return ret, errors.Wrapf(err, "zk: parse route of %v", name)
+	}
+	ret.Config = rc
+	return ret, nil
+}
+
+// checkWrite  validate rc, and returns the path of route node of name
+func (m *manager) checkWrite(name types.DeployName, rc *router.RouteCfg) (string, router.Issues, error) {
+	if err := rc.Validate(); err != nil {
+		return "", nil, err
+	}
+	if ver := m.zkPathInfor.GetAPIVersion(name); ver != rc.APIVersion {
+		return "", nil, errors.Errorf("zk: route of %v should be api %v, got %v", name, ver, rc.APIVersion)
+	}
+	issues, err := discovery.CheckWrite(m, name, rc)
+	if err != nil {
+		return "", nil, err
+	}
+
+	path, err := m.routePath(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, issues, nil
+}
+
+// CreateRouteConfig  create route node of name with rc, ErrRouteExist is returned if it exists
+func (m *manager) CreateRouteConfig(name types.DeployName, rc *router.RouteCfg) (*discovery.RouteNode, error) {
+	path, issues, err := m.checkWrite(name, rc)
+	if err != nil {
+		return nil, err
+	}
+
+	content := rc.String()
+	err = m.zkClient.CreateNode(path, content)
+	if err == zk.ErrNodeExists {
+		return nil, errors.Wrapf(discovery.ErrRouteExist, "%v", name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "zk: create route of %v", name)
+	}
+
+	m.lock.Lock()
+	m.routeCfg[name] = rc
+	m.lock.Unlock()
+
+	return &discovery.RouteNode{
+		Name:    name,
+		Path:    path,
+		Content: content,
+		Config:  rc,
+		Issues:  issues,
+	}, nil
+}
+
+// UpdateRouteConfig  write rc to route node of name, only if the node version is still version
+func (m *manager) UpdateRouteConfig(name types.DeployName, rc *router.RouteCfg, version int64) (*discovery.RouteNode, error) {
+	if version < 0 || version > math.MaxInt32 {
+		return nil, errors.Wrapf(discovery.ErrRouteConflict, "%v version %d", name, version)
+	}
+	path, issues, err := m.checkWrite(name, rc)
+	if err != nil {
+		return nil, err
+	}
+
+	content := rc.String()
+	nv, err := m.zkClient.SetNodeValueVersion(path, content, int32(version))
+	if err == zk.ErrBadVersion {
+		return nil, errors.Wrapf(discovery.ErrRouteConflict, "%v version %d", name, version)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "zk: set route of %v", name)
+	}
+
+	m.lock.Lock()
+	m.routeCfg[name] = rc
+	m.lock.Unlock()
+
+	return &discovery.RouteNode{
+		Name:    name,
+		Path:    path,
+		Version: int64(nv),
+		Content: content,
+		Config:  rc,
+		Issues:  issues,
+	}, nil
+}
+
+// RemoveInstance  delete instance node of name, if it is still the registration of node
+func (m *manager) RemoveInstance(name types.DeployName, node *router.ServiceNode) error {
+	dir, err := m.zkPathInfor.GetInstancePath(name)
+	if err != nil {
+		return err
+	}
+	path := dir + node.NodeName
+
+	b, stat, err := m.zkClient.client.Get(path)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "zk: get instance %v", path)
+	}
+
+	cur := router.ServiceNode{}
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return errors.Wrapf(err, "zk: decode instance %v", path)
+	}
+	cur.NodeName = node.NodeName
+	if !discovery.SameInstance(&cur, node) {
+		return errors.Wrapf(discovery.ErrInstanceChanged, "zk: %v", path)
+	}
+
+	err = m.zkClient.DeleteNode(path, stat.Version)
+	switch err {
+	case nil, zk.ErrNoNode:
+		return nil
+	case zk.ErrBadVersion:
+		return errors.Wrapf(discovery.ErrInstanceChanged, "zk: %v", path)
+	}
+	return errors.Wrapf(err, "zk: delete instance %v", path)
+}
@@ -0,0 +1,77 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package zk
+
+import (
+	"context"
+
+	"github.com/golang/glog"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// sessionTracker  follows state of a zk session,
+// events may be missed while session is lost, and all watches are gone once it expired
+type sessionTracker struct {
+	state      zk.State
+	hasSession bool
+	lost       bool
+}
+
+// next  move to state, returns true if data should be resynced
+func (t *sessionTracker) next(state zk.State) bool {
+	t.state = state
+	switch state {
+	case zk.StateHasSession:
+		resync := t.lost
+		t.hasSession = true
+		t.lost = false
+		return resync
+	case zk.StateDisconnected, zk.StateExpired:
+		// data is loaded when first session established
+		t.lost = t.hasSession
+	}
+	return false
+}
+
+// watchSession  request a resync each time a lost session is reestablished
+func (m *manager) watchSession(ctx context.Context, events <-chan zk.Event) {
+	stage := m.stage.String()
+	t := sessionTracker{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Type != zk.EventSession {
+				continue
+			}
+
+			glog.V(4).Infof("zk: %v session state: %v", m.stage, ev.State)
+			resync := t.next(ev.State)
+			switch ev.State {
+			case zk.StateHasSession:
+				sessionConnected.WithLabelValues(stage).Set(1)
+			case zk.StateExpired:
+				glog.Warningf("zk: %v session expired", m.stage)
+				sessionExpired.WithLabelValues(stage).Inc()
+				sessionConnected.WithLabelValues(stage).Set(0)
+			case zk.StateDisconnected:
+				glog.Warningf("zk: %v disconnected", m.stage)
+				sessionConnected.WithLabelValues(stage).Set(0)
+			}
+
+			if resync {
+				select {
+				case m.resync <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package zk
+
+import (
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func Test_sessionTracker_next(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []zk.State
+		want   []bool
+	}{
+		{
+			name:   "first session",
+			states: []zk.State{zk.StateConnecting, zk.StateConnected, zk.StateHasSession},
+			want:   []bool{false, false, false},
+		},
+		{
+			name:   "disconnected before first session",
+			states: []zk.State{zk.StateConnecting, zk.StateDisconnected, zk.StateConnected, zk.StateHasSession},
+			want:   []bool{false, false, false, false},
+		},
+		{
+			name:   "reconnect",
+			states: []zk.State{zk.StateHasSession, zk.StateDisconnected, zk.StateConnecting, zk.StateConnected, zk.StateHasSession},
+			want:   []bool{false, false, false, false, true},
+		},
+		{
+			name:   "expired",
+			states: []zk.State{zk.StateHasSession, zk.StateDisconnected, zk.StateExpired, zk.StateConnected, zk.StateHasSession, zk.StateHasSession},
+			want:   []bool{false, false, false, false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := sessionTracker{}
+			for i, s := range tt.states {
+				if got := tr.next(s); got != tt.want[i] {
+					t.Errorf("sessionTracker.next(%v) at %d = %v, want %v", s, i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
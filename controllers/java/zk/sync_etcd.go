@@ -0,0 +1,670 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package zk
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"we.com/dolphin/controllers/java/discovery"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/registry/watch"
+	"we.com/dolphin/types"
+)
+
+// route and config nodes are synced both ways between zk and etcd:
+// changes in zk are mirrored to etcd, and changes in etcd are pushed to zk with version checks.
+// for each node the content and zk version of last sync (base) is kept, if both sides have changed
+// since then, a conflict is recorded instead of overwriting either side.
+
+var (
+	// ErrNoConflict  there is no conflict of the zk path
+	ErrNoConflict = errors.New("zk: no sync conflict")
+)
+
+// ConflictKind  why a node cannot be synced
+type ConflictKind string
+
+const (
+	// ConflictDiverged  both zk and etcd have changed since last sync
+	ConflictDiverged ConflictKind = "diverged"
+	// ConflictRejected  etcd content is refused by validation, e.g. route lint errors
+	ConflictRejected ConflictKind = "rejected"
+	// ConflictNoZKNode  zk node of etcd path does not exist, zk nodes are never created by sync
+	ConflictNoZKNode ConflictKind = "noZKNode"
+)
+
+// SyncSide  which side wins when a conflict is resolved
+type SyncSide string
+
+const (
+	SideZK   SyncSide = "zk"
+	SideEtcd SyncSide = "etcd"
+)
+
+// Conflict  a zk node and its etcd mirror that cannot be synced
+type Conflict struct {
+	Path      string       `json:"path"`
+	EtcdPath  string       `json:"etcdPath"`
+	Type      string       `json:"type"`
+	Kind      ConflictKind `json:"kind"`
+	Base      string       `json:"base"`
+	ZK        string       `json:"zk"`
+	ZKVersion int32        `json:"zkVersion"`
+	Etcd      string       `json:"etcd"`
+	Reason    string       `json:"reason,omitempty"`
+	Time      time.Time    `json:"time"`
+}
+
+// SyncReport  result of comparing route and config nodes mirrored in etcd with zk
+type SyncReport struct {
+	Stage  types.Stage `json:"stage"`
+	Time   time.Time   `json:"time"`
+	Total  int         `json:"total"`
+	InSync int         `json:"inSync"`
+	// Diverged  zk paths whose content differ from etcd
+	Diverged    []string    `json:"diverged"`
+	MissingInZK []string    `json:"missingInZK"`
+	Errors      []string    `json:"errors,omitempty"`
+	Conflicts   []*Conflict `json:"conflicts"`
+}
+
+type syncBase struct {
+	Content   string `json:"content"`
+	ZKVersion int32  `json:"zkVersion"`
+}
+
+// zkNodes  zk nodes read and written by sync, implemented by *Client
+type zkNodes interface {
+	GetNode(path string) (string, int32, error)
+	SetNodeValueVersion(path string, value string, version int32) (int32, error)
+}
+
+// etcdNodes  raw contents of zk nodes mirrored in etcd
+type etcdNodes interface {
+	get(path string) (string, bool, error)
+	put(path string, value string) error
+	// list  full path -> content of all nodes under dir
+	list(dir string) (map[string]string, error)
+}
+
+// syncStore  persist sync bases and conflicts, so they survive restart
+type syncStore interface {
+	getBase(zkPath string) (*syncBase, error)
+	saveBase(zkPath string, b *syncBase) error
+	deleteBase(zkPath string) error
+	getConflict(zkPath string) (*Conflict, error)
+	listConflicts() ([]*Conflict, error)
+	saveConflict(c *Conflict) error
+	deleteConflict(zkPath string) error
+}
+
+type syncer struct {
+	stage types.Stage
+	// serialize syncs, so a write to one side is recorded before its echo from the other side is handled
+	lock  sync.Mutex
+	zk    zkNodes
+	etcd  etcdNodes
+	store syncStore
+	// zkPath  map etcd path to zk path
+	zkPath func(etcdPath string) (zkTyp, string, error)
+	// check  validate content before it is pushed to zk
+	check func(typ zkTyp, zkPath string, content string) error
+}
+
+func (s *syncer) synced(zkPath, content string, version int32) error {
+	if err := s.store.saveBase(zkPath, &syncBase{Content: content, ZKVersion: version}); err != nil {
+		return err
+	}
+	return s.store.deleteConflict(zkPath)
+}
+
+func (s *syncer) conflict(c *Conflict) error {
+	c.Time = time.Now()
+	glog.Warningf("zk: %v sync conflict of %v, %v: %v", s.stage, c.Path, c.Kind, c.Reason)
+	return s.store.saveConflict(c)
+}
+
+// zkChanged  mirror zk node to etcd, unless etcd has been changed since last sync
+func (s *syncer) zkChanged(typ zkTyp, zkPath, etcdPath, content string, version int32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	base, err := s.store.getBase(zkPath)
+	if err != nil {
+		return err
+	}
+	cur, ok, err := s.etcd.get(etcdPath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case ok && cur == content:
+	case ok && base != nil && content == base.Content:
+		// only etcd has changed, keep it, it is pushed to zk by etcdChanged
+		if version == base.ZKVersion {
+			return nil
+		}
+		return s.store.saveBase(zkPath, &syncBase{Content: base.Content, ZKVersion: version})
+	case !ok || base == nil || cur == base.Content:
+		if err := s.etcd.put(etcdPath, content); err != nil {
+			return err
+		}
+	default:
+		return s.conflict(&Conflict{
+			Path:      zkPath,
+			EtcdPath:  etcdPath,
+			Type:      string(typ),
+			Kind:      ConflictDiverged,
+			Base:      base.Content,
+			ZK:        content,
+			ZKVersion: version,
+			Etcd:      cur,
+			Reason:    "both zk and etcd have changed since last sync",
+		})
+	}
+
+	return s.synced(zkPath, content, version)
+}
+
+// zkDeleted  zk node is deleted, its etcd mirror is deleted by caller
+func (s *syncer) zkDeleted(zkPath string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.store.deleteBase(zkPath); err != nil {
+		return err
+	}
+	return s.store.deleteConflict(zkPath)
+}
+
+// etcdChanged  push etcd content to zk, unless zk has been changed since last sync
+func (s *syncer) etcdChanged(etcdPath, content string) error {
+	typ, zkPath, err := s.zkPath(etcdPath)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	base, err := s.store.getBase(zkPath)
+	if err != nil {
+		return err
+	}
+	// echo of a zk change, or nothing changed
+	if base != nil && base.Content == content {
+		return nil
+	}
+
+	c := &Conflict{
+		Path:     zkPath,
+		EtcdPath: etcdPath,
+		Type:     string(typ),
+		Etcd:     content,
+	}
+	if base != nil {
+		c.Base = base.Content
+	}
+
+	cur, ver, err := s.zk.GetNode(zkPath)
+	if err == zk.ErrNoNode {
+		c.Kind = ConflictNoZKNode
+		c.Reason = "zk node does not exist"
+		return s.conflict(c)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "zk: get %v", zkPath)
+	}
+	if cur == content {
+		return s.synced(zkPath, cur, ver)
+	}
+
+	c.ZK, c.ZKVersion = cur, ver
+	if base == nil || base.ZKVersion != ver {
+		c.Kind = ConflictDiverged
+		c.Reason = "both zk and etcd have changed since last sync"
+		return s.conflict(c)
+	}
+
+	_, err = s.push(c, ver)
+	return err
+}
+
+// resyncEtcd  push every node under dirs in etcd to zk, as if it is just changed,
+// nodes already synced are skipped. it catches up changes missed while etcd is not watched
+func (s *syncer) resyncEtcd(dirs ...string) error {
+	var merr error
+	for _, dir := range dirs {
+		nodes, err := s.etcd.list(dir)
+		if err != nil {
+			merr = multierror.Append(merr, errors.Wrapf(err, "list %v", dir))
+			continue
+		}
+		for etcdPath, content := range nodes {
+			if err := s.etcdChanged(etcdPath, content); err != nil {
+				merr = multierror.Append(merr, errors.Wrapf(err, "sync %v", etcdPath))
+			}
+		}
+	}
+	return merr
+}
+
+// push  write etcd content of c to zk if zk node version is still version,
+// returns c if it cannot be pushed and is recorded as a conflict
+func (s *syncer) push(c *Conflict, version int32) (*Conflict, error) {
+	if s.check != nil {
+		if err := s.check(zkTyp(c.Type), c.Path, c.Etcd); err != nil {
+			c.Kind = ConflictRejected
+			c.Reason = err.Error()
+			return c, s.conflict(c)
+		}
+	}
+
+	nv, err := s.zk.SetNodeValueVersion(c.Path, c.Etcd, version)
+	if err == zk.ErrBadVersion {
+		c.Kind = ConflictDiverged
+		c.Reason = "zk has changed while pushing etcd content"
+		return c, s.conflict(c)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "zk: set %v", c.Path)
+	}
+
+	return nil, s.synced(c.Path, c.Etcd, nv)
+}
+
+// resolve  resolve conflict of zkPath by copying content of side use to the other side
+func (s *syncer) resolve(zkPath string, use SyncSide) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, err := s.store.getConflict(zkPath)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return errors.Wrapf(ErrNoConflict, "%v", zkPath)
+	}
+
+	switch use {
+	case SideZK:
+		cur, ver, err := s.zk.GetNode(zkPath)
+		if err != nil {
+			return errors.Wrapf(err, "zk: get %v", zkPath)
+		}
+		if err := s.etcd.put(c.EtcdPath, cur); err != nil {
+			return err
+		}
+		return s.synced(zkPath, cur, ver)
+
+	case SideEtcd:
+		cur, ok, err := s.etcd.get(c.EtcdPath)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.Errorf("zk: etcd mirror %v of %v not found", c.EtcdPath, zkPath)
+		}
+		zc, ver, err := s.zk.GetNode(zkPath)
+		if err != nil {
+			return errors.Wrapf(err, "zk: get %v", zkPath)
+		}
+		c.Etcd, c.ZK, c.ZKVersion = cur, zc, ver
+
+		left, err := s.push(c, ver)
+		if err != nil {
+			return err
+		}
+		if left != nil {
+			return errors.Errorf("zk: %v still conflicts, %v: %v", zkPath, left.Kind, left.Reason)
+		}
+		return nil
+
+	default:
+		return errors.Errorf("zk: unknown sync side %q", use)
+	}
+}
+
+// report  compare etcd nodes under dirs with zk
+func (s *syncer) report(dirs ...string) (*SyncReport, error) {
+	ret := &SyncReport{
+		Stage:       s.stage,
+		Time:        time.Now(),
+		Diverged:    []string{},
+		MissingInZK: []string{},
+	}
+
+	for _, dir := range dirs {
+		nodes, err := s.etcd.list(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for etcdPath, content := range nodes {
+			_, zkPath, err := s.zkPath(etcdPath)
+			if err != nil {
+				ret.Errors = append(ret.Errors, err.Error())
+				continue
+			}
+
+			ret.Total++
+			cur, _, err := s.zk.GetNode(zkPath)
+			switch {
+			case err == zk.ErrNoNode:
+				ret.MissingInZK = append(ret.MissingInZK, zkPath)
+			case err != nil:
+				ret.Errors = append(ret.Errors, errors.Wrapf(err, "get %v", zkPath).Error())
+			case cur == content:
+				ret.InSync++
+			default:
+				ret.Diverged = append(ret.Diverged, zkPath)
+			}
+		}
+	}
+	sort.Strings(ret.Diverged)
+	sort.Strings(ret.MissingInZK)
+	sort.Strings(ret.Errors)
+
+	cs, err := s.store.listConflicts()
+	if err != nil {
+		return nil, err
+	}
+	ret.Conflicts = cs
+	return ret, nil
+}
+
+// etcdMirror  etcdNodes stored in etcd as raw bytes
+type etcdMirror struct{}
+
+func (etcdMirror) get(path string) (string, bool, error) {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return "", false, err
+	}
+
+	var dat []byte
+	err = store.Get(context.Background(), path, &dat, false)
+	if generic.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(dat), true, nil
+}
+
+func (etcdMirror) put(path string, value string) error {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return err
+	}
+	return store.Update(context.Background(), path, []byte(value), nil, 0)
+}
+
+func (etcdMirror) list(dir string) (map[string]string, error) {
+	store, err := generic.GetStoreInstance(dir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	dat := map[string][]byte{}
+	if err := store.List(context.Background(), "", generic.Everything, dat); err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]string, len(dat))
+	for k, v := range dat {
+		ret[path.Join(dir, k)] = string(v)
+	}
+	return ret, nil
+}
+
+type etcdSyncStore struct {
+	stage types.Stage
+}
+
+func syncKey(dir, zkPath string) string {
+	return dir + strings.TrimPrefix(zkPath, "/")
+}
+
+func (s etcdSyncStore) getBase(zkPath string) (*syncBase, error) {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := syncBase{}
+	err = store.Get(context.Background(), syncKey(etcdkey.JavaZKSyncBaseDir(s.stage), zkPath), &ret, false)
+	if generic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (s etcdSyncStore) saveBase(zkPath string, b *syncBase) error {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return err
+	}
+	return store.Update(context.Background(), syncKey(etcdkey.JavaZKSyncBaseDir(s.stage), zkPath), b, nil, 0)
+}
+
+func (s etcdSyncStore) deleteBase(zkPath string) error {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return err
+	}
+	err = store.Delete(context.Background(), syncKey(etcdkey.JavaZKSyncBaseDir(s.stage), zkPath), nil)
+	if generic.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s etcdSyncStore) getConflict(zkPath string) (*Conflict, error) {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := Conflict{}
+	err = store.Get(context.Background(), syncKey(etcdkey.JavaZKConflictDir(s.stage), zkPath), &ret, false)
+	if generic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (s etcdSyncStore) listConflicts() ([]*Conflict, error) {
+	store, err := generic.GetStoreInstance(etcdkey.JavaZKConflictDir(s.stage), false)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := map[string]*Conflict{}
+	if err := store.List(context.Background(), "", generic.Everything, cs); err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Conflict, 0, len(cs))
+	for _, c := range cs {
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret, nil
+}
+
+func (s etcdSyncStore) saveConflict(c *Conflict) error {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return err
+	}
+	return store.Update(context.Background(), syncKey(etcdkey.JavaZKConflictDir(s.stage), c.Path), c, nil, 0)
+}
+
+func (s etcdSyncStore) deleteConflict(zkPath string) error {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return err
+	}
+	err = store.Delete(context.Background(), syncKey(etcdkey.JavaZKConflictDir(s.stage), zkPath), nil)
+	if generic.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// syncDirs  etcd dirs of nodes synced both ways
+func (m *manager) syncDirs() []string {
+	return []string{etcdkey.JavaZKRouteDir(m.stage), etcdkey.JavaZKConfigDir(m.stage)}
+}
+
+// checkPush  routes pushed from etcd are validated and linted as any other route write
+func (m *manager) checkPush(typ zkTyp, zkPath string, content string) error {
+	if typ != zkRoute {
+		return nil
+	}
+
+	name, err := m.zkPathInfor.GetDeployName(zkPath)
+	if err != nil {
+		return err
+	}
+	rc, err := m.ParseRoute(name, content)
+	if err != nil {
+		return err
+	}
+	_, err = discovery.CheckWrite(m, name, rc)
+	return err
+}
+
+const (
+	minWatchBackoff = time.Second
+	maxWatchBackoff = time.Minute
+)
+
+// watchEtcd  push changes of route and config nodes in etcd to zk, deletes are not pushed.
+// watches are reestablished until ctx is done
+func (m *manager) watchEtcd(ctx context.Context) error {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range m.syncDirs() {
+		w, err := store.Watch(ctx, dir, generic.Everything, true, reflect.TypeOf([]byte{}))
+		if err != nil {
+			return err
+		}
+		go m.keepWatchEtcd(ctx, store, dir, w)
+	}
+
+	return nil
+}
+
+// keepWatchEtcd  handle events of w, once it is closed dir is watched again with backoff,
+// and resynced, so changes in between are not missed
+func (m *manager) keepWatchEtcd(ctx context.Context, store generic.Interface, dir string, w watch.Interface) {
+	stage := m.stage.String()
+	backoff := minWatchBackoff
+	for {
+		start := time.Now()
+		m.handleEtcdEvents(ctx, dir, w)
+		w.Stop()
+		if ctx.Err() != nil {
+			return
+		}
+		// the watch lasted long enough, it is not failing repeatedly
+		if time.Since(start) > maxWatchBackoff {
+			backoff = minWatchBackoff
+		}
+
+		for {
+			glog.Warningf("zk: %v watch %v closed, rewatch in %v", m.stage, dir, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxWatchBackoff {
+				backoff = maxWatchBackoff
+			}
+
+			var err error
+			w, err = store.Watch(ctx, dir, generic.Everything, true, reflect.TypeOf([]byte{}))
+			if err == nil {
+				break
+			}
+			glog.Errorf("zk: %v watch %v: %v", m.stage, dir, err)
+		}
+		etcdRewatchTotal.WithLabelValues(stage, dir).Inc()
+
+		if err := m.sync.resyncEtcd(dir); err != nil {
+			glog.Errorf("zk: %v resync %v to zk: %v", m.stage, dir, err)
+		}
+	}
+}
+
+// handleEtcdEvents  returns when ctx is done, w is closed or fails
+func (m *manager) handleEtcdEvents(ctx context.Context, dir string, w watch.Interface) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			switch event.Type {
+			case watch.Error:
+				glog.Warningf("zk: %v watch %v err: %v", m.stage, dir, event.Object)
+				return
+			case watch.Added, watch.Modified:
+				dat, ok := event.Object.(*[]byte)
+				if !ok {
+					glog.Errorf("zk: %v watch %v, expect *[]byte, got %T", m.stage, dir, event.Object)
+					continue
+				}
+				etcdPath := path.Join(dir, strings.TrimPrefix(event.Key, dir))
+				if err := m.sync.etcdChanged(etcdPath, string(*dat)); err != nil {
+					glog.Errorf("zk: %v sync %v to zk: %v", m.stage, etcdPath, err)
+				}
+			}
+		}
+	}
+}
+
+// Conflicts  list zk nodes which cannot be synced with etcd
+func (m *manager) Conflicts() ([]*Conflict, error) {
+	return m.sync.store.listConflicts()
+}
+
+// ResolveConflict  resolve conflict of zkPath by copying content of side use to the other side
+func (m *manager) ResolveConflict(zkPath string, use SyncSide) error {
+	return m.sync.resolve(zkPath, use)
+}
+
+// SyncReport  compare route and config nodes in etcd with zk
+func (m *manager) SyncReport() (*SyncReport, error) {
+	return m.sync.report(m.syncDirs()...)
+}
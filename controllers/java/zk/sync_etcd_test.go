@@ -0,0 +1,333 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package zk
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+type fakeZKNode struct {
+	content string
+	version int32
+}
+
+type fakeZK map[string]*fakeZKNode
+
+func (f fakeZK) GetNode(path string) (string, int32, error) {
+	n, ok := f[path]
+	if !ok {
+		return "", 0, zk.ErrNoNode
+	}
+	return n.content, n.version, nil
+}
+
+func (f fakeZK) SetNodeValueVersion(path string, value string, version int32) (int32, error) {
+	n, ok := f[path]
+	if !ok {
+		return 0, zk.ErrNoNode
+	}
+	if n.version != version {
+		return 0, zk.ErrBadVersion
+	}
+	n.content = value
+	n.version++
+	return n.version, nil
+}
+
+type fakeEtcd map[string]string
+
+func (f fakeEtcd) get(path string) (string, bool, error) {
+	v, ok := f[path]
+	return v, ok, nil
+}
+
+func (f fakeEtcd) put(path string, value string) error {
+	f[path] = value
+	return nil
+}
+
+func (f fakeEtcd) list(dir string) (map[string]string, error) {
+	return f, nil
+}
+
+type memSyncStore struct {
+	bases     map[string]*syncBase
+	conflicts map[string]*Conflict
+}
+
+func (s memSyncStore) getBase(zkPath string) (*syncBase, error) { return s.bases[zkPath], nil }
+
+func (s memSyncStore) saveBase(zkPath string, b *syncBase) error {
+	s.bases[zkPath] = b
+	return nil
+}
+
+func (s memSyncStore) deleteBase(zkPath string) error {
+	delete(s.bases, zkPath)
+	return nil
+}
+
+func (s memSyncStore) getConflict(zkPath string) (*Conflict, error) { return s.conflicts[zkPath], nil }
+
+func (s memSyncStore) listConflicts() ([]*Conflict, error) {
+	ret := []*Conflict{}
+	for _, c := range s.conflicts {
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret, nil
+}
+
+func (s memSyncStore) saveConflict(c *Conflict) error {
+	s.conflicts[c.Path] = c
+	return nil
+}
+
+func (s memSyncStore) deleteConflict(zkPath string) error {
+	delete(s.conflicts, zkPath)
+	return nil
+}
+
+const (
+	testZKPath   = "/service/com.crm"
+	testEtcdPath = "/dolphin/uat/java/zk/route/2/crm"
+)
+
+func newTestSyncer(zkContent, etcdContent string, base *syncBase) (*syncer, fakeZK, fakeEtcd, memSyncStore) {
+	fz := fakeZK{testZKPath: &fakeZKNode{content: zkContent, version: 3}}
+	fe := fakeEtcd{testEtcdPath: etcdContent}
+	st := memSyncStore{bases: map[string]*syncBase{}, conflicts: map[string]*Conflict{}}
+	if base != nil {
+		st.bases[testZKPath] = base
+	}
+
+	s := &syncer{
+		zk:    fz,
+		etcd:  fe,
+		store: st,
+		zkPath: func(etcdPath string) (zkTyp, string, error) {
+			if etcdPath != testEtcdPath {
+				return "", "", errors.Errorf("unknown etcd path %v", etcdPath)
+			}
+			return zkRoute, testZKPath, nil
+		},
+	}
+	return s, fz, fe, st
+}
+
+func Test_syncer_zkChanged(t *testing.T) {
+	tests := []struct {
+		name         string
+		etcd         string
+		base         *syncBase
+		wantEtcd     string
+		wantConflict bool
+	}{
+		{
+			name:     "etcd unchanged",
+			etcd:     "version=1",
+			base:     &syncBase{Content: "version=1", ZKVersion: 2},
+			wantEtcd: "version=2",
+		},
+		{
+			name:     "first sync",
+			etcd:     "version=1",
+			wantEtcd: "version=2",
+		},
+		{
+			name:         "both changed",
+			etcd:         "version=3",
+			base:         &syncBase{Content: "version=1", ZKVersion: 2},
+			wantEtcd:     "version=3",
+			wantConflict: true,
+		},
+		{
+			name:     "same change",
+			etcd:     "version=2",
+			base:     &syncBase{Content: "version=1", ZKVersion: 2},
+			wantEtcd: "version=2",
+		},
+		{
+			name:     "only etcd changed",
+			etcd:     "version=3",
+			base:     &syncBase{Content: "version=2", ZKVersion: 3},
+			wantEtcd: "version=3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, fe, st := newTestSyncer("version=2", tt.etcd, tt.base)
+			if err := s.zkChanged(zkRoute, testZKPath, testEtcdPath, "version=2", 3); err != nil {
+				t.Fatalf("zkChanged() error = %v", err)
+			}
+			if fe[testEtcdPath] != tt.wantEtcd {
+				t.Errorf("zkChanged() etcd = %q, want %q", fe[testEtcdPath], tt.wantEtcd)
+			}
+			if c := st.conflicts[testZKPath]; (c != nil) != tt.wantConflict {
+				t.Errorf("zkChanged() conflict = %v, want %v", c, tt.wantConflict)
+			}
+			if !tt.wantConflict && st.bases[testZKPath].Content != "version=2" {
+				t.Errorf("zkChanged() base = %v", st.bases[testZKPath])
+			}
+		})
+	}
+}
+
+func Test_syncer_zkChanged_etcdOnly(t *testing.T) {
+	s, fz, _, st := newTestSyncer("version=2", "version=3", &syncBase{Content: "version=2", ZKVersion: 3})
+
+	// zk reloaded before the etcd change is pushed
+	if err := s.zkChanged(zkRoute, testZKPath, testEtcdPath, "version=2", 3); err != nil {
+		t.Fatalf("zkChanged() error = %v", err)
+	}
+	if err := s.etcdChanged(testEtcdPath, "version=3"); err != nil {
+		t.Fatalf("etcdChanged() error = %v", err)
+	}
+	if got := fz[testZKPath].content; got != "version=3" {
+		t.Errorf("zk = %q, want %q", got, "version=3")
+	}
+	if c := st.conflicts[testZKPath]; c != nil {
+		t.Errorf("conflict = %v, want none", c)
+	}
+}
+
+func Test_syncer_etcdChanged(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     *syncBase
+		check    error
+		wantZK   string
+		wantKind ConflictKind
+	}{
+		{
+			name:   "push",
+			base:   &syncBase{Content: "version=1", ZKVersion: 3},
+			wantZK: "version=2",
+		},
+		{
+			name:     "zk changed",
+			base:     &syncBase{Content: "version=0", ZKVersion: 2},
+			wantZK:   "version=1",
+			wantKind: ConflictDiverged,
+		},
+		{
+			name:     "unknown base",
+			wantZK:   "version=1",
+			wantKind: ConflictDiverged,
+		},
+		{
+			name:     "rejected",
+			base:     &syncBase{Content: "version=1", ZKVersion: 3},
+			check:    errors.New("lint failed"),
+			wantZK:   "version=1",
+			wantKind: ConflictRejected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fz, _, st := newTestSyncer("version=1", "version=2", tt.base)
+			s.check = func(typ zkTyp, zkPath string, content string) error { return tt.check }
+
+			if err := s.etcdChanged(testEtcdPath, "version=2"); err != nil {
+				t.Fatalf("etcdChanged() error = %v", err)
+			}
+			if got := fz[testZKPath].content; got != tt.wantZK {
+				t.Errorf("etcdChanged() zk = %q, want %q", got, tt.wantZK)
+			}
+
+			c := st.conflicts[testZKPath]
+			if tt.wantKind == "" {
+				if c != nil {
+					t.Errorf("etcdChanged() unexpected conflict %v", c)
+				}
+				if b := st.bases[testZKPath]; b.Content != "version=2" || b.ZKVersion != 4 {
+					t.Errorf("etcdChanged() base = %v", b)
+				}
+				return
+			}
+			if c == nil || c.Kind != tt.wantKind {
+				t.Errorf("etcdChanged() conflict = %v, want %v", c, tt.wantKind)
+			}
+		})
+	}
+}
+
+func Test_syncer_resyncEtcd(t *testing.T) {
+	s, fz, _, st := newTestSyncer("version=1", "version=2", &syncBase{Content: "version=1", ZKVersion: 3})
+
+	for i := 0; i < 2; i++ {
+		if err := s.resyncEtcd("/dolphin/uat/java/zk/route"); err != nil {
+			t.Fatalf("resyncEtcd() error = %v", err)
+		}
+		if n := fz[testZKPath]; n.content != "version=2" || n.version != 4 {
+			t.Errorf("resyncEtcd() %d zk = %q version %d, want version=2 version 4", i, n.content, n.version)
+		}
+	}
+	if len(st.conflicts) != 0 {
+		t.Errorf("resyncEtcd() unexpected conflicts %v", st.conflicts)
+	}
+}
+
+func Test_syncer_echo(t *testing.T) {
+	s, fz, fe, st := newTestSyncer("version=1", "version=1", nil)
+
+	// zk change is mirrored to etcd, and its echo from etcd is ignored
+	fz[testZKPath] = &fakeZKNode{content: "version=2", version: 4}
+	if err := s.zkChanged(zkRoute, testZKPath, testEtcdPath, "version=2", 4); err != nil {
+		t.Fatalf("zkChanged() error = %v", err)
+	}
+	if err := s.etcdChanged(testEtcdPath, fe[testEtcdPath]); err != nil {
+		t.Fatalf("etcdChanged() error = %v", err)
+	}
+	if fz[testZKPath].version != 4 || len(st.conflicts) != 0 {
+		t.Errorf("echo changed zk: %v, conflicts: %v", fz[testZKPath], st.conflicts)
+	}
+}
+
+func Test_syncer_resolve(t *testing.T) {
+	base := &syncBase{Content: "version=0", ZKVersion: 2}
+
+	s, fz, fe, st := newTestSyncer("version=1", "version=2", base)
+	if err := s.etcdChanged(testEtcdPath, "version=2"); err != nil {
+		t.Fatalf("etcdChanged() error = %v", err)
+	}
+	if err := s.resolve(testZKPath, SideEtcd); err != nil {
+		t.Fatalf("resolve(etcd) error = %v", err)
+	}
+	if fz[testZKPath].content != "version=2" || len(st.conflicts) != 0 {
+		t.Errorf("resolve(etcd) zk = %v, conflicts: %v", fz[testZKPath], st.conflicts)
+	}
+
+	s, fz, fe, st = newTestSyncer("version=1", "version=2", base)
+	if err := s.etcdChanged(testEtcdPath, "version=2"); err != nil {
+		t.Fatalf("etcdChanged() error = %v", err)
+	}
+	if err := s.resolve(testZKPath, SideZK); err != nil {
+		t.Fatalf("resolve(zk) error = %v", err)
+	}
+	if fe[testEtcdPath] != "version=1" || len(st.conflicts) != 0 {
+		t.Errorf("resolve(zk) etcd = %v, conflicts: %v", fe[testEtcdPath], st.conflicts)
+	}
+
+	if err := s.resolve(testZKPath, SideZK); errors.Cause(err) != ErrNoConflict {
+		t.Errorf("resolve() without conflict error = %v, want %v", err, ErrNoConflict)
+	}
+}
+
+func Test_syncer_report(t *testing.T) {
+	s, _, _, _ := newTestSyncer("version=1", "version=2", nil)
+	r, err := s.report("")
+	if err != nil {
+		t.Fatalf("report() error = %v", err)
+	}
+	if r.Total != 1 || r.InSync != 0 || len(r.Diverged) != 1 || r.Diverged[0] != testZKPath {
+		t.Errorf("report() = %+v", r)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -19,8 +20,10 @@ import (
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"github.com/samuel/go-zookeeper/zk"
+	"we.com/dolphin/controllers/java/discovery"
 	"we.com/dolphin/controllers/java/router"
 	zkcfg "we.com/dolphin/controllers/java/zk/types"
+	"we.com/dolphin/registry/etcdkey"
 	"we.com/dolphin/registry/generic"
 	"we.com/dolphin/types"
 )
@@ -34,6 +37,11 @@ type manager struct {
 	lock        sync.RWMutex
 	zkIns       map[types.DeployName][]router.ServiceNode
 	routeCfg    map[types.DeployName]*router.RouteCfg
+	sync        *syncer
+	// resync is requested when zk session is reestablished
+	resync chan struct{}
+	// serializes full syncs
+	reload sync.Mutex
 }
 
 func (m *manager) Destory() error {
@@ -62,6 +70,7 @@ func NewManager(cfg *zkcfg.EnvConfig, pi PathInfor) (Manager, error) {
 		zkIns:       map[types.DeployName][]router.ServiceNode{},
 		routeCfg:    map[types.DeployName]*router.RouteCfg{},
 		zkPathInfor: pi,
+		resync:      make(chan struct{}, 1),
 	}
 
 	cli, err := NewClient(cfg.ZKServers)
@@ -70,6 +79,16 @@ func NewManager(cfg *zkcfg.EnvConfig, pi PathInfor) (Manager, error) {
 	}
 
 	m.zkClient = cli
+	m.sync = &syncer{
+		stage: m.stage,
+		zk:    cli,
+		etcd:  etcdMirror{},
+		store: etcdSyncStore{stage: m.stage},
+		zkPath: func(etcdPath string) (zkTyp, string, error) {
+			return pi.GetZKPath(m.stage, etcdPath)
+		},
+		check: m.checkPush,
+	}
 
 	if err := m.start(); err != nil {
 		m.Destory()
@@ -88,21 +107,27 @@ func (m *manager) start() error {
 		return errors.Errorf("config is nil")
 	}
 
+	ctx, cf := context.WithCancel(context.Background())
+	m.cf = cf
+
+	go m.watchSession(ctx, m.zkClient.events)
+
 	go func() {
 		timer := time.NewTicker(4 * time.Hour)
 		defer timer.Stop()
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case <-timer.C:
-				if err := m.ReloadData(); err != nil {
-					glog.Errorf("zk: sync data from zk to etcd: %v", err)
-				}
+				m.resyncData()
 			}
 		}
 	}()
 
-	ctx, cf := context.WithCancel(context.Background())
-	m.cf = cf
+	if err := m.watchEtcd(ctx); err != nil {
+		return errors.Wrap(err, "zk: watch etcd for changes")
+	}
 
 	go func() {
 		// if more than 5 times err happend with 5 mins
@@ -110,25 +135,39 @@ func (m *manager) start() error {
 		startTime := time.Now()
 		errCount := 0
 		for {
+			// watches are set before data is reloaded, so no change in between is missed
+			wctx, wcf := context.WithCancel(ctx)
+			errc := make(chan error, 1)
+			go func() {
+				errc <- m.Watch(wctx)
+			}()
+			m.resyncData()
+
+			var err error
 			select {
 			case <-ctx.Done():
+				wcf()
 				return
-			default:
-				if err := m.Watch(ctx); err != nil {
-					glog.Error(err)
-					errCount++
-					now := time.Now()
-					if startTime.Add(5 * time.Minute).After(now) {
-						if errCount > 5 {
-							glog.Fatalf("zk: %v sync failed %v times within %v seconds", m.stage.String(), errCount, (now.Unix() - startTime.Unix()))
-						}
-					} else {
-						startTime = now
-						errCount = 1
+			case err = <-errc:
+			case <-m.resync:
+				glog.Infof("zk: %v session reestablished, rewatch and resync", m.stage)
+				wcf()
+				err = <-errc
+			}
+			wcf()
+
+			if err != nil {
+				glog.Error(err)
+				errCount++
+				now := time.Now()
+				if startTime.Add(5 * time.Minute).After(now) {
+					if errCount > 5 {
+						glog.Fatalf("zk: %v sync failed %v times within %v seconds", m.stage.String(), errCount, (now.Unix() - startTime.Unix()))
 					}
+				} else {
+					startTime = now
+					errCount = 1
 				}
-
-				m.Destory()
 			}
 		}
 	}()
@@ -136,15 +175,30 @@ func (m *manager) start() error {
 	return nil
 }
 
+// resyncData  full sync from zk to etcd
+func (m *manager) resyncData() {
+	stage := m.stage.String()
+	if err := m.ReloadData(); err != nil {
+		glog.Errorf("zk: sync data from zk to etcd: %v", err)
+		resyncTotal.WithLabelValues(stage, "error").Inc()
+		return
+	}
+	resyncTotal.WithLabelValues(stage, "success").Inc()
+	lastSync.WithLabelValues(stage).Set(float64(time.Now().Unix()))
+}
+
+// ReloadData  load all configed zk nodes, mirror them to etcd,
+// and remove etcd mirrors of zk nodes which no longer exist
 func (m *manager) ReloadData() error {
+	m.reload.Lock()
+	defer m.reload.Unlock()
+
 	env := m.stage
 	cfg := m.config
-	store, err := generic.GetStoreInstance("", false)
-	if err != nil {
-		return err
-	}
 
-	var merr *multierror.Error
+	zkIns := map[types.DeployName][]router.ServiceNode{}
+	routeCfg := map[types.DeployName]*router.RouteCfg{}
+	seen := map[string]struct{}{}
 	for _, v := range cfg.ZKPaths {
 		dat, err := m.zkClient.GetValues([]string{v.Base}, v.Regexp, false)
 		if err != nil {
@@ -157,19 +211,120 @@ func (m *manager) ReloadData() error {
 				glog.Warningf("zk: %v zkpath %v ingored for %v", env, k, err)
 				continue
 			}
+			seen[etcdPath] = struct{}{}
+
+			if typ == zkInstance || typ == zkRoute {
+				name, s, rc, err := m.decodeZKData(typ, k, d)
+				if err != nil {
+					glog.Warningf("zk: %v parse %v: %v", env, k, err)
+				} else if typ == zkInstance {
+					zkIns[name] = append(zkIns[name], *s)
+				} else {
+					routeCfg[name] = rc
+				}
+			}
+
+			if err := m.mirror(typ, k, etcdPath, d); err != nil {
+				glog.Errorf("zk: sync data from zk to etcd: %v", err)
+			}
+		}
+	}
 
-			if err := m.parseZKData(typ, k, d); err != nil {
+	m.lock.Lock()
+	m.zkIns = zkIns
+	m.routeCfg = routeCfg
+	m.lock.Unlock()
+
+	return m.removeTombstones(seen)
+}
+
+// removeTombstones  delete etcd mirrors not in seen, whose zk node is gone,
+// route and config nodes with an unresolved conflict are kept,
+// instance paths cannot be mapped back to zk, their mirrors are removed once not seen
+func (m *manager) removeTombstones(seen map[string]struct{}) error {
+	env := m.stage
+	dir := etcdkey.JavaZKDir(env)
+	insDir := etcdkey.JavaZKInstanceDir(env)
+	store, err := generic.GetStoreInstance(dir, false)
+	if err != nil {
+		return err
+	}
+
+	dat := map[string][]byte{}
+	if err := store.List(context.Background(), "", generic.Everything, dat); err != nil {
+		return err
+	}
+
+	var merr *multierror.Error
+	for k := range dat {
+		etcdPath := path.Join(dir, k)
+		if _, ok := seen[etcdPath]; ok {
+			continue
+		}
+
+		if strings.HasPrefix(etcdPath, insDir) {
+			glog.Infof("zk: %v remove tombstone %v", env, etcdPath)
+			if err := store.Delete(context.Background(), k, nil); err != nil && !generic.IsNotFound(err) {
 				merr = multierror.Append(merr, err)
 			}
+			continue
+		}
+
+		typ, zkPath, err := m.zkPathInfor.GetZKPath(env, etcdPath)
+		if err != nil {
+			glog.Warningf("zk: %v etcdpath %v ignored for %v", env, etcdPath, err)
+			continue
+		}
+
+		exists, _, err := m.zkClient.client.Exists(zkPath)
+		if err != nil {
+			merr = multierror.Append(merr, errors.Wrapf(err, "zk: check %v", zkPath))
+			continue
+		}
+		if exists {
+			continue
+		}
 
-			err = store.Update(context.Background(), etcdPath, d, nil, 0)
+		if typ == zkRoute || typ == zkConfig {
+			c, err := m.sync.store.getConflict(zkPath)
 			if err != nil {
-				glog.Errorf("zk: sync data from zk to etcd: %v", err)
+				merr = multierror.Append(merr, err)
+				continue
+			}
+			if c != nil {
+				continue
+			}
+			if err := m.sync.zkDeleted(zkPath); err != nil {
+				merr = multierror.Append(merr, err)
+				continue
 			}
 		}
+
+		glog.Infof("zk: %v remove tombstone %v of %v", env, etcdPath, zkPath)
+		if err := store.Delete(context.Background(), k, nil); err != nil && !generic.IsNotFound(err) {
+			merr = multierror.Append(merr, err)
+		}
 	}
 
-	return nil
+	return merr.ErrorOrNil()
+}
+
+// mirror  write zk data to etcd, route and config nodes are synced with conflict check
+func (m *manager) mirror(typ zkTyp, zkPath, etcdPath string, data []byte) error {
+	if typ != zkRoute && typ != zkConfig {
+		store, err := generic.GetStoreInstance("", false)
+		if err != nil {
+			return err
+		}
+		return store.Update(context.Background(), etcdPath, data, nil, 0)
+	}
+
+	// version of data is needed to push etcd changes back
+	content, ver, err := m.zkClient.GetNode(zkPath)
+	if err != nil {
+		return err
+	}
+	return m.sync.zkChanged(typ, zkPath, etcdPath, content, ver)
 }
 
 func (m *manager) GetRouteConfig(name types.DeployName) (*router.RouteCfg, error) {
@@ -181,16 +336,16 @@ func (m *manager) GetRouteConfig(name types.DeployName) (*router.RouteCfg, error
 }
 
 func (m *manager) SetRouteConfig(name types.DeployName, cfg *router.RouteCfg) error {
-	path, err := m.zkPathInfor.GetRoutePath(name)
+	path, err := m.routePath(name)
 	if err != nil {
 		return err
 	}
-	if path == "" {
-		return errors.Errorf("dont known zk path form %v", name)
-	}
 
 	var val string
 	if cfg != nil {
+		if _, err := discovery.CheckWrite(m, name, cfg); err != nil {
+			return err
+		}
 		val = cfg.String()
 	}
 
@@ -214,7 +369,8 @@ func (m *manager) GetInstanceList(name types.DeployName) ([]*router.ServiceNode,
 	if !ok {
 		return nil, nil
 	}
-	for _, v := range ins {
+	for i := range ins {
+		v := ins[i]
 		ret = append(ret, &v)
 	}
 
@@ -226,12 +382,8 @@ func (m *manager) GetInstanceList(name types.DeployName) ([]*router.ServiceNode,
 func (m *manager) Watch(ctx context.Context) error {
 	handler := m.handlerFunc(ctx, m.stage)
 
+	// not closed: watchers stop sending once ctx is done
 	ech := make(chan zk.Event, 10)
-	defer func() {
-		close(ech)
-		for range ech {
-		}
-	}()
 
 	if err := m.watch(ctx, ech); err != nil {
 		return err
@@ -263,12 +415,32 @@ func (m *manager) watch(ctx context.Context, ech chan<- zk.Event) error {
 }
 
 func (m *manager) parseZKData(typ zkTyp, path string, dat []byte) error {
-	name, err := m.zkPathInfor.GetDeployName(path)
+	name, s, rc, err := m.decodeZKData(typ, path, dat)
 	if err != nil {
 		return err
 	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if typ == zkInstance {
+		ss := m.zkIns[name]
+		ss = append(ss, *s)
+		m.zkIns[name] = ss
+	} else if typ == zkRoute {
+		m.routeCfg[name] = rc
+	}
+
+	return nil
+}
+
+// decodeZKData  decode instance or route node at path
+func (m *manager) decodeZKData(typ zkTyp, path string, dat []byte) (types.DeployName, *router.ServiceNode, *router.RouteCfg, error) {
+	name, err := m.zkPathInfor.GetDeployName(path)
+	if err != nil {
+		return "", nil, nil, err
+	}
 	if name == "" {
-		return errors.Errorf("zk sync: cannot recognize zk path: %v", path)
+		return "", nil, nil, errors.Errorf("zk sync: cannot recognize zk path: %v", path)
 	}
 
 	nodeName := filepath.Base(path)
@@ -277,7 +449,7 @@ func (m *manager) parseZKData(typ zkTyp, path string, dat []byte) error {
 	var rc *router.RouteCfg
 	if typ == zkInstance {
 		if err := json.Unmarshal(dat, &s); err != nil {
-			return err
+			return "", nil, nil, err
 		}
 		s.NodeName = nodeName
 		if strings.HasPrefix(path, "/biz/") {
@@ -291,21 +463,11 @@ func (m *manager) parseZKData(typ zkTyp, path string, dat []byte) error {
 		rc, err = router.Parse(string(dat), ver)
 		if err != nil {
 			glog.Errorf("zk: parse %v router config, err: %v", name, err)
-			return err
+			return "", nil, nil, err
 		}
 	}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	if typ == zkInstance {
-		ss := m.zkIns[name]
-		ss = append(ss, s)
-		m.zkIns[name] = ss
-	} else if typ == zkRoute {
-		m.routeCfg[name] = rc
-	}
-
-	return nil
+	return name, &s, rc, nil
 }
 
 func (m *manager) handlerFunc(ctx context.Context, env types.Stage) func(zk.Event) error {
@@ -378,7 +540,7 @@ func (m *manager) handlerFunc(ctx context.Context, env types.Stage) func(zk.Even
 
 				if _, ok := etcdKeysMap[k]; !ok {
 					s := path + "/" + k
-					data, _, err := cli.client.Get(s)
+					data, stat, err := cli.client.Get(s)
 					if err != nil {
 						glog.Errorf("zk: %v get zk data of %v: %v", env, s, err)
 						continue
@@ -395,12 +557,14 @@ func (m *manager) handlerFunc(ctx context.Context, env types.Stage) func(zk.Even
 					}
 					if err = store.Update(context.Background(), etcdPath, data, nil, 0); err != nil {
 						glog.Errorf("zk: %v store data to etcd %v: %v", env, etcdPath, err)
+						continue
 					}
+					observeLag(env, stat.Mtime)
 				}
 			}
 
 		case zk.EventNodeDataChanged:
-			data, _, err := cli.client.Get(path)
+			data, stat, err := cli.client.Get(path)
 			if err != nil {
 				glog.Errorf("zk: %v get zk data of %v: %v", env, path, err)
 				break
@@ -416,14 +580,11 @@ func (m *manager) handlerFunc(ctx context.Context, env types.Stage) func(zk.Even
 				m.parseZKData(typ, path, data)
 			}
 
-			store, err := generic.GetStoreInstance("", false)
-			if err != nil {
-				return err
-			}
-
-			if err = store.Update(context.Background(), etcdPath, data, nil, 0); err != nil {
+			if err = m.mirror(typ, path, etcdPath, data); err != nil {
 				glog.Errorf("zk: %v store data to etcd %v: %v", env, etcdPath, err)
+				break
 			}
+			observeLag(env, stat.Mtime)
 		case zk.EventNodeDeleted:
 			typ, etcdPath, err := m.zkPathInfor.GetEtcdPath(env, path)
 			if err != nil {
@@ -452,6 +613,12 @@ func (m *manager) handlerFunc(ctx context.Context, env types.Stage) func(zk.Even
 			}
 			m.lock.Unlock()
 
+			if typ == zkRoute || typ == zkConfig {
+				if err = m.sync.zkDeleted(path); err != nil {
+					glog.Errorf("zk: %v delete sync base of %v: %v", env, path, err)
+				}
+			}
+
 			store, err := generic.GetStoreInstance("", false)
 			if err != nil {
 				return err
@@ -480,11 +647,13 @@ func (m *manager) ListDeployment() []types.DeployName {
 	return ret
 }
 
-// Manager get or set java instance infos
+// Manager  zookeeper discovery backend, route and config nodes are synced with etcd
 type Manager interface {
-	ListDeployment() []types.DeployName
-	GetRouteConfig(name types.DeployName) (*router.RouteCfg, error)
-	SetRouteConfig(name types.DeployName, rc *router.RouteCfg) error
-	GetInstanceList(name types.DeployName) ([]*router.ServiceNode, error)
-	Destory() error
+	discovery.Backend
+	// Conflicts list zk nodes which cannot be synced with etcd
+	Conflicts() ([]*Conflict, error)
+	// ResolveConflict resolve conflict of zk path by copying content of side use to the other side
+	ResolveConflict(zkPath string, use SyncSide) error
+	// SyncReport compare route and config nodes mirrored in etcd with zk
+	SyncReport() (*SyncReport, error)
 }
@@ -23,12 +23,49 @@ type PathConfig struct {
 	Regexp    *regexp.Regexp `json:"-"`
 }
 
+// service discovery backends
+const (
+	BackendZK   = "zk"
+	BackendEtcd = "etcd"
+)
+
 // EnvConfig  zk config of an env
 type EnvConfig struct {
 	ENV         types.Stage     `json:"env,omitempty"`
+	Backend     string          `json:"backend,omitempty"`
 	ZKServers   []string        `json:"zkServers,omitempty"`
 	DialTimeout mytime.Duration `json:"dialTimeout,omitempty"`
 	ZKPaths     []PathConfig    `json:"zkPaths,omitempty"`
+	// ESBs  esb addresses, host:port, by api version, java deployments are probed through them
+	ESBs map[string][]string `json:"esbs,omitempty"`
+	// GCInterval  how often stale instance registrations are collected, DefaultGCInterval if 0,
+	// only deployments whose gc policy allows are deleted
+	GCInterval mytime.Duration `json:"gcInterval,omitempty"`
+	// SLOInterval  how often SLOs of java deployments are checked, DefaultSLOInterval if 0
+	SLOInterval mytime.Duration `json:"sloInterval,omitempty"`
+}
+
+const (
+	// DefaultGCInterval  default interval of collecting stale instances
+	DefaultGCInterval = 10 * time.Minute
+	// DefaultSLOInterval  default interval of checking SLOs
+	DefaultSLOInterval = time.Minute
+)
+
+// GetGCInterval  GCInterval, or DefaultGCInterval if it is not set
+func (ec *EnvConfig) GetGCInterval() time.Duration {
+	if ec.GCInterval <= 0 {
+		return DefaultGCInterval
+	}
+	return time.Duration(ec.GCInterval)
+}
+
+// GetSLOInterval  SLOInterval, or DefaultSLOInterval if it is not set
+func (ec *EnvConfig) GetSLOInterval() time.Duration {
+	if ec.SLOInterval <= 0 {
+		return DefaultSLOInterval
+	}
+	return time.Duration(ec.SLOInterval)
 }
 
 // Config  zk config
@@ -45,8 +82,22 @@ func (ec *EnvConfig) Validate() error {
 
 	e := ec.ENV
 	var merr *multierror.Error
-	if len(ec.ZKServers) == 0 {
-		merr = multierror.Append(merr, errors.Errorf("at least one zk server should config for %v", e))
+	if ec.GCInterval < 0 {
+		merr = multierror.Append(merr, errors.Errorf("%v: gcInterval less than 0", e))
+	}
+	if ec.SLOInterval < 0 {
+		merr = multierror.Append(merr, errors.Errorf("%v: sloInterval less than 0", e))
+	}
+
+	switch ec.Backend {
+	case "", BackendZK:
+		if len(ec.ZKServers) == 0 {
+			merr = multierror.Append(merr, errors.Errorf("at least one zk server should config for %v", e))
+		}
+	case BackendEtcd:
+		return merr.ErrorOrNil()
+	default:
+		merr = multierror.Append(merr, errors.Errorf("%v: unknown discovery backend %v", e, ec.Backend))
 	}
 
 	to := time.Duration(ec.DialTimeout)
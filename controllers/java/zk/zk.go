@@ -18,6 +18,8 @@ import (
 // Client provides a wrapper around the zookeeper client
 type Client struct {
 	client *zk.Conn
+	// session events of client
+	events <-chan zk.Event
 }
 
 func (c *Client) Close() {
@@ -27,16 +29,11 @@ func (c *Client) Close() {
 }
 
 func NewClient(machines []string) (*Client, error) {
-	c, _, err := zk.Connect(machines, time.Second) //*10)
+	c, events, err := zk.Connect(machines, time.Second) //*10)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	b, s, err := c.Get("/service/com.dc-item/1_5")
-	if err != nil {
-		panic(err)
-	}
-	glog.Infof("%v: %v", b, s)
-	return &Client{c}, nil
+	return &Client{client: c, events: events}, nil
 }
 
 func nodeWalk(prefix string, c *Client, pathMatcher *regexp.Regexp,
@@ -79,7 +76,10 @@ func nodeWalk(prefix string, c *Client, pathMatcher *regexp.Regexp,
 				s = prefix + "/" + key
 			}
 
-			nodeWalk(s, c, pathMatcher, keysOnly, vars)
+			// node may be deleted during the walk
+			if err := nodeWalk(s, c, pathMatcher, keysOnly, vars); err != nil && err != zk.ErrNoNode {
+				return err
+			}
 		}
 	}
 	return nil
@@ -131,6 +131,36 @@ func (c *Client) GetNodeValue(key string) (string, error) {
 	return string(b), nil
 }
 
+// GetNode get node value and its version
+func (c *Client) GetNode(key string) (string, int32, error) {
+	b, stat, err := c.client.Get(key)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(b), stat.Version, nil
+}
+
+// SetNodeValueVersion set node value only if node version is still version,
+// returns zk.ErrBadVersion if node has been changed by others, and the new version on success
+func (c *Client) SetNodeValueVersion(key string, value string, version int32) (int32, error) {
+	stat, err := c.client.Set(key, ([]byte)(value), version)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Version, nil
+}
+
+// CreateNode create node with value, returns zk.ErrNodeExists if it exists
+func (c *Client) CreateNode(key string, value string) error {
+	_, err := c.client.Create(key, ([]byte)(value), 0, zk.WorldACL(zk.PermAll))
+	return err
+}
+
+// DeleteNode delete node only if its version is still version
+func (c *Client) DeleteNode(key string, version int32) error {
+	return c.client.Delete(key, version)
+}
+
 // GetNodesValues get nodes values
 func (c *Client) GetNodesValues(keys []string) (map[string]string, error) {
 	vars := make(map[string]string)
@@ -179,9 +209,9 @@ func (c *Client) nodeWalkW(ctx context.Context, prefix string, pathMatcher *rege
 	var err error
 	defer func() {
 		if err != nil {
-			ech <- zk.Event{
-				Path: prefix,
-				Err:  err,
+			select {
+			case ech <- zk.Event{Path: prefix, Err: err}:
+			case <-ctx.Done():
 			}
 		}
 	}()
@@ -222,7 +252,9 @@ func (c *Client) nodeWalkW(ctx context.Context, prefix string, pathMatcher *rege
 			case <-ctx.Done():
 				return
 			case event := <-dataC:
-				ech <- event
+				if !send(ctx, ech, event) {
+					return
+				}
 
 				if event.Type == zk.EventNodeDeleted {
 					return
@@ -233,7 +265,9 @@ func (c *Client) nodeWalkW(ctx context.Context, prefix string, pathMatcher *rege
 				}
 
 			case event := <-childech:
-				ech <- event
+				if !send(ctx, ech, event) {
+					return
+				}
 				if event.Type == zk.EventNodeDeleted {
 					return
 				}
@@ -250,3 +284,13 @@ func (c *Client) nodeWalkW(ctx context.Context, prefix string, pathMatcher *rege
 		}
 	}()
 }
+
+// send  event to ech, unless watch is canceled
+func send(ctx context.Context, ech chan<- zk.Event, event zk.Event) bool {
+	select {
+	case ech <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
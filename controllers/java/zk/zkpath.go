@@ -174,6 +174,32 @@ func (sp *simplePathInfo) GetEtcdPath(env types.Stage, zkPath string) (zkTyp, st
 	return typ, path.Join(etcdkey.StageBaseDir(env), p), nil
 }
 
+// GetZKPath etcdPath 格式为 {javaZKDir}/{typ}/{version}/{cluster}/...,
+// api2.0的cluster为项目名， 需要转换为服务名
+func (sp *simplePathInfo) GetZKPath(env types.Stage, etcdPath string) (zkTyp, string, error) {
+	prefix := etcdkey.JavaZKDir(env)
+	if !strings.HasPrefix(etcdPath, prefix) {
+		return "", "", errors.Errorf("zk: %v is not a mirrored zk path of %v", etcdPath, env)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(etcdPath, prefix), "/")
+	if len(parts) >= 3 && parts[1] == "2" {
+		sp.lock.RLock()
+		s := sp.projectMap[parts[2]]
+		sp.lock.RUnlock()
+		if s == "" {
+			return "", "", errors.Errorf("zk: unknown api2.0 project %v", parts[2])
+		}
+		parts[2] = s
+	}
+
+	p, err := getZKPath0(strings.Join(parts, "/"))
+	if err != nil {
+		return "", "", err
+	}
+	return zkTyp(parts[0]), p, nil
+}
+
 func parseZKPathv4(zkPath string) (zkTyp, string, error) {
 	var typ zkTyp
 	if !strings.HasPrefix(zkPath, "/biz/") {
@@ -369,4 +395,6 @@ type PathInfor interface {
 	GetDeployName(path string) (types.DeployName, error)
 	GetAPIVersion(name types.DeployName) string
 	GetEtcdPath(env types.Stage, zkPath string) (zkTyp, string, error)
+	// GetZKPath reverse of GetEtcdPath, only route and config paths can be mapped back
+	GetZKPath(env types.Stage, etcdPath string) (zkTyp, string, error)
 }
@@ -0,0 +1,185 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	ctypes "we.com/dolphin/controllers/types"
+	"we.com/dolphin/types"
+)
+
+const (
+	// canaries are aborted when  fail ratio exceeds this value
+	canaryMaxFailRatio  = 0.2
+	canaryCheckInterval = 10 * time.Second
+)
+
+// canaryUpdate  deploy canaries, bake them, then promote or abort
+// prev is the deploy config before this update, canaries are rollbacked to it on abort
+func (c *replicaCtrl) canaryUpdate(ctx context.Context, prev types.DeployConfig) error {
+	upo := c.dc.UpdatePolicy
+	hc := c.hcManager.ListHostConfigs(c.key)
+	numExp, _ := c.hcStat(hc)
+
+	numCanary := int(math.Ceil(float64(c.dc.NumOfInstance)*upo.NewPercent)) - numExp
+	since := time.Now()
+	if numCanary > 0 {
+		if err := c.addInstances(ctx, numCanary); err != nil {
+			return c.abortNewVersion(prev, err)
+		}
+	}
+
+	glog.Infof("sched: %v canary of version %v started, bake %v", c.key, c.expectVersion, upo.Bake)
+	if err := c.bakeCanaries(ctx, since, upo.Bake); err != nil {
+		return c.abortNewVersion(prev, err)
+	}
+
+	glog.Infof("sched: %v canary of version %v passed, promote", c.key, c.expectVersion)
+	return c.rollingUpdate(ctx)
+}
+
+func (c *replicaCtrl) bakeCanaries(ctx context.Context, since time.Time, bake time.Duration) error {
+	if c.opt.dryMode {
+		return nil
+	}
+
+	deadline := time.NewTimer(bake)
+	defer deadline.Stop()
+	ticker := time.NewTicker(canaryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := c.checkCanaries(since); err != nil {
+				return err
+			}
+		case <-deadline.C:
+			n, err := c.checkCanaries(since)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.Wrap(ErrCanaryAborted, "no canary instance is running")
+			}
+			return nil
+		}
+	}
+}
+
+// checkCanaries  check canaries started after since, returns num of  running canaries
+func (c *replicaCtrl) checkCanaries(since time.Time) (int, error) {
+	ver := string(c.expectVersion)
+
+	for _, ins := range c.info.NewStoppedInstance(c.key, time.Since(since)) {
+		if ins.Version == ver {
+			return 0, errors.Wrapf(ErrCanaryAborted, "instance %v on %v stopped", ins.ID, ins.Host)
+		}
+	}
+
+	num := 0
+	for _, ins := range c.info.RunningInstance(c.key) {
+		if ins.Version != ver {
+			continue
+		}
+		num++
+
+		if ins.Status == types.InstanceError {
+			return num, errors.Wrapf(ErrCanaryAborted, "instance %v on %v status %v", ins.ID, ins.Host, ins.Status)
+		}
+
+		for _, cd := range ins.Conditions {
+			if cd == nil {
+				continue
+			}
+			switch cd.Type {
+			case types.ProbError, types.ProbeCondition, types.ProcessStopped:
+				return num, errors.Wrapf(ErrCanaryAborted, "instance %v on %v: %v %v", ins.ID, ins.Host, cd.Type, cd.Message)
+			}
+		}
+	}
+
+	if num > 0 {
+		if r, ok := c.canaryFailRatio(); ok && r > canaryMaxFailRatio {
+			return num, errors.Wrapf(ErrCanaryAborted, "probe fail ratio %.2f exceeds %.2f", r, canaryMaxFailRatio)
+		}
+	}
+
+	return num, nil
+}
+
+// canaryFailRatio  fail ratio of canaries, if health checker does not know it,
+// the fail ratio of the whole deployment is used
+func (c *replicaCtrl) canaryFailRatio() (float64, bool) {
+	if c.health == nil {
+		return 0, false
+	}
+	if vh, ok := c.health.(ctypes.VersionHealthChecker); ok {
+		if r, ok := vh.VersionFailRatio(c.key, c.expectVersion); ok {
+			return r, true
+		}
+	}
+	return c.health.FailRatio(c.key)
+}
+
+// abortNewVersion  remove all instances of new version, and restore to prev deploy config, which is saved too
+func (c *replicaCtrl) abortNewVersion(prev types.DeployConfig, cause error) error {
+	glog.Errorf("sched: %v abort version %v: %v", c.key, c.expectVersion, cause)
+
+	merr := multierror.Append(nil, cause)
+	canaryVer := c.expectVersion
+	prevVer := types.DeployVer(prev.ImageVersion())
+	if prevVer == canaryVer {
+		return merr.ErrorOrNil()
+	}
+
+	if err := c.removeVersionConfigs(canaryVer); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+
+	c.setConfig(prev)
+
+	// the deploy config is loaded on restart, it must not bring the aborted version back
+	if c.saveConfig != nil {
+		if err := c.saveConfig(&prev); err != nil {
+			merr = multierror.Append(merr, errors.Wrapf(err, "sched: save deploy config of %v reverted to %v", c.key, prevVer))
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// removeVersionConfigs  remove all instances of version ver from host configs
+func (c *replicaCtrl) removeVersionConfigs(ver types.DeployVer) error {
+	hc := c.hcManager.ListHostConfigs(c.key)
+
+	var merr *multierror.Error
+	for h, cfg := range hc {
+		if _, ok := cfg.Info[ver]; !ok {
+			continue
+		}
+		delete(cfg.Info, ver)
+
+		var err error
+		if len(cfg.Info) == 0 {
+			err = c.hcManager.DeleteHostConfigs(c.key, h)
+		} else {
+			err = c.hcManager.SetHostConfig(c.key, h, cfg)
+		}
+		if err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	return merr.ErrorOrNil()
+}
@@ -0,0 +1,153 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"we.com/dolphin/types"
+)
+
+// newCanaryCtrl  a controller updating from v1.0.0 to v2.0.0, one canary of 4 instances is running
+func newCanaryCtrl(t *testing.T) (*replicaCtrl, *fakeHCManager, types.DeployConfig) {
+	c, hc := newTestCtrl(t, true, map[types.HostID]types.DeploySpec{
+		"h1": {Info: map[types.DeployVer]int{"v1.0.0": 1, "v2.0.0": 1}},
+		"h2": {Info: map[types.DeployVer]int{"v1.0.0": 2}},
+	})
+
+	prev := c.dc
+	prev.Image = &types.Image{Version: types.MustParseVersion("v1.0.0")}
+
+	c.expectVersion = "v2.0.0"
+	c.dc.Image = &types.Image{Version: types.MustParseVersion("v2.0.0")}
+	c.dc.UpdatePolicy = &types.UpdateOption{
+		Policy:     types.CanaryUpdate,
+		NewPercent: 0.25,
+		Bake:       20 * time.Millisecond,
+		Step:       10 * time.Millisecond,
+		Timeout:    200 * time.Millisecond,
+	}
+	return c, hc, prev
+}
+
+func Test_replicaCtrl_checkCanaries(t *testing.T) {
+	canary := types.InstanceID("h1-v2.0.0-0")
+	tests := []struct {
+		name    string
+		health  *fakeHealth
+		stopped []*types.Instance
+		cond    *types.Condition
+		wantNum int
+		wantErr bool
+	}{
+		{name: "healthy", wantNum: 1},
+		{
+			name:    "canary stopped",
+			stopped: []*types.Instance{{ID: "h1-v2.0.0-1", Version: "v2.0.0"}},
+			wantErr: true,
+		},
+		{
+			name:    "legacy stopped",
+			stopped: []*types.Instance{{ID: "h2-v1.0.0-1", Version: "v1.0.0"}},
+			wantNum: 1,
+		},
+		{
+			name:    "probe condition",
+			cond:    &types.Condition{Type: types.ProbeCondition, Message: "probe fail ratio 0.80"},
+			wantNum: 1,
+			wantErr: true,
+		},
+		{
+			name:    "version fail ratio",
+			health:  &fakeHealth{ratio: 0.1, known: true, vers: map[types.DeployVer]float64{"v2.0.0": 0.5}},
+			wantNum: 1,
+			wantErr: true,
+		},
+		{
+			name:    "version healthy, legacy failing",
+			health:  &fakeHealth{ratio: 0.5, known: true, vers: map[types.DeployVer]float64{"v2.0.0": 0.1}},
+			wantNum: 1,
+		},
+		{
+			name:    "version unknown, deployment failing",
+			health:  &fakeHealth{ratio: 0.5, known: true},
+			wantNum: 1,
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			health:  &fakeHealth{},
+			wantNum: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, _ := newCanaryCtrl(t)
+			fi := c.info.(*fakeInfor)
+			fi.stopped = tt.stopped
+			if tt.cond != nil {
+				fi.SetCondition(testKey, canary, tt.cond)
+			}
+			if tt.health != nil {
+				c.health = *tt.health
+			}
+
+			n, err := c.checkCanaries(time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkCanaries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && errors.Cause(err) != ErrCanaryAborted {
+				t.Errorf("checkCanaries() error = %v, want %v", err, ErrCanaryAborted)
+			}
+			if err == nil && n != tt.wantNum {
+				t.Errorf("checkCanaries() = %v, want %v", n, tt.wantNum)
+			}
+		})
+	}
+}
+
+func Test_replicaCtrl_canaryUpdate(t *testing.T) {
+	t.Run("promote", func(t *testing.T) {
+		c, hc, prev := newCanaryCtrl(t)
+		c.health = fakeHealth{vers: map[types.DeployVer]float64{"v2.0.0": 0.1}}
+
+		if err := c.canaryUpdate(context.Background(), prev); err != nil {
+			t.Fatalf("canaryUpdate() error = %v", err)
+		}
+		numExp, numUnexp := c.hcStat(hc.ListHostConfigs(testKey))
+		if numExp != 4 || numUnexp != 0 {
+			t.Errorf("canaryUpdate() got %v expected, %v legacy, want 4, 0", numExp, numUnexp)
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		c, hc, prev := newCanaryCtrl(t)
+		c.health = fakeHealth{vers: map[types.DeployVer]float64{"v2.0.0": 0.9}}
+		var saved *types.DeployConfig
+		c.saveConfig = func(dc *types.DeployConfig) error {
+			saved = dc
+			return nil
+		}
+
+		err := c.canaryUpdate(context.Background(), prev)
+		if err == nil {
+			t.Fatalf("canaryUpdate() of failing canaries should fail")
+		}
+		if c.expectVersion != "v1.0.0" || c.dc.ImageVersion() != "v1.0.0" {
+			t.Errorf("canaryUpdate() expect version %v, config %v after abort, want v1.0.0", c.expectVersion, c.dc.ImageVersion())
+		}
+		if saved == nil || saved.ImageVersion() != "v1.0.0" {
+			t.Errorf("canaryUpdate() saved %v after abort, want config of v1.0.0", saved)
+		}
+		numExp, numUnexp := c.hcStat(hc.ListHostConfigs(testKey))
+		if numExp != 3 || numUnexp != 0 {
+			t.Errorf("canaryUpdate() got %v of v1.0.0, %v others after abort, want 3, 0", numExp, numUnexp)
+		}
+	})
+}
@@ -14,4 +14,6 @@ var (
 	ErrHostShortOfResource = errors.New("replica: host short of resource")
 	ErrCocurrencyFull      = errors.New("repllica: cocurrency full, please try again 2 mins later")
 	ErrUnknown             = errors.New("replica: unknown error")
+	ErrCanaryAborted       = errors.New("replica: canary aborted, new version instances are unhealthy")
+	ErrRolloutTimeout      = errors.New("replica: rollout timeout, paused")
 )
@@ -0,0 +1,315 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"github.com/golang/glog"
+	ctypes "we.com/dolphin/controllers/types"
+	"we.com/dolphin/types"
+)
+
+const (
+	// a host which load per cpu exceeds this value, should not schedual more instances
+	maxLoadPerCPU = 2.0
+)
+
+// hostState  info and status of a host, loaded once per evaluation round
+type hostState struct {
+	info   *types.HostInfo
+	status *types.HostStatus
+	err    error
+}
+
+// hostStateCache  cache host states, so every evaluator of a round sees the same data
+type hostStateCache struct {
+	stage  types.Stage
+	states map[types.HostID]*hostState
+}
+
+func newHostStateCache(stage types.Stage) *hostStateCache {
+	return &hostStateCache{
+		stage:  stage,
+		states: map[types.HostID]*hostState{},
+	}
+}
+
+func (c *hostStateCache) get(hid types.HostID) *hostState {
+	if st, ok := c.states[hid]; ok {
+		return st
+	}
+
+	st := &hostState{}
+	st.status, st.err = getHostStatus(c.stage, hid)
+	if st.err == nil {
+		st.info, st.err = getHostInfo(c.stage, hid)
+	}
+	c.states[hid] = st
+	return st
+}
+
+// freeResource  resource can be used by new instances
+func (st *hostState) freeResource() types.DeployResource {
+	free := st.info.GetResource()
+	free.Subtract(st.info.ResourceReserved)
+	if used := st.status.ResourceUsed(); used != nil {
+		free.Subtract(*used)
+	}
+	return free
+}
+
+// resourceEvaluator  prefer hosts with more free memory and cpu
+type resourceEvaluator struct {
+	cache   *hostStateCache
+	require types.DeployResource
+}
+
+func (e *resourceEvaluator) Evaluat(hid types.HostID) float64 {
+	st := e.cache.get(hid)
+	if st.err != nil {
+		glog.Warningf("scheduler: evaluate resource of %v: %v", hid, st.err)
+		return -1
+	}
+
+	free := st.freeResource()
+	if free.Devide(e.require) <= 0 {
+		return -1
+	}
+
+	total := st.info.GetResource()
+	return (ratio(free.Memory, total.Memory) + ratio(free.CPU, total.CPU)) / 2
+}
+
+// loadEvaluator prefer hosts with lower load
+type loadEvaluator struct {
+	cache *hostStateCache
+}
+
+func (e *loadEvaluator) Evaluat(hid types.HostID) float64 {
+	st := e.cache.get(hid)
+	if st.err != nil {
+		glog.Warningf("scheduler: evaluate load of %v: %v", hid, st.err)
+		return -1
+	}
+
+	cpus := st.info.NumOfCPUs
+	if cpus <= 0 {
+		cpus = 1
+	}
+
+	lpc := st.status.Load1 / float64(cpus)
+	if lpc >= maxLoadPerCPU {
+		return -1
+	}
+
+	return 1 - lpc/maxLoadPerCPU
+}
+
+// spreadEvaluator prefer hosts running less instances of the same deploy key
+type spreadEvaluator struct {
+	counts map[types.HostID]int
+}
+
+func (e *spreadEvaluator) Evaluat(hid types.HostID) float64 {
+	return 1 / float64(1+e.counts[hid])
+}
+
+// capEvaluator  exclude hosts which already run max instances per node,
+// max 0 means 1, an instance of a deploy key per host
+type capEvaluator struct {
+	max    int
+	counts map[types.HostID]int
+}
+
+func (e *capEvaluator) Evaluat(hid types.HostID) float64 {
+	max := e.max
+	if max <= 0 {
+		max = 1
+	}
+	if e.counts[hid] >= max {
+		return -1
+	}
+	return 1
+}
+
+// coLocateEvaluator  affinity and anti-affinity of deploy keys
+// hosts in notWith are excluded, if with is not nil, only hosts in with are allowed
+type coLocateEvaluator struct {
+	with    map[types.HostID]struct{}
+	notWith map[types.HostID]struct{}
+}
+
+func (e *coLocateEvaluator) Evaluat(hid types.HostID) float64 {
+	if _, ok := e.notWith[hid]; ok {
+		return -1
+	}
+
+	if e.with != nil {
+		if _, ok := e.with[hid]; !ok {
+			return -1
+		}
+	}
+	return 1
+}
+
+// labelSpreadEvaluator prefer hosts whose label value has less instances of the deploy key
+type labelSpreadEvaluator struct {
+	cache  *hostStateCache
+	labels []string
+	// label -> label value -> num of instances
+	counts map[string]map[string]int
+}
+
+func newLabelSpreadEvaluator(cache *hostStateCache, labels []string, counts map[types.HostID]int) *labelSpreadEvaluator {
+	e := &labelSpreadEvaluator{
+		cache:  cache,
+		labels: labels,
+		counts: make(map[string]map[string]int, len(labels)),
+	}
+
+	for _, l := range labels {
+		e.counts[l] = map[string]int{}
+	}
+
+	for hid, n := range counts {
+		if n <= 0 {
+			continue
+		}
+		hl := e.hostLabels(hid)
+		for _, l := range labels {
+			e.counts[l][hl[l]] += n
+		}
+	}
+
+	return e
+}
+
+func (e *labelSpreadEvaluator) hostLabels(hid types.HostID) map[string]string {
+	st := e.cache.get(hid)
+	if st.err != nil || st.info == nil {
+		return nil
+	}
+	return st.info.Labels
+}
+
+func (e *labelSpreadEvaluator) Evaluat(hid types.HostID) float64 {
+	if len(e.labels) == 0 {
+		return 1
+	}
+
+	hl := e.hostLabels(hid)
+	var sum float64
+	for _, l := range e.labels {
+		sum += 1 / float64(1+e.counts[l][hl[l]])
+	}
+
+	return sum / float64(len(e.labels))
+}
+
+type weightedEvaluator struct {
+	weight    float64
+	evaluator ctypes.HostEvaluator
+}
+
+// evaluatorChain  combine evaluators into one, the score is the weighted
+// average of all evaluators, if any evaluator gives a negative score, the host
+// is considered unschedualable
+type evaluatorChain []weightedEvaluator
+
+func (ec evaluatorChain) Evaluat(hid types.HostID) float64 {
+	var sum, weights float64
+	for _, v := range ec {
+		if v.weight <= 0 {
+			continue
+		}
+		sc := v.evaluator.Evaluat(hid)
+		if sc < 0 {
+			return -1
+		}
+		sum += v.weight * sc
+		weights += v.weight
+	}
+
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
+
+// evalRound  data shared by all evaluators of one scheduling round
+type evalRound struct {
+	cache   *hostStateCache
+	require types.DeployResource
+	// num of instances of the deploy key on each host
+	counts     map[types.HostID]int
+	maxPerNode int
+	spreadBy   []string
+	// hosts running instances of affinity keys, nil means no affinity rule
+	with    map[types.HostID]struct{}
+	notWith map[types.HostID]struct{}
+}
+
+// evaluatorFactory create a evaluator for a scheduling round
+type evaluatorFactory struct {
+	weight float64
+	create func(r *evalRound) ctypes.HostEvaluator
+}
+
+var defaultEvaluators = []evaluatorFactory{
+	{
+		weight: 2,
+		create: func(r *evalRound) ctypes.HostEvaluator {
+			return &resourceEvaluator{cache: r.cache, require: r.require}
+		},
+	},
+	{
+		weight: 1,
+		create: func(r *evalRound) ctypes.HostEvaluator {
+			return &loadEvaluator{cache: r.cache}
+		},
+	},
+	{
+		weight: 3,
+		create: func(r *evalRound) ctypes.HostEvaluator {
+			return &spreadEvaluator{counts: r.counts}
+		},
+	},
+	{
+		weight: 1,
+		create: func(r *evalRound) ctypes.HostEvaluator {
+			return &capEvaluator{max: r.maxPerNode, counts: r.counts}
+		},
+	},
+	{
+		weight: 1,
+		create: func(r *evalRound) ctypes.HostEvaluator {
+			return &coLocateEvaluator{with: r.with, notWith: r.notWith}
+		},
+	},
+	{
+		weight: 4,
+		create: func(r *evalRound) ctypes.HostEvaluator {
+			return newLabelSpreadEvaluator(r.cache, r.spreadBy, r.counts)
+		},
+	},
+}
+
+func newEvaluatorChain(r *evalRound, factories []evaluatorFactory) evaluatorChain {
+	ec := make(evaluatorChain, 0, len(factories))
+	for _, f := range factories {
+		ec = append(ec, weightedEvaluator{
+			weight:    f.weight,
+			evaluator: f.create(r),
+		})
+	}
+	return ec
+}
+
+func ratio(a, b uint64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
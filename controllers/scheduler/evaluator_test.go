@@ -0,0 +1,172 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"testing"
+
+	ctypes "we.com/dolphin/controllers/types"
+	"we.com/dolphin/types"
+)
+
+type fixedEvaluator map[types.HostID]float64
+
+func (fe fixedEvaluator) Evaluat(hid types.HostID) float64 {
+	return fe[hid]
+}
+
+func Test_evaluatorChain_Evaluat(t *testing.T) {
+	spread := &spreadEvaluator{
+		counts: map[types.HostID]int{
+			"h1": 1,
+			"h3": 3,
+		},
+	}
+
+	tests := []struct {
+		name string
+		ec   evaluatorChain
+		host types.HostID
+		want float64
+	}{
+		{
+			name: "spread no instance",
+			ec:   evaluatorChain{{weight: 1, evaluator: spread}},
+			host: "h0",
+			want: 1,
+		},
+		{
+			name: "spread 3 instances",
+			ec:   evaluatorChain{{weight: 1, evaluator: spread}},
+			host: "h3",
+			want: 0.25,
+		},
+		{
+			name: "weighted average",
+			ec: evaluatorChain{
+				{weight: 1, evaluator: spread},
+				{weight: 3, evaluator: fixedEvaluator{"h1": 1}},
+			},
+			host: "h1",
+			want: 0.875,
+		},
+		{
+			name: "negative wins",
+			ec: evaluatorChain{
+				{weight: 1, evaluator: spread},
+				{weight: 1, evaluator: fixedEvaluator{"h0": -1}},
+			},
+			host: "h0",
+			want: -1,
+		},
+		{
+			name: "zero weight ignored",
+			ec: evaluatorChain{
+				{weight: 1, evaluator: spread},
+				{weight: 0, evaluator: fixedEvaluator{"h0": -1}},
+			},
+			host: "h0",
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ec.Evaluat(tt.host); got != tt.want {
+				t.Errorf("evaluatorChain.Evaluat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newEvaluatorChain(t *testing.T) {
+	round := &evalRound{
+		counts: map[types.HostID]int{"h1": 1},
+	}
+
+	factories := []evaluatorFactory{
+		{
+			weight: 1,
+			create: func(r *evalRound) ctypes.HostEvaluator {
+				return &spreadEvaluator{counts: r.counts}
+			},
+		},
+	}
+
+	ec := newEvaluatorChain(round, factories)
+	if got := ec.Evaluat("h1"); got != 0.5 {
+		t.Errorf("newEvaluatorChain().Evaluat() = %v, want %v", got, 0.5)
+	}
+}
+
+func Test_placementEvaluators(t *testing.T) {
+	counts := map[types.HostID]int{
+		"h1": 1,
+		"h2": 2,
+	}
+
+	tests := []struct {
+		name string
+		ev   ctypes.HostEvaluator
+		host types.HostID
+		want float64
+	}{
+		{
+			name: "default cap",
+			ev:   &capEvaluator{counts: counts},
+			host: "h1",
+			want: -1,
+		},
+		{
+			name: "default cap, no instance",
+			ev:   &capEvaluator{counts: counts},
+			host: "h3",
+			want: 1,
+		},
+		{
+			name: "under cap",
+			ev:   &capEvaluator{max: 2, counts: counts},
+			host: "h1",
+			want: 1,
+		},
+		{
+			name: "reach cap",
+			ev:   &capEvaluator{max: 2, counts: counts},
+			host: "h2",
+			want: -1,
+		},
+		{
+			name: "not with",
+			ev:   &coLocateEvaluator{notWith: map[types.HostID]struct{}{"h1": {}}},
+			host: "h1",
+			want: -1,
+		},
+		{
+			name: "with",
+			ev:   &coLocateEvaluator{with: map[types.HostID]struct{}{"h1": {}}},
+			host: "h1",
+			want: 1,
+		},
+		{
+			name: "not in with",
+			ev:   &coLocateEvaluator{with: map[types.HostID]struct{}{"h1": {}}},
+			host: "h2",
+			want: -1,
+		},
+		{
+			name: "no rule",
+			ev:   &coLocateEvaluator{},
+			host: "h2",
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ev.Evaluat(tt.host); got != tt.want {
+				t.Errorf("Evaluat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"we.com/dolphin/types"
+)
+
+// fakeHCManager in memory host config manager
+type fakeHCManager struct {
+	lock sync.RWMutex
+	cfgs map[types.DeployKey]map[types.HostID]types.DeploySpec
+}
+
+func newFakeHCManager() *fakeHCManager {
+	return &fakeHCManager{
+		cfgs: map[types.DeployKey]map[types.HostID]types.DeploySpec{},
+	}
+}
+
+func (m *fakeHCManager) ListDeployKeys() []types.DeployKey {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ret := make([]types.DeployKey, 0, len(m.cfgs))
+	for k := range m.cfgs {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func (m *fakeHCManager) GetHostConfig(key types.DeployKey, hostID types.HostID) *types.DeploySpec {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	spec, ok := m.cfgs[key][hostID]
+	if !ok {
+		return nil
+	}
+	ret := copySpec(spec)
+	return &ret
+}
+
+func (m *fakeHCManager) ListHostConfigs(key types.DeployKey) map[types.HostID]types.DeploySpec {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ret := map[types.HostID]types.DeploySpec{}
+	for h, spec := range m.cfgs[key] {
+		ret[h] = copySpec(spec)
+	}
+	return ret
+}
+
+func (m *fakeHCManager) DeleteHostConfigs(key types.DeployKey, hostIDs ...types.HostID) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, h := range hostIDs {
+		delete(m.cfgs[key], h)
+	}
+	if len(m.cfgs[key]) == 0 {
+		delete(m.cfgs, key)
+	}
+	return nil
+}
+
+func (m *fakeHCManager) SetHostConfig(key types.DeployKey, hostID types.HostID, cfg types.DeploySpec) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.cfgs[key] == nil {
+		m.cfgs[key] = map[types.HostID]types.DeploySpec{}
+	}
+	m.cfgs[key][hostID] = copySpec(cfg)
+	return nil
+}
+
+func (m *fakeHCManager) Destroy() {}
+
+// fakeInfor  instance infor, if agent is true, instances are always the same as host configs,
+// just like agents start instances immediately
+type fakeInfor struct {
+	hc    *fakeHCManager
+	agent bool
+	// stopped  instances returned as newly stopped
+	stopped []*types.Instance
+	// conds  conditions of running instances
+	conds map[types.InstanceID][]*types.Condition
+}
+
+func (fi *fakeInfor) Start(ctx context.Context) error { return nil }
+
+func (fi *fakeInfor) ListDeploykeys() []types.DeployKey {
+	return fi.hc.ListDeployKeys()
+}
+
+func (fi *fakeInfor) NewStartedInstance(key types.DeployKey, d time.Duration) []*types.Instance {
+	return nil
+}
+
+func (fi *fakeInfor) NewStoppedInstance(key types.DeployKey, d time.Duration) []*types.Instance {
+	return fi.stopped
+}
+
+func (fi *fakeInfor) RunningInstance(key types.DeployKey) map[types.InstanceID]*types.Instance {
+	ret := map[types.InstanceID]*types.Instance{}
+	if !fi.agent {
+		return ret
+	}
+
+	for h, spec := range fi.hc.ListHostConfigs(key) {
+		for ver, n := range spec.Info {
+			for i := 0; i < n; i++ {
+				id := types.InstanceID(fmt.Sprintf("%v-%v-%d", h, ver, i))
+				ret[id] = &types.Instance{
+					ID:         id,
+					HostID:     h,
+					Version:    string(ver),
+					LifeCycle:  types.LCRunning,
+					Conditions: fi.conds[id],
+				}
+			}
+		}
+	}
+	return ret
+}
+
+func (fi *fakeInfor) RecentStoppedInstance(key types.DeployKey) map[types.InstanceID]*types.Instance {
+	return nil
+}
+
+func (fi *fakeInfor) GetInstance(key types.DeployKey, insID types.InstanceID) *types.Instance {
+	return fi.RunningInstance(key)[insID]
+}
+
+func (fi *fakeInfor) Notify(ch chan<- types.DeployKey) {}
+
+func (fi *fakeInfor) SetCondition(key types.DeployKey, insID types.InstanceID, cond *types.Condition) {
+	if fi.conds == nil {
+		fi.conds = map[types.InstanceID][]*types.Condition{}
+	}
+	fi.conds[insID] = append(fi.conds[insID], cond)
+}
+
+func (fi *fakeInfor) ClearCondition(key types.DeployKey, insID types.InstanceID, typ types.ConditionType) {
+}
+
+// fakeHealth  health checker returns fixed fail ratios, ratios of versions not in vers are unknown
+type fakeHealth struct {
+	ratio float64
+	known bool
+	vers  map[types.DeployVer]float64
+}
+
+func (h fakeHealth) FailRatio(key types.DeployKey) (float64, bool) {
+	return h.ratio, h.known
+}
+
+func (h fakeHealth) VersionFailRatio(key types.DeployKey, ver types.DeployVer) (float64, bool) {
+	r, ok := h.vers[ver]
+	return r, ok
+}
+
+// memRolloutStore  in memory rolloutStore
+type memRolloutStore map[types.DeployKey]types.RolloutState
+
+func (s memRolloutStore) load(key types.DeployKey) (*types.RolloutState, error) {
+	st, ok := s[key]
+	if !ok {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+func (s memRolloutStore) save(key types.DeployKey, st *types.RolloutState) error {
+	s[key] = *st
+	return nil
+}
+
+func (s memRolloutStore) delete(key types.DeployKey) error {
+	delete(s, key)
+	return nil
+}
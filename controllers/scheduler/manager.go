@@ -11,10 +11,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"we.com/dolphin/controllers/alert"
 	ctypes "we.com/dolphin/controllers/types"
+	"we.com/dolphin/registry/history"
 	"we.com/dolphin/types"
 )
 
@@ -24,12 +26,32 @@ type Manager interface {
 	Deploy(ctx context.Context, dc *types.DeployConfig) error
 	// Update update a deployconfig and trigger a new deployment
 	Update(ctx context.Context, dc *types.DeployConfig) error
+	// Plan  host config changes Deploy or Update of dc would make, without applying them
+	Plan(ctx context.Context, dc *types.DeployConfig) (*types.DeployPlan, error)
 	// RevokeLegacyLease  trigger an instance stop of legacy instaces
 	RevokeLegacyLease(key types.DeployKey) error
 	// RenewLegacyLease  reset lease timeout
 	RenewLegacyLease(key types.DeployKey) error
 	// Destroy delete replicaCtrl and  stop all running instaces
 	Destroy(ctx context.Context, key types.DeployKey) error
+	// History list applied deploy config revisions of key
+	History(key types.DeployKey) ([]*types.DeployRevision, error)
+	// Rollback redeploy  the config of  revision, the config is saved after it is rolled out
+	Rollback(ctx context.Context, key types.DeployKey, revision int64) error
+	// StartRollback  check revision of key can be rolled back to, and roll it back in background
+	// until it is done or the manager stops, progress of it is in RolloutState
+	StartRollback(key types.DeployKey, revision int64) error
+	// RolloutState  progress of the current or the last rollout of key
+	RolloutState(key types.DeployKey) (*types.RolloutState, error)
+	// SetHealthChecker set checker used to judge  canaries
+	SetHealthChecker(hc ctypes.HealthChecker)
+	// SetTrafficShifter set shifter used to move traffic to new version during blue/green deployments
+	SetTrafficShifter(ts ctypes.TrafficShifter)
+	// Drain move all instances on host to other hosts, host should be cordoned first,
+	// or instances may be scheduled back
+	Drain(ctx context.Context, hostID types.HostID) error
+	// Stop stop all background tasks
+	Stop()
 }
 
 type manager struct {
@@ -38,7 +60,14 @@ type manager struct {
 	lock        sync.RWMutex
 	info        ctypes.InstanceInfor
 	hcManager   ctypes.HostConfigManager
+	history     *history.Registry
+	health      ctypes.HealthChecker
+	shifter     ctypes.TrafficShifter
 	controllers map[types.DeployKey]*replicaCtrl
+	// saveConfig  save deploy config a rollback applies
+	saveConfig func(dc *types.DeployConfig) error
+	ctx        context.Context
+	cancel     context.CancelFunc
 }
 
 // NewSchedular  create a new schedual manager
@@ -47,17 +76,70 @@ func NewSchedular(stage types.Stage, lease time.Duration, info ctypes.InstanceIn
 		return nil, errors.New("info and hcmanager cannot be nil")
 	}
 
+	hr, err := history.NewRegistry(stage)
+	if err != nil {
+		return nil, errors.Wrap(err, "create history registry")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	m := manager{
-		stage:     stage,
-		lease:     lease,
-		info:      info,
-		hcManager: hcManager,
+		stage:       stage,
+		lease:       lease,
+		info:        info,
+		hcManager:   hcManager,
+		history:     hr,
+		controllers: map[types.DeployKey]*replicaCtrl{},
+		saveConfig:  saveDeployConfig,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	if err := m.restore(); err != nil {
+		glog.Errorf("sched: env=%v, restore controllers: %v", stage, err)
 	}
+	go m.run()
 
 	return &m, nil
 }
 
 func (m *manager) Stop() {
+	m.cancel()
+}
+
+func (m *manager) newOption() option {
+	return option{
+		maxTries:            3,
+		legacyVerionTimeout: m.lease,
+		dryMode:             false,
+	}
+}
+
+// restore rebuild controllers for every deployed key, and resume unfinished rollouts
+func (m *manager) restore() error {
+	var merr *multierror.Error
+	for _, key := range m.hcManager.ListDeployKeys() {
+		dc, err := loadDeployConfig(m.stage, key)
+		if err != nil {
+			merr = multierror.Append(merr, errors.Wrapf(err, "load deploy config of %v", key))
+			continue
+		}
+
+		c, err := m.newController(dc)
+		if err != nil {
+			merr = multierror.Append(merr, errors.Wrapf(err, "create controller of %v", key))
+			continue
+		}
+
+		st, err := c.states.load(key)
+		if err != nil {
+			merr = multierror.Append(merr, errors.Wrapf(err, "load rollout state of %v", key))
+		}
+
+		m.updateController(key, c)
+		c.resume(m.ctx, st)
+	}
+
+	return merr.ErrorOrNil()
 }
 
 func (m *manager) Deploy(ctx context.Context, dc *types.DeployConfig) error {
@@ -71,31 +153,24 @@ func (m *manager) Deploy(ctx context.Context, dc *types.DeployConfig) error {
 
 	key := dc.Key()
 
-	_, err := m.controlerReady(key)
-	if err != nil {
-		return err
+	if c, ok := m.getController(key); ok {
+		if c == nil {
+			return errors.Errorf("sched: env=%v, %v deployment in process,  please try again later", m.stage, key)
+		}
+		return errors.Errorf("sched: env=%v, %v already deployed", m.stage, key)
 	}
+	// a nil controller indicates deployment is in process
+	m.updateController(key, nil)
 
-	c, err := newReplicaCtrl(dc, m.info, m.hcManager, option{
-		maxTries:            3,
-		legacyVerionTimeout: m.lease,
-		dryMode:             false,
-	})
+	c, err := m.newController(dc)
 
 	if err != nil {
 		m.deleteController(key)
 		return err
 	}
-	c.hcManager = m.hcManager
-
-	err = m.Update(ctx, dc)
-	if err != nil {
-		m.updateController(key, c)
-		return err
-	}
 
 	m.updateController(key, c)
-	return nil
+	return m.Update(ctx, dc)
 }
 
 func (m *manager) Update(ctx context.Context, dc *types.DeployConfig) error {
@@ -112,12 +187,205 @@ func (m *manager) Update(ctx context.Context, dc *types.DeployConfig) error {
 	}
 
 	key := dc.Key()
-	c, ok := m.getController(key)
-	if !ok {
-		return errors.Errorf("sched: unknown %v for env %v", key, m.stage.String())
+	c, err := m.controlerReady(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Deploy(ctx, dc); err != nil {
+		return err
+	}
+
+	if _, err := m.history.Add(dc, 0); err != nil {
+		glog.Errorf("sched: record deploy history of %v: %v", key, err)
+	}
+	return nil
+}
+
+func (m *manager) Plan(ctx context.Context, dc *types.DeployConfig) (*types.DeployPlan, error) {
+	if dc == nil {
+		return nil, errors.New("sched: deploy config cannot be nil")
+	}
+
+	if err := dc.Validate(); err != nil {
+		return nil, err
+	}
+
+	if dc.Stage != m.stage {
+		return nil, errors.Errorf("sched: wrong stage %v for manager %v", dc.Stage.String(), m.stage.String())
+	}
+
+	// plan from the current deploy config, so that update policies see the same previous state
+	key := dc.Key()
+	base := dc
+	if c, _ := m.getController(key); c != nil {
+		cur, _ := c.snapshot()
+		base = &cur
+	}
+
+	opt := m.newOption()
+	opt.dryMode = true
+	hcm := newPlanHCManager(m.hcManager, key)
+	c, err := newReplicaCtrl(base, m.info, hcm, opt)
+	if err != nil {
+		return nil, err
+	}
+	c.states = nil
+	c.hostDead = nil
+	c.saveConfig = nil
+
+	before := m.hcManager.ListHostConfigs(key)
+
+	ndc := *dc
+	if err := c.Deploy(ctx, &ndc); err != nil {
+		return nil, errors.Wrapf(err, "sched: plan %v", key)
+	}
+
+	return buildPlan(key, c.expectVersion, ndc.UpdatePolicy.Policy, before, hcm.ListHostConfigs(key)), nil
+}
+
+func (m *manager) History(key types.DeployKey) ([]*types.DeployRevision, error) {
+	return m.history.List(key)
+}
+
+func (m *manager) Rollback(ctx context.Context, key types.DeployKey, revision int64) error {
+	c, dc, err := m.rollbackConfig(key, revision)
+	if err != nil {
+		return err
+	}
+	return m.rollback(ctx, c, dc, revision)
+}
+
+func (m *manager) StartRollback(key types.DeployKey, revision int64) error {
+	c, dc, err := m.rollbackConfig(key, revision)
+	if err != nil {
+		return err
+	}
+
+	c.stateLock.Lock()
+	busy := c.reconciling
+	c.stateLock.Unlock()
+	if busy {
+		return errors.Errorf("sched: %v is being updated, please try again later", key)
+	}
+
+	go func() {
+		if err := m.rollback(m.ctx, c, dc, revision); err != nil {
+			glog.Errorf("sched: rollback %v to revision %v: %v", key, revision, err)
+		}
+	}()
+	return nil
+}
+
+// rollbackConfig  controller of key, and config of its revision
+func (m *manager) rollbackConfig(key types.DeployKey, revision int64) (*replicaCtrl, *types.DeployConfig, error) {
+	c, err := m.controlerReady(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rev, err := m.history.Get(key, revision)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "sched: get revision %v of %v", revision, key)
+	}
+
+	dc := rev.Config
+	if err := dc.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, &dc, nil
+}
+
+// rollback  deploy dc of revision, it is saved only after rolled out, so a failed rollback
+// does not leave a config which is never applied
+func (m *manager) rollback(ctx context.Context, c *replicaCtrl, dc *types.DeployConfig, revision int64) error {
+	if err := c.Deploy(ctx, dc); err != nil {
+		return err
+	}
+
+	if err := m.saveConfig(dc); err != nil {
+		return errors.Wrap(err, "sched: save deploy config")
+	}
+
+	if _, err := m.history.Add(dc, revision); err != nil {
+		glog.Errorf("sched: record rollback history of %v: %v", dc.Key(), err)
+	}
+	return nil
+}
+
+func (m *manager) RolloutState(key types.DeployKey) (*types.RolloutState, error) {
+	c, err := m.controlerReady(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	st := c.state
+	return &st, nil
+}
+
+func (m *manager) Drain(ctx context.Context, hostID types.HostID) error {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		merr *multierror.Error
+	)
+	for _, key := range m.hcManager.ListDeployKeys() {
+		if m.hcManager.GetHostConfig(key, hostID) == nil {
+			continue
+		}
+
+		c, err := m.controlerReady(key)
+		if err != nil {
+			lock.Lock()
+			merr = multierror.Append(merr, err)
+			lock.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(c *replicaCtrl) {
+			defer wg.Done()
+			if err := c.drainHost(ctx, hostID); err != nil {
+				lock.Lock()
+				merr = multierror.Append(merr, err)
+				lock.Unlock()
+			}
+		}(c)
 	}
+	wg.Wait()
 
-	return c.Deploy(ctx, dc)
+	return merr.ErrorOrNil()
+}
+
+func (m *manager) SetHealthChecker(hc ctypes.HealthChecker) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.health = hc
+}
+
+func (m *manager) SetTrafficShifter(ts ctypes.TrafficShifter) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.shifter = ts
+}
+
+// checkers  health checker and traffic shifter controllers use when rollouts begin
+func (m *manager) checkers() (ctypes.HealthChecker, ctypes.TrafficShifter) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.health, m.shifter
+}
+
+// newController  create a controller of dc, which gets checkers from m
+func (m *manager) newController(dc *types.DeployConfig) (*replicaCtrl, error) {
+	c, err := newReplicaCtrl(dc, m.info, m.hcManager, m.newOption())
+	if err != nil {
+		return nil, err
+	}
+	c.checkers = m.checkers
+	return c, nil
 }
 
 func (m *manager) RevokeLegacyLease(key types.DeployKey) error {
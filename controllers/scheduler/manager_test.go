@@ -0,0 +1,130 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/integration"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/registry/history"
+	"we.com/dolphin/types"
+)
+
+func testDeployConfig(ver string) *types.DeployConfig {
+	return &types.DeployConfig{
+		Stage:         types.Dev,
+		Type:          types.ProjectType("java"),
+		Name:          types.DeployName("crm-server"),
+		ServiceType:   types.ServiceDaemon,
+		NumOfInstance: 4,
+		Image:         &types.Image{Version: types.MustParseVersion(ver)},
+		UpdatePolicy: &types.UpdateOption{
+			Policy:  types.RollingUpdate,
+			Step:    10 * time.Millisecond,
+			Timeout: 200 * time.Millisecond,
+		},
+	}
+}
+
+func Test_manager_Rollback(t *testing.T) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	hr := history.New(types.Dev, generic.New(cluster.RandClient(), etcdkey.DeployHistoryDir(types.Dev)))
+
+	v1, v2 := testDeployConfig("v1.0.0"), testDeployConfig("v2.0.0")
+	for _, dc := range []*types.DeployConfig{v1, v2} {
+		if _, err := hr.Add(dc, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hc := newFakeHCManager()
+	hc.SetHostConfig(testKey, "h1", types.DeploySpec{Info: map[types.DeployVer]int{"v2.0.0": 2}})
+	hc.SetHostConfig(testKey, "h2", types.DeploySpec{Info: map[types.DeployVer]int{"v2.0.0": 2}})
+	info := &fakeInfor{hc: hc, agent: true}
+
+	var (
+		lock  sync.Mutex
+		saved []*types.DeployConfig
+	)
+	m := &manager{
+		stage:       types.Dev,
+		info:        info,
+		hcManager:   hc,
+		history:     hr,
+		controllers: map[types.DeployKey]*replicaCtrl{},
+		saveConfig: func(dc *types.DeployConfig) error {
+			lock.Lock()
+			defer lock.Unlock()
+			saved = append(saved, dc)
+			return nil
+		},
+		ctx: context.Background(),
+	}
+	c, err := newReplicaCtrl(v2, info, hc, m.newOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.states = memRolloutStore{}
+	m.updateController(testKey, c)
+
+	if err := m.Rollback(context.Background(), testKey, 3); err == nil {
+		t.Errorf("Rollback() to unknown revision should fail")
+	}
+	if err := m.Rollback(context.Background(), "java/unknown", 1); err == nil {
+		t.Errorf("Rollback() of unknown deployment should fail")
+	}
+	if len(saved) != 0 {
+		t.Fatalf("failed Rollback() saved deploy config %v", saved)
+	}
+
+	if err := m.Rollback(context.Background(), testKey, 1); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+
+	if len(saved) != 1 || saved[0].ImageVersion() != "v1.0.0" {
+		t.Errorf("Rollback() saved %v, want config of v1.0.0", saved)
+	}
+	numExp, numUnexp := c.hcStat(hc.ListHostConfigs(testKey))
+	if c.expectVersion != "v1.0.0" || numExp != 4 || numUnexp != 0 {
+		t.Errorf("Rollback() got %v instances of %v, %v others, want 4 of v1.0.0", numExp, c.expectVersion, numUnexp)
+	}
+
+	latest, err := hr.Latest(testKey)
+	if err != nil || latest == nil {
+		t.Fatalf("Latest() = %v, %v", latest, err)
+	}
+	if latest.Revision != 3 || latest.RollbackOf != 1 || latest.Version != "v1.0.0" {
+		t.Errorf("Rollback() recorded revision %v rollback of %v version %v, want 3, 1, v1.0.0", latest.Revision, latest.RollbackOf, latest.Version)
+	}
+
+	// rolled out in background, and saved after it is done
+	if err := m.StartRollback(testKey, 2); err != nil {
+		t.Fatalf("StartRollback() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		n := len(saved)
+		lock.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("StartRollback() not rolled out, saved %d configs", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	st, err := m.RolloutState(testKey)
+	if err != nil || st.Version != "v2.0.0" || st.Phase != types.RolloutDone {
+		t.Errorf("RolloutState() = %+v, %v, want v2.0.0 done", st, err)
+	}
+}
@@ -9,6 +9,11 @@ import (
 	"time"
 )
 
+const (
+	// interval to check whether updated instances are running
+	rolloutPollInterval = 5 * time.Second
+)
+
 type option struct {
 	// maxtries time, when deploy a config  fails
 	maxTries            int
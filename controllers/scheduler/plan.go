@@ -0,0 +1,175 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	ctypes "we.com/dolphin/controllers/types"
+	"we.com/dolphin/types"
+)
+
+func copySpec(spec types.DeploySpec) types.DeploySpec {
+	ret := types.DeploySpec{Info: make(map[types.DeployVer]int, len(spec.Info))}
+	for k, v := range spec.Info {
+		ret.Info[k] = v
+	}
+	return ret
+}
+
+// planHCManager  keep host config changes of key in memory, so a deployment can be planed
+// without touching the real host configs, host configs of other keys are read from base
+type planHCManager struct {
+	base ctypes.HostConfigManager
+	key  types.DeployKey
+	lock sync.RWMutex
+	cfgs map[types.HostID]types.DeploySpec
+}
+
+func newPlanHCManager(base ctypes.HostConfigManager, key types.DeployKey) *planHCManager {
+	cfgs := map[types.HostID]types.DeploySpec{}
+	for h, spec := range base.ListHostConfigs(key) {
+		cfgs[h] = copySpec(spec)
+	}
+	return &planHCManager{
+		base: base,
+		key:  key,
+		cfgs: cfgs,
+	}
+}
+
+func (m *planHCManager) ListDeployKeys() []types.DeployKey {
+	keys := m.base.ListDeployKeys()
+	for _, k := range keys {
+		if k == m.key {
+			return keys
+		}
+	}
+	return append(keys, m.key)
+}
+
+func (m *planHCManager) GetHostConfig(key types.DeployKey, hostID types.HostID) *types.DeploySpec {
+	if key != m.key {
+		return m.base.GetHostConfig(key, hostID)
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	spec, ok := m.cfgs[hostID]
+	if !ok {
+		return nil
+	}
+	ret := copySpec(spec)
+	return &ret
+}
+
+func (m *planHCManager) ListHostConfigs(key types.DeployKey) map[types.HostID]types.DeploySpec {
+	if key != m.key {
+		return m.base.ListHostConfigs(key)
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ret := make(map[types.HostID]types.DeploySpec, len(m.cfgs))
+	for h, spec := range m.cfgs {
+		ret[h] = copySpec(spec)
+	}
+	return ret
+}
+
+func (m *planHCManager) DeleteHostConfigs(key types.DeployKey, hostIDs ...types.HostID) error {
+	if key != m.key {
+		return errors.Errorf("sched: plan of %v cannot change host configs of %v", m.key, key)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, h := range hostIDs {
+		delete(m.cfgs, h)
+	}
+	return nil
+}
+
+func (m *planHCManager) SetHostConfig(key types.DeployKey, hostID types.HostID, cfg types.DeploySpec) error {
+	if key != m.key {
+		return errors.Errorf("sched: plan of %v cannot change host configs of %v", m.key, key)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cfgs[hostID] = copySpec(cfg)
+	return nil
+}
+
+func (m *planHCManager) Destroy() {}
+
+// buildPlan  diff host configs before and after a deployment, hosts not changed are omitted
+func buildPlan(key types.DeployKey, ver types.DeployVer, policy types.UpdatePolicyName,
+	before, after map[types.HostID]types.DeploySpec) *types.DeployPlan {
+	ret := &types.DeployPlan{
+		Key:     key,
+		Version: ver,
+		Policy:  policy,
+		Hosts:   []types.HostPlan{},
+	}
+
+	hosts := map[types.HostID]struct{}{}
+	for h := range before {
+		hosts[h] = struct{}{}
+	}
+	for h := range after {
+		hosts[h] = struct{}{}
+	}
+
+	for h := range hosts {
+		b, a := before[h], after[h]
+		hp := types.HostPlan{
+			HostID: h,
+			Before: copySpec(b),
+			After:  copySpec(a),
+		}
+
+		for v, n := range a.Info {
+			if v != ver && n > 0 {
+				if hp.Legacy == nil {
+					hp.Legacy = map[types.DeployVer]int{}
+				}
+				hp.Legacy[v] = n
+			}
+		}
+
+		switch {
+		case len(b.Info) == 0 && len(a.Info) == 0:
+			continue
+		case len(b.Info) == 0:
+			hp.Action = types.PlanAddHost
+		case len(a.Info) == 0:
+			hp.Action = types.PlanRemoveHost
+		case specEqual(a, b) && len(hp.Legacy) == 0:
+			continue
+		default:
+			hp.Action = types.PlanUpdateHost
+		}
+		ret.Hosts = append(ret.Hosts, hp)
+	}
+
+	sort.Slice(ret.Hosts, func(i, j int) bool { return ret.Hosts[i].HostID < ret.Hosts[j].HostID })
+	return ret
+}
+
+func specEqual(a, b types.DeploySpec) bool {
+	if len(a.Info) != len(b.Info) {
+		return false
+	}
+	for v, n := range a.Info {
+		if m, ok := b.Info[v]; !ok || m != n {
+			return false
+		}
+	}
+	return true
+}
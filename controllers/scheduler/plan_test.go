@@ -0,0 +1,75 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"we.com/dolphin/types"
+)
+
+func Test_planRollingUpdate(t *testing.T) {
+	c, hc := newTestCtrl(t, false, legacySpecs())
+	c.opt.dryMode = true
+	plan := newPlanHCManager(hc, testKey)
+	c.hcManager = plan
+
+	if err := c.rollingUpdate(context.Background()); err != nil {
+		t.Fatalf("rollingUpdate() error = %v", err)
+	}
+
+	// host configs are untouched
+	if got := hc.ListHostConfigs(testKey); !reflect.DeepEqual(got, legacySpecs()) {
+		t.Errorf("rollingUpdate() in dry mode changed host configs: %v", got)
+	}
+
+	p := buildPlan(testKey, c.expectVersion, types.RollingUpdate, hc.ListHostConfigs(testKey), plan.ListHostConfigs(testKey))
+	if len(p.Hosts) != 3 {
+		t.Fatalf("buildPlan() got %d hosts, want 3", len(p.Hosts))
+	}
+	for _, hp := range p.Hosts {
+		if hp.Action != types.PlanUpdateHost || len(hp.Legacy) != 0 {
+			t.Errorf("buildPlan() host %v: action %v, legacy %v", hp.HostID, hp.Action, hp.Legacy)
+		}
+		if hp.After.Info["v2"] != hp.Before.Info["v1"] {
+			t.Errorf("buildPlan() host %v: %v -> %v", hp.HostID, hp.Before.Info, hp.After.Info)
+		}
+	}
+}
+
+func Test_buildPlan(t *testing.T) {
+	before := map[types.HostID]types.DeploySpec{
+		"h1": {Info: map[types.DeployVer]int{"v1": 1}},
+		"h2": {Info: map[types.DeployVer]int{"v1": 1}},
+		"h3": {Info: map[types.DeployVer]int{"v2": 1}},
+	}
+	after := map[types.HostID]types.DeploySpec{
+		"h1": {Info: map[types.DeployVer]int{"v1": 1, "v2": 1}},
+		"h3": {Info: map[types.DeployVer]int{"v2": 1}},
+		"h4": {Info: map[types.DeployVer]int{"v2": 1}},
+	}
+
+	got := buildPlan(testKey, "v2", types.MixedUpdate, before, after)
+
+	want := map[types.HostID]types.PlanAction{
+		"h1": types.PlanUpdateHost,
+		"h2": types.PlanRemoveHost,
+		"h4": types.PlanAddHost,
+	}
+	actions := map[types.HostID]types.PlanAction{}
+	for _, hp := range got.Hosts {
+		actions[hp.HostID] = hp.Action
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("buildPlan() actions = %v, want %v", actions, want)
+	}
+
+	if got.Hosts[0].HostID != "h1" || got.Hosts[0].Legacy["v1"] != 1 {
+		t.Errorf("buildPlan() h1 legacy = %v, want v1:1", got.Hosts[0].Legacy)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"we.com/dolphin/controllers/alert"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+)
+
+const (
+	// interval of periodic reconcile
+	reconcileInterval = time.Minute
+	// wait a while after instances change, so starting and stopping instances can settle
+	reconcileDelay = 10 * time.Second
+)
+
+// DriftKind kind of drift between deploy config and host configs
+type DriftKind string
+
+const (
+	// DriftHostDead instances configed on a dead host
+	DriftHostDead DriftKind = "hostDead"
+	// DriftMissing less instances than deploy config
+	DriftMissing DriftKind = "missing"
+	// DriftExtra more instances than deploy config
+	DriftExtra DriftKind = "extra"
+	// DriftGiveUp reconcile failed maxTries times, and will not try until next rollout
+	DriftGiveUp DriftKind = "giveUp"
+)
+
+// DriftEvent a drift found by reconcile, and what is done about it
+type DriftEvent struct {
+	Stage   types.Stage     `json:"stage"`
+	Key     types.DeployKey `json:"key"`
+	Kind    DriftKind       `json:"kind"`
+	Host    types.HostID    `json:"host,omitempty"`
+	Version types.DeployVer `json:"version,omitempty"`
+	Num     int             `json:"num"`
+	Message string          `json:"message,omitempty"`
+	Time    time.Time       `json:"time"`
+}
+
+func (e DriftEvent) String() string {
+	return fmt.Sprintf("env=%v %v %v host=%v version=%v num=%d: %v", e.Stage, e.Key, e.Kind, e.Host, e.Version, e.Num, e.Message)
+}
+
+func (e DriftEvent) alert() alert.Message {
+	pt, dn, _ := types.ParseDeployKey(e.Key)
+	return alert.Message{
+		Labels: map[string]string{
+			"env":       e.Stage.String(),
+			"from":      "dolphin scheduler",
+			"deployKey": string(e.Key),
+			"ptype":     string(pt),
+			"proj":      dn,
+			"why":       string(e.Kind),
+		},
+		Annotations: map[string]string{
+			"msg": e.String(),
+		},
+	}
+}
+
+func (c *replicaCtrl) newDrift(kind DriftKind, host types.HostID, ver types.DeployVer, num int, msg string) DriftEvent {
+	return DriftEvent{
+		Stage:   c.stage,
+		Key:     c.key,
+		Kind:    kind,
+		Host:    host,
+		Version: ver,
+		Num:     num,
+		Message: msg,
+		Time:    time.Now(),
+	}
+}
+
+// isHostDead  host status has a ttl, a host without status is considered dead
+func (c *replicaCtrl) isHostDead(hostID types.HostID) bool {
+	_, err := getHostStatus(c.stage, hostID)
+	return generic.IsNotFound(err)
+}
+
+// beginReconcile  returns false if a rollout is in process, or reconcile is running or has given up
+func (c *replicaCtrl) beginReconcile() bool {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	switch c.state.Phase {
+	case types.RolloutUpdating, types.RolloutPaused:
+		return false
+	}
+	if c.reconciling || c.reconcileFails >= c.maxTries() {
+		return false
+	}
+	c.reconciling = true
+	return true
+}
+
+// endReconcile  returns true if reconcile has failed maxTries times
+func (c *replicaCtrl) endReconcile(err error) bool {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	c.reconciling = false
+	if err == nil {
+		c.reconcileFails = 0
+		return false
+	}
+	c.reconcileFails++
+	return c.reconcileFails >= c.maxTries()
+}
+
+// reconcile  bring host configs back to deploy config: instances on dead hosts are removed,
+// missing instances are added to other hosts, and extra ones are trimmed
+func (c *replicaCtrl) reconcile(ctx context.Context) ([]DriftEvent, error) {
+	var events []DriftEvent
+
+	for h, cfg := range c.hcManager.ListHostConfigs(c.key) {
+		if len(cfg.Info) == 0 || c.hostDead == nil || !c.hostDead(h) {
+			continue
+		}
+		if err := c.hcManager.DeleteHostConfigs(c.key, h); err != nil {
+			return events, errors.Wrapf(err, "sched: remove config of dead host %v", h)
+		}
+		for ver, n := range cfg.Info {
+			events = append(events, c.newDrift(DriftHostDead, h, ver, n, "host is dead, instances removed"))
+		}
+	}
+
+	numExp, _ := c.hcStat(c.hcManager.ListHostConfigs(c.key))
+	diff := c.dc.NumOfInstance - numExp
+	switch {
+	case diff > 0:
+		events = append(events, c.newDrift(DriftMissing, "", c.expectVersion, diff, "add instances"))
+		if err := c.addInstances(ctx, diff); err != nil {
+			return events, err
+		}
+	case diff < 0:
+		events = append(events, c.newDrift(DriftExtra, "", c.expectVersion, -diff, "remove instances"))
+		if err := c.trimInstances(ctx, -diff); err != nil {
+			return events, err
+		}
+	}
+
+	return events, nil
+}
+
+// trimInstances  remove num instances of expectVersion, from hosts running most of them
+func (c *replicaCtrl) trimInstances(ctx context.Context, num int) error {
+	hc := c.hcManager.ListHostConfigs(c.key)
+	hosts := make([]types.HostID, 0, len(hc))
+	for h, cfg := range hc {
+		if cfg.Info[c.expectVersion] > 0 {
+			hosts = append(hosts, h)
+		}
+	}
+
+	for num > 0 && len(hosts) > 0 {
+		sort.Slice(hosts, func(i, j int) bool {
+			ni, nj := hc[hosts[i]].Info[c.expectVersion], hc[hosts[j]].Info[c.expectVersion]
+			if ni != nj {
+				return ni > nj
+			}
+			return hosts[i] < hosts[j]
+		})
+
+		h := hosts[0]
+		if err := c.removeOneInstance(ctx, c.expectVersion, h); err != nil {
+			return err
+		}
+		num--
+
+		hc[h].Info[c.expectVersion]--
+		if hc[h].Info[c.expectVersion] <= 0 {
+			hosts = hosts[1:]
+		}
+	}
+
+	return nil
+}
+
+// run  reconcile controllers periodically, and soon after their instances change
+func (m *manager) run() {
+	changes := make(chan types.DeployKey, 100)
+	m.info.Notify(changes)
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	delay := time.NewTimer(reconcileDelay)
+	delay.Stop()
+	defer delay.Stop()
+
+	pending := map[types.DeployKey]struct{}{}
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.reconcile(m.listControllers()...)
+		case key := <-changes:
+			if len(pending) == 0 {
+				delay.Reset(reconcileDelay)
+			}
+			pending[key] = struct{}{}
+		case <-delay.C:
+			keys := make([]types.DeployKey, 0, len(pending))
+			for k := range pending {
+				keys = append(keys, k)
+			}
+			pending = map[types.DeployKey]struct{}{}
+			m.reconcile(keys...)
+		}
+	}
+}
+
+func (m *manager) reconcile(keys ...types.DeployKey) {
+	for _, key := range keys {
+		c, _ := m.getController(key)
+		if c == nil || !c.beginReconcile() {
+			continue
+		}
+
+		go func(c *replicaCtrl) {
+			events, err := c.reconcile(m.ctx)
+			if c.endReconcile(err) {
+				msg := fmt.Sprintf("reconcile failed %d times, give up until next rollout: %v", c.maxTries(), err)
+				events = append(events, c.newDrift(DriftGiveUp, "", c.expectVersion, 0, msg))
+			} else if err != nil {
+				glog.Errorf("sched: reconcile %v: %v", c.key, err)
+			}
+			m.emitDrifts(events...)
+		}(c)
+	}
+}
+
+func (m *manager) emitDrifts(events ...DriftEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	alerts := make([]alert.Message, 0, len(events))
+	for _, e := range events {
+		glog.Warningf("sched: drift %v", e)
+		alerts = append(alerts, e.alert())
+	}
+	go alert.SendAlerts(alerts...)
+}
+
+func (m *manager) listControllers() []types.DeployKey {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ret := make([]types.DeployKey, 0, len(m.controllers))
+	for k, c := range m.controllers {
+		if c != nil {
+			ret = append(ret, k)
+		}
+	}
+	return ret
+}
@@ -9,6 +9,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -19,15 +20,32 @@ import (
 )
 
 type replicaCtrl struct {
-	opt           option
-	stage         types.Stage
-	key           types.DeployKey
+	opt   option
+	stage types.Stage
+	key   types.DeployKey
+	// dc and expectVersion are written under stateLock, by the one which set reconciling,
+	// others read them by snapshot
 	dc            types.DeployConfig
-	legacyTimer   *time.Timer
 	expectVersion types.DeployVer
 
+	// stateLock protects legacyTimer, state, reconciling and reconcileFails
+	// reconciling is also set when a host is draining, or a rollout is in process
+	stateLock      sync.Mutex
+	legacyTimer    *time.Timer
+	state          types.RolloutState
+	states         rolloutStore
+	reconciling    bool
+	reconcileFails int
+	hostDead       func(hostID types.HostID) bool
+	// saveConfig  save deploy config reverted to when a new version is aborted
+	saveConfig func(dc *types.DeployConfig) error
+
 	info      ctypes.InstanceInfor
 	hcManager ctypes.HostConfigManager
+	// checkers  health checker and traffic shifter to use, they are got when a rollout begins
+	checkers func() (ctypes.HealthChecker, ctypes.TrafficShifter)
+	health   ctypes.HealthChecker
+	shifter  ctypes.TrafficShifter
 }
 
 func newReplicaCtrl(dc *types.DeployConfig, info ctypes.InstanceInfor,
@@ -40,10 +58,6 @@ func newReplicaCtrl(dc *types.DeployConfig, info ctypes.InstanceInfor,
 		return nil, err
 	}
 
-	var version string
-	if dc.Image != nil && dc.Image.Version != nil {
-		version = dc.Image.Version.String()
-	}
 	key := dc.Key()
 
 	ctrl := replicaCtrl{
@@ -51,22 +65,42 @@ func newReplicaCtrl(dc *types.DeployConfig, info ctypes.InstanceInfor,
 		stage:         dc.Stage,
 		dc:            *dc,
 		key:           key,
-		expectVersion: types.DeployVer(version),
+		expectVersion: types.DeployVer(dc.ImageVersion()),
 		info:          info,
 		hcManager:     hcManager,
+		states:        etcdRolloutStore{stage: dc.Stage},
+		saveConfig:    saveDeployConfig,
 	}
 
+	ctrl.hostDead = ctrl.isHostDead
+
 	return &ctrl, nil
 }
 
+// snapshot  current deploy config and expect version
+func (c *replicaCtrl) snapshot() (types.DeployConfig, types.DeployVer) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return c.dc, c.expectVersion
+}
+
+// setConfig  set deploy config and expect version, caller must have set reconciling
+func (c *replicaCtrl) setConfig(dc types.DeployConfig) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.dc = dc
+	c.expectVersion = types.DeployVer(dc.ImageVersion())
+}
+
 func (c *replicaCtrl) checkStatus() error {
+	dc, ver := c.snapshot()
 	hc := c.hcManager.ListHostConfigs(c.key)
-	numExp, numUnexp := c.hcStat(hc)
-	if numExp != c.dc.NumOfInstance {
+	numExp, numUnexp := hcStatOf(hc, ver)
+	if numExp != dc.NumOfInstance {
 		return errors.Errorf("sched: host config spec is not consist with deploy config")
 	}
 
-	if numUnexp > 0 && c.legacyTimer == nil {
+	if numUnexp > 0 && !c.hasLegacyTask() {
 		msg := fmt.Sprintf("sched: %v legacy version instance is running but has not legacy task", numUnexp)
 		err := c.removeLegacyInstanceConfigs()
 
@@ -81,7 +115,7 @@ func (c *replicaCtrl) checkStatus() error {
 
 	insMap := c.info.RunningInstance(c.key)
 
-	expVer := string(c.expectVersion)
+	expVer := string(ver)
 	for _, ins := range insMap {
 		if ins.Version == expVer {
 			numExp--
@@ -108,45 +142,245 @@ func (c *replicaCtrl) checkStatus() error {
 	return merr.ErrorOrNil()
 }
 
-// start deploy a new project
+// start deploy a new project, only one rollout, reconcile or drain runs at a time
 func (c *replicaCtrl) Deploy(ctx context.Context, config *types.DeployConfig) error {
-	c.dc = *config
-	upo := config.UpdatePolicy
-	if upo == nil {
-		upo = types.GetDefaultUpdateOption(config.ServiceType)
-		config.UpdatePolicy = upo
+	return c.rollout(ctx, config, nil)
+}
+
+// rollout  deploy config, from is the config rolled out from, the current config is used if it is nil
+func (c *replicaCtrl) rollout(ctx context.Context, config, from *types.DeployConfig) error {
+	if config.UpdatePolicy == nil {
+		config.UpdatePolicy = types.GetDefaultUpdateOption(config.ServiceType)
 	}
 
+	c.stateLock.Lock()
+	if c.reconciling {
+		c.stateLock.Unlock()
+		return errors.Errorf("sched: %v is being updated, please try again later", c.key)
+	}
+	c.reconciling = true
+	prev := c.dc
+	if from != nil {
+		prev = *from
+	}
+	c.stateLock.Unlock()
+	defer func() {
+		c.stateLock.Lock()
+		c.reconciling = false
+		c.stateLock.Unlock()
+	}()
+
+	c.setConfig(*config)
+	if c.checkers != nil {
+		c.health, c.shifter = c.checkers()
+	}
+
+	c.beginRollout(prev)
+	err := c.deploy(ctx, prev)
+	c.finishRollout(err)
+	return err
+}
+
+func (c *replicaCtrl) deploy(ctx context.Context, prev types.DeployConfig) error {
+	upo := c.dc.UpdatePolicy
 	switch upo.Policy {
 	case types.RollingUpdate:
 		return c.rollingUpdate(ctx)
 	case types.NewDeploy:
-		return c.newDeploy(ctx)
+		return c.blueGreen(ctx, prev, c.newDeploy)
 	case types.MixedUpdate:
-		return c.mixUpdate(ctx)
+		return c.blueGreen(ctx, prev, c.mixUpdate)
+	case types.CanaryUpdate:
+		return c.canaryUpdate(ctx, prev)
 	default:
-		glog.Fatalf("unknown deploy policy of " + string(config.Key()))
+		glog.Fatalf("unknown deploy policy of " + string(c.key))
+	}
+	return nil
+}
+
+// instanceStep  interval between adding or removing two instances
+func (c *replicaCtrl) instanceStep() time.Duration {
+	if c.opt.dryMode {
+		return 0
+	}
+	if c.dc.UpdatePolicy != nil && c.dc.UpdatePolicy.Step > 0 {
+		return c.dc.UpdatePolicy.Step
+	}
+	return 30 * time.Second
+}
+
+func (c *replicaCtrl) maxTries() int {
+	if c.opt.maxTries > 0 {
+		return c.opt.maxTries
+	}
+	return 1
+}
+
+// drainHost  move instances on hostID to other hosts, the host should be cordoned first,
+// or instances may be scheduled back. replacements are added one step a time as update policy
+// says, and instances on host are removed after replacements are running. legacy instances
+// on host are not replaced, they are removed in batches within timeout of update policy
+func (c *replicaCtrl) drainHost(ctx context.Context, hostID types.HostID) error {
+	c.stateLock.Lock()
+	busy := c.reconciling || c.state.Phase == types.RolloutUpdating
+	if !busy {
+		c.reconciling = true
+	}
+	c.stateLock.Unlock()
+	if busy {
+		return errors.Errorf("sched: %v is being updated, please try again later", c.key)
+	}
+	defer func() {
+		c.stateLock.Lock()
+		c.reconciling = false
+		c.stateLock.Unlock()
+	}()
+
+	spec := c.hcManager.GetHostConfig(c.key, hostID)
+	if spec == nil {
+		return nil
+	}
+
+	// legacy instances are not replaced, they are going to be removed anyway
+	if num := spec.Info[c.expectVersion]; num > 0 {
+		glog.Infof("sched: drain %v, move %d instances of %v", hostID, num, c.key)
+		if err := c.addInstances(ctx, num); err != nil {
+			return errors.Wrapf(err, "sched: drain %v", hostID)
+		}
+
+		if !c.opt.dryMode {
+			_, timeout := c.stepAndTimeout()
+			wctx, cancel := context.WithTimeout(ctx, timeout)
+			err := c.waitExpectRunning(wctx)
+			cancel()
+			if err != nil {
+				return errors.Wrapf(err, "sched: drain %v, wait replacements of %v", hostID, c.key)
+			}
+		}
+	}
+
+	if err := c.removeHostLegacy(ctx, hostID); err != nil {
+		return errors.Wrapf(err, "sched: drain %v, remove legacy instances of %v", hostID, c.key)
+	}
+
+	return c.hcManager.DeleteHostConfigs(c.key, hostID)
+}
+
+// removeHostLegacy  remove legacy instances on hostID in batches, one batch per step, within timeout
+func (c *replicaCtrl) removeHostLegacy(ctx context.Context, hostID types.HostID) error {
+	spec := c.hcManager.GetHostConfig(c.key, hostID)
+	if spec == nil {
+		return nil
+	}
+	num := 0
+	for ver, n := range spec.Info {
+		if ver != c.expectVersion {
+			num += n
+		}
+	}
+	if num == 0 {
+		return nil
+	}
+
+	step, timeout := c.stepAndTimeout()
+	if c.opt.dryMode {
+		step = 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	batches := 1
+	if step > 0 {
+		batches = int(timeout / step)
+	}
+	if batches < 1 {
+		batches = 1
+	}
+	batch := int(math.Ceil(float64(num) / float64(batches)))
+
+	removed := 0
+	for removed < num {
+		spec := c.hcManager.GetHostConfig(c.key, hostID)
+		if spec == nil {
+			return nil
+		}
+
+		n := 0
+		for ver, cnt := range spec.Info {
+			for ; ver != c.expectVersion && cnt > 0 && n < batch; cnt-- {
+				if err := c.removeOneInstance(ctx, ver, hostID); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		removed += n
+		glog.Infof("sched: drain %v, %d/%d legacy instances of %v removed", hostID, removed, num, c.key)
+
+		if removed >= num {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return c.rolloutErr(ctx, removed, num)
+		case <-time.After(step):
+		}
 	}
 	return nil
 }
 
+func (c *replicaCtrl) hasLegacyTask() bool {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return c.legacyTimer != nil
+}
+
 func (c *replicaCtrl) renewLease() {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
 	if c.legacyTimer != nil {
 		c.legacyTimer.Reset(c.opt.legacyVerionTimeout)
+		c.state.LegacyDeadline = time.Now().Add(c.opt.legacyVerionTimeout)
+		c.saveStateLocked()
 	}
 }
 
 func (c *replicaCtrl) revokeLease() {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
 	if c.legacyTimer != nil {
 		c.legacyTimer.Reset(time.Millisecond)
 	}
 }
 
 func (c *replicaCtrl) Destroy() error {
+	c.stateLock.Lock()
+	if c.legacyTimer != nil {
+		c.legacyTimer.Stop()
+		c.legacyTimer = nil
+	}
+	c.stateLock.Unlock()
+
 	hc := c.hcManager.ListHostConfigs(c.key)
 
 	hs := make([]types.HostID, 0, len(hc))
-	return c.hcManager.DeleteHostConfigs(c.key, hs...)
+	for h := range hc {
+		hs = append(hs, h)
+	}
+
+	var merr *multierror.Error
+	if err := c.hcManager.DeleteHostConfigs(c.key, hs...); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if c.states != nil {
+		if err := c.states.delete(c.key); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr.ErrorOrNil()
 }
 
 func (c *replicaCtrl) rollingUpdate(ctx context.Context) error {
@@ -175,54 +409,179 @@ func (c *replicaCtrl) rollingUpdate(ctx context.Context) error {
 		numUpdate = numUnexp
 	}
 
-	n, err := c.updateInstances(ctx, numUpdate)
-	if err != nil {
+	if err := c.pacedUpdate(ctx, numUpdate); err != nil {
 		return err
 	}
-	if n != numUpdate {
-		glog.Errorf("sched: bug: rollingUpdate, updated num is not expected")
-	}
 
 	return c.removeLegacyInstanceConfigs()
 }
 
+// stepAndTimeout  step and timeout of update policy
+func (c *replicaCtrl) stepAndTimeout() (time.Duration, time.Duration) {
+	step := 30 * time.Second
+	timeout := 5 * time.Minute
+	if upo := c.dc.UpdatePolicy; upo != nil {
+		if upo.Step > 0 {
+			step = upo.Step
+		}
+		if upo.Timeout > 0 {
+			timeout = upo.Timeout
+		}
+	}
+	if step > timeout {
+		step = timeout
+	}
+	return step, timeout
+}
+
+// pacedUpdate  update num legacy instances to expectVersion in batches, one batch per step,
+// so that all instances are updated within timeout. after every batch, wait the updated instances
+// are running before next batch. if timeout passes, the rollout is paused, and ErrRolloutTimeout
+// is returned, call Deploy again to resume it
+func (c *replicaCtrl) pacedUpdate(ctx context.Context, num int) error {
+	if num <= 0 {
+		return nil
+	}
+
+	// nothing to wait when planning
+	if c.opt.dryMode {
+		_, err := c.updateInstances(ctx, num)
+		return err
+	}
+
+	step, timeout := c.stepAndTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	batches := int(timeout / step)
+	if batches < 1 {
+		batches = 1
+	}
+	batch := int(math.Ceil(float64(num) / float64(batches)))
+
+	updated := 0
+	for updated < num {
+		start := time.Now()
+		n := batch
+		if n > num-updated {
+			n = num - updated
+		}
+
+		cnt, err := c.updateInstances(ctx, n)
+		updated += cnt
+		if err != nil {
+			return err
+		}
+		if cnt == 0 {
+			glog.Warningf("sched: %v no legacy instance left to update, %d/%d updated", c.key, updated, num)
+			return nil
+		}
+
+		if err := c.waitExpectRunning(ctx); err != nil {
+			return errors.Wrapf(err, "sched: %v %d/%d instances updated", c.key, updated, num)
+		}
+
+		if updated >= num {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return c.rolloutErr(ctx, updated, num)
+		case <-time.After(step - time.Since(start)):
+		}
+	}
+
+	return nil
+}
+
+// waitExpectRunning  wait until instances of expectVersion configed in host configs are all running
+func (c *replicaCtrl) waitExpectRunning(ctx context.Context) error {
+	interval := rolloutPollInterval
+	if step, _ := c.stepAndTimeout(); step < interval {
+		interval = step
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		numExp, _ := c.hcStat(c.hcManager.ListHostConfigs(c.key))
+		if c.numRunning(c.expectVersion) >= numExp {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return c.rolloutErr(ctx, c.numRunning(c.expectVersion), numExp)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *replicaCtrl) rolloutErr(ctx context.Context, done, total int) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Wrapf(ErrRolloutTimeout, "%d/%d", done, total)
+	}
+	return ctx.Err()
+}
+
+// numRunning num of running instances of version ver
+func (c *replicaCtrl) numRunning(ver types.DeployVer) int {
+	num := 0
+	for _, ins := range c.info.RunningInstance(c.key) {
+		if ins.Version == string(ver) && ins.LifeCycle == types.LCRunning {
+			num++
+		}
+	}
+	return num
+}
+
 func (c *replicaCtrl) newDeploy(ctx context.Context) error {
 	num := c.dc.NumOfInstance
-	if err := c.addInstances(ctx, num); err != nil {
+	return c.addInstances(ctx, num)
+}
+
+// blueGreen  deploy new version instances beside legacy ones, shift traffic to them, then remove
+// legacy instances after lease. if traffic shifting fails, new version instances are removed
+func (c *replicaCtrl) blueGreen(ctx context.Context, prev types.DeployConfig, deploy func(ctx context.Context) error) error {
+	if err := deploy(ctx); err != nil {
 		return err
 	}
 
-	if c.legacyTimer == nil {
-		tm := time.AfterFunc(c.opt.legacyVerionTimeout, func() {
-			c.removeLegacyInstanceConfigs()
-			c.legacyTimer = nil
-		})
-		c.legacyTimer = tm
+	if err := c.shiftTraffic(ctx, prev); err != nil {
+		return c.abortNewVersion(prev, err)
 	}
 
+	c.scheduleLegacyRemoval(time.Now().Add(c.opt.legacyVerionTimeout))
 	return nil
 }
 
+// shiftTraffic  shift traffic from version of prev to expectVersion, after new instances are running
+func (c *replicaCtrl) shiftTraffic(ctx context.Context, prev types.DeployConfig) error {
+	from := types.DeployVer(prev.ImageVersion())
+	if c.shifter == nil || c.opt.dryMode || from == "" || from == c.expectVersion {
+		return nil
+	}
+
+	_, timeout := c.stepAndTimeout()
+	wctx, cancel := context.WithTimeout(ctx, timeout)
+	err := c.waitExpectRunning(wctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	return c.shifter.Shift(ctx, c.key, from, c.expectVersion, c.health)
+}
+
 func (c *replicaCtrl) mixUpdate(ctx context.Context) error {
 	dc := c.dc
 	upo := dc.UpdatePolicy
 	hc := c.hcManager.ListHostConfigs(c.key)
 
 	numExp, numUnexp := c.hcStat(hc)
-	var err error
-	defer func() {
-		if c.legacyTimer != nil && err == nil {
-			tm := time.AfterFunc(c.opt.legacyVerionTimeout, func() {
-				c.removeLegacyInstanceConfigs()
-				c.legacyTimer = nil
-			})
-			c.legacyTimer = tm
-		}
-	}()
-
 	if numExp > dc.NumOfInstance {
-		_, err = c.removeExpectInstances(ctx, numExp-dc.NumOfInstance)
-		if err != nil {
+		if _, err := c.removeExpectInstances(ctx, numExp-dc.NumOfInstance); err != nil {
 			return err
 		}
 	}
@@ -238,19 +597,18 @@ func (c *replicaCtrl) mixUpdate(ctx context.Context) error {
 	numUpdate := numUnexp - numLegacy
 	if numUpdate <= 0 {
 		numNew = dc.NumOfInstance - numExp
-		err = c.addInstances(ctx, numNew)
-		return err
+		return c.addInstances(ctx, numNew)
 	}
 
 	numNew = dc.NumOfInstance - numUpdate
 	if numNew > 0 {
-		if err = c.addInstances(ctx, numNew); err != nil {
+		if err := c.addInstances(ctx, numNew); err != nil {
 			return err
 		}
 	}
 
 	if numUpdate > dc.NumOfInstance {
-		if _, err = c.updateInstances(ctx, dc.NumOfInstance); err != nil {
+		if err := c.pacedUpdate(ctx, dc.NumOfInstance); err != nil {
 			return err
 		}
 	}
@@ -258,14 +616,16 @@ func (c *replicaCtrl) mixUpdate(ctx context.Context) error {
 	return nil
 }
 
+// removeLegacyInstanceConfigs  it is called by legacy timer and status check too, expect version is read by snapshot
 func (c *replicaCtrl) removeLegacyInstanceConfigs() error {
+	_, ver := c.snapshot()
 	hc := c.hcManager.ListHostConfigs(c.key)
 
 	var merr *multierror.Error
 	for h, cfg := range hc {
 		needUpdate := false
 		for v := range cfg.Info {
-			if v != c.expectVersion {
+			if v != ver {
 				delete(cfg.Info, v)
 				needUpdate = true
 			}
@@ -284,7 +644,7 @@ func (c *replicaCtrl) removeLegacyInstanceConfigs() error {
 	return merr.ErrorOrNil()
 }
 
-// updateInstances update n oldversion instances to expectVersion
+// updateInstances update n oldversion instances to expectVersion at once
 // return num of instance updated, or err
 func (c *replicaCtrl) updateInstances(ctx context.Context, num int) (int, error) {
 	if num <= 0 {
@@ -299,16 +659,10 @@ func (c *replicaCtrl) updateInstances(ctx context.Context, num int) (int, error)
 
 	count := 0
 	var merr *multierror.Error
-	step := 30 * time.Second
-	if c.dc.UpdatePolicy != nil && c.dc.UpdatePolicy.Step > 0 {
-		step = c.dc.UpdatePolicy.Step
-	}
-outer:
 	// restart  old version instances
 	for h, cfg := range hc {
 		_, ok := cfg.Info[c.expectVersion]
 		if len(cfg.Info) == 1 && ok || len(cfg.Info) == 0 {
-			delete(hc, h)
 			continue
 		}
 
@@ -318,31 +672,37 @@ outer:
 			}
 
 			if count >= num {
-				return num, nil
+				break
 			}
 
-			count++
-			v := cfg.Info[c.expectVersion] + 1
-			cfg.Info[c.expectVersion] = v
-			n--
-			if n == 0 {
+			if n > num-count {
+				n = num - count
+			}
+			count += n
+			cfg.Info[c.expectVersion] += n
+			left := cfg.Info[ver] - n
+			if left <= 0 {
 				delete(cfg.Info, ver)
 			} else {
-				cfg.Info[ver] = n
+				cfg.Info[ver] = left
 			}
+		}
 
-			if err := c.hcManager.SetHostConfig(c.key, h, cfg); err != nil {
-				merr = multierror.Append(merr, err)
-			}
+		if err := c.hcManager.SetHostConfig(c.key, h, cfg); err != nil {
+			merr = multierror.Append(merr, err)
+		} else {
+			c.markHostDone(h)
+		}
 
-			select {
-			case <-ctx.Done():
-				merr = multierror.Append(merr, ctx.Err())
-				return count, merr.ErrorOrNil()
-			case <-time.After(step):
-			}
+		if count >= num {
+			break
+		}
 
-			continue outer
+		select {
+		case <-ctx.Done():
+			merr = multierror.Append(merr, ctx.Err())
+			return count, merr.ErrorOrNil()
+		default:
 		}
 	}
 	err := merr.ErrorOrNil()
@@ -352,13 +712,12 @@ outer:
 // addInstances deploy num new instances
 func (c *replicaCtrl) addInstances(ctx context.Context, num int) error {
 	req := toRequire(&c.dc)
-	scheduler := newScheduler(c.stage, c.key, req, c.info)
+	scheduler := newScheduler(c.stage, c.key, req, c.info, c.hcManager)
 	var merr *multierror.Error
-	step := 30 * time.Second
-	if c.dc.UpdatePolicy != nil && c.dc.UpdatePolicy.Step > 0 {
-		step = c.dc.UpdatePolicy.Step
-	}
+	step := c.instanceStep()
 	tm := time.NewTimer(0)
+	defer tm.Stop()
+	fails := 0
 	for num > 0 {
 		select {
 		case <-ctx.Done():
@@ -369,8 +728,13 @@ func (c *replicaCtrl) addInstances(ctx context.Context, num int) error {
 			h, err := scheduler.NextHost()
 			if err != nil {
 				merr = multierror.Append(merr, err)
+				fails++
+				if fails >= c.maxTries() {
+					return errors.Wrapf(merr.ErrorOrNil(), "sched: %v give up after %d tries, %d instances not added", c.key, fails, num)
+				}
 				continue
 			}
+			fails = 0
 
 			if err = c.addOneInstance(ctx, h); err != nil {
 				merr = multierror.Append(merr, err)
@@ -388,11 +752,19 @@ func (c *replicaCtrl) addOneInstance(ctx context.Context, hostID types.HostID) e
 		spec = &types.DeploySpec{}
 	}
 
+	if spec.Info == nil {
+		spec.Info = map[types.DeployVer]int{}
+	}
+
 	nv := types.DeployVer(c.expectVersion)
 	num := spec.Info[nv]
 	spec.Info[nv] = num + 1
 
-	return c.hcManager.SetHostConfig(c.key, hostID, *spec)
+	if err := c.hcManager.SetHostConfig(c.key, hostID, *spec); err != nil {
+		return err
+	}
+	c.markHostDone(hostID)
+	return nil
 }
 
 func (c *replicaCtrl) removeOneInstance(ctx context.Context, ver types.DeployVer, hostID types.HostID) error {
@@ -409,6 +781,8 @@ func (c *replicaCtrl) removeOneInstance(ctx context.Context, ver types.DeployVer
 	v--
 	if v <= 0 {
 		delete(spec.Info, ver)
+	} else {
+		spec.Info[ver] = v
 	}
 
 	if len(spec.Info) == 0 {
@@ -437,6 +811,8 @@ func (c *replicaCtrl) updateOneInstance(ctx context.Context, hostID types.HostID
 	v--
 	if v <= 0 {
 		delete(spec.Info, oldVer)
+	} else {
+		spec.Info[oldVer] = v
 	}
 
 	newVal := spec.Info[newVer]
@@ -445,12 +821,17 @@ func (c *replicaCtrl) updateOneInstance(ctx context.Context, hostID types.HostID
 }
 
 func (c *replicaCtrl) hcStat(hc map[types.HostID]types.DeploySpec) (int, int) {
+	return hcStatOf(hc, c.expectVersion)
+}
+
+// hcStatOf  num of instances of version exp, and of other versions in hc
+func hcStatOf(hc map[types.HostID]types.DeploySpec, exp types.DeployVer) (int, int) {
 	numExp := 0
 	numUnExp := 0
 
 	for _, cfg := range hc {
 		for ver, num := range cfg.Info {
-			if ver == c.expectVersion {
+			if ver == exp {
 				numExp += num
 			} else {
 				numUnExp += num
@@ -463,12 +844,12 @@ func (c *replicaCtrl) hcStat(hc map[types.HostID]types.DeploySpec) (int, int) {
 
 func (c *replicaCtrl) removeExpectInstances(ctx context.Context, num int) (int, error) {
 	count := num
-	step := 30 * time.Second
-	if c.dc.UpdatePolicy != nil && c.dc.UpdatePolicy.Step > 0 {
-		step = c.dc.UpdatePolicy.Step
-	}
+	step := c.instanceStep()
 	for num > 0 {
 		hc := c.hcManager.ListHostConfigs(c.key)
+		if numExp, _ := c.hcStat(hc); numExp == 0 {
+			return count - num, nil
+		}
 		for h, cfg := range hc {
 			for ver := range cfg.Info {
 				if ver != c.expectVersion {
@@ -478,7 +859,7 @@ func (c *replicaCtrl) removeExpectInstances(ctx context.Context, num int) (int,
 					return count - num, err
 				}
 				num--
-				if num < 0 {
+				if num <= 0 {
 					return count, nil
 				}
 				select {
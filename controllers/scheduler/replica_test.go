@@ -0,0 +1,285 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"we.com/dolphin/types"
+)
+
+const testKey = types.DeployKey("java/crm-server")
+
+func newTestCtrl(t *testing.T, agent bool, specs map[types.HostID]types.DeploySpec) (*replicaCtrl, *fakeHCManager) {
+	hc := newFakeHCManager()
+	for h, spec := range specs {
+		hc.SetHostConfig(testKey, h, spec)
+	}
+
+	c := &replicaCtrl{
+		key:           testKey,
+		expectVersion: "v2",
+		dc: types.DeployConfig{
+			NumOfInstance: 4,
+			UpdatePolicy: &types.UpdateOption{
+				Policy:  types.RollingUpdate,
+				Step:    10 * time.Millisecond,
+				Timeout: 200 * time.Millisecond,
+			},
+		},
+		info:      &fakeInfor{hc: hc, agent: agent},
+		hcManager: hc,
+	}
+	return c, hc
+}
+
+func legacySpecs() map[types.HostID]types.DeploySpec {
+	return map[types.HostID]types.DeploySpec{
+		"h1": {Info: map[types.DeployVer]int{"v1": 2}},
+		"h2": {Info: map[types.DeployVer]int{"v1": 1}},
+		"h3": {Info: map[types.DeployVer]int{"v1": 1}},
+	}
+}
+
+func Test_replicaCtrl_rollingUpdate(t *testing.T) {
+	c, hc := newTestCtrl(t, true, legacySpecs())
+
+	if err := c.rollingUpdate(context.Background()); err != nil {
+		t.Fatalf("rollingUpdate() error = %v", err)
+	}
+
+	numExp, numUnexp := c.hcStat(hc.ListHostConfigs(testKey))
+	if numExp != 4 || numUnexp != 0 {
+		t.Errorf("rollingUpdate() got %v expected, %v legacy, want 4, 0", numExp, numUnexp)
+	}
+}
+
+func Test_replicaCtrl_rollingUpdate_timeout(t *testing.T) {
+	c, hc := newTestCtrl(t, false, legacySpecs())
+
+	err := c.rollingUpdate(context.Background())
+	if errors.Cause(err) != ErrRolloutTimeout {
+		t.Fatalf("rollingUpdate() error = %v, want %v", err, ErrRolloutTimeout)
+	}
+
+	// rollout is paused, legacy instances are kept
+	_, numUnexp := c.hcStat(hc.ListHostConfigs(testKey))
+	if numUnexp == 0 {
+		t.Errorf("rollingUpdate() legacy instances removed on timeout")
+	}
+}
+
+func Test_replicaCtrl_updateInstances(t *testing.T) {
+	c, hc := newTestCtrl(t, true, legacySpecs())
+
+	n, err := c.updateInstances(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("updateInstances() error = %v", err)
+	}
+	if n != 3 {
+		t.Errorf("updateInstances() = %v, want 3", n)
+	}
+
+	numExp, numUnexp := c.hcStat(hc.ListHostConfigs(testKey))
+	if numExp != 3 || numUnexp != 1 {
+		t.Errorf("updateInstances() got %v expected, %v legacy, want 3, 1", numExp, numUnexp)
+	}
+}
+
+func Test_replicaCtrl_finishRollout(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want types.RolloutPhase
+	}{
+		{name: "done", want: types.RolloutDone},
+		{name: "timeout", err: errors.Wrap(ErrRolloutTimeout, "h1"), want: types.RolloutPaused},
+		{name: "failed", err: ErrNoHostMeetCondition, want: types.RolloutFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCtrl(t, true, nil)
+			store := memRolloutStore{}
+			c.states = store
+
+			prev := types.DeployConfig{NumOfInstance: 2}
+			c.beginRollout(prev)
+			c.markHostDone("h1")
+			c.markHostDone("h1")
+			c.finishRollout(tt.err)
+
+			st, _ := store.load(testKey)
+			if st == nil {
+				t.Fatalf("finishRollout() state not saved")
+			}
+			if st.Phase != tt.want {
+				t.Errorf("finishRollout() phase = %v, want %v", st.Phase, tt.want)
+			}
+			if st.Version != "v2" || len(st.HostsDone) != 1 {
+				t.Errorf("finishRollout() state = %+v", st)
+			}
+			if st.Prev == nil || st.Prev.NumOfInstance != prev.NumOfInstance {
+				t.Errorf("finishRollout() prev = %+v, want %+v", st.Prev, prev)
+			}
+		})
+	}
+}
+
+func Test_replicaCtrl_beginRollout_keepPrev(t *testing.T) {
+	c, _ := newTestCtrl(t, true, nil)
+	c.states = memRolloutStore{}
+
+	c.beginRollout(types.DeployConfig{NumOfInstance: 2})
+	c.markHostDone("h1")
+	// resumed or retried rollout of the same version starts from the current config
+	c.beginRollout(c.dc)
+
+	st, _ := c.states.load(testKey)
+	if st.Prev == nil || st.Prev.NumOfInstance != 2 {
+		t.Errorf("beginRollout() prev = %+v, want the config before the first try", st.Prev)
+	}
+	if len(st.HostsDone) != 1 {
+		t.Errorf("beginRollout() hosts done = %v, want [h1]", st.HostsDone)
+	}
+
+	c.expectVersion = "v3"
+	c.beginRollout(c.dc)
+	st, _ = c.states.load(testKey)
+	if st.Prev == nil || st.Prev.NumOfInstance != c.dc.NumOfInstance || len(st.HostsDone) != 0 {
+		t.Errorf("beginRollout() of a new version state = %+v", st)
+	}
+}
+
+func Test_replicaCtrl_reconcile(t *testing.T) {
+	tests := []struct {
+		name   string
+		specs  map[types.HostID]types.DeploySpec
+		dead   types.HostID
+		phase  types.RolloutPhase
+		want   map[types.HostID]int
+		drifts []DriftKind
+	}{
+		{
+			name: "dead host",
+			specs: map[types.HostID]types.DeploySpec{
+				"h1": {Info: map[types.DeployVer]int{"v2": 1}},
+				"h2": {Info: map[types.DeployVer]int{"v2": 4}},
+			},
+			dead:   "h1",
+			want:   map[types.HostID]int{"h2": 4},
+			drifts: []DriftKind{DriftHostDead},
+		},
+		{
+			name: "trim extras",
+			specs: map[types.HostID]types.DeploySpec{
+				"h1": {Info: map[types.DeployVer]int{"v2": 2}},
+				"h2": {Info: map[types.DeployVer]int{"v2": 4}},
+			},
+			want:   map[types.HostID]int{"h1": 2, "h2": 2},
+			drifts: []DriftKind{DriftExtra},
+		},
+		{
+			name: "no drift",
+			specs: map[types.HostID]types.DeploySpec{
+				"h1": {Info: map[types.DeployVer]int{"v2": 2}},
+				"h2": {Info: map[types.DeployVer]int{"v2": 2}},
+			},
+			want: map[types.HostID]int{"h1": 2, "h2": 2},
+		},
+		{
+			name: "rollout in process",
+			specs: map[types.HostID]types.DeploySpec{
+				"h1": {Info: map[types.DeployVer]int{"v2": 6}},
+			},
+			phase: types.RolloutUpdating,
+			want:  map[types.HostID]int{"h1": 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, hc := newTestCtrl(t, true, tt.specs)
+			c.state.Phase = tt.phase
+			c.hostDead = func(h types.HostID) bool { return h == tt.dead }
+
+			var events []DriftEvent
+			if c.beginReconcile() {
+				var err error
+				events, err = c.reconcile(context.Background())
+				c.endReconcile(err)
+				if err != nil {
+					t.Fatalf("reconcile() error = %v", err)
+				}
+			}
+
+			got := map[types.HostID]int{}
+			for h, spec := range hc.ListHostConfigs(testKey) {
+				got[h] = spec.Info["v2"]
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reconcile() host configs = %v, want %v", got, tt.want)
+			}
+
+			var kinds []DriftKind
+			for _, e := range events {
+				kinds = append(kinds, e.Kind)
+			}
+			if !reflect.DeepEqual(kinds, tt.drifts) {
+				t.Errorf("reconcile() drifts = %v, want %v", kinds, tt.drifts)
+			}
+		})
+	}
+}
+
+func Test_replicaCtrl_drainHost(t *testing.T) {
+	c, hc := newTestCtrl(t, true, map[types.HostID]types.DeploySpec{
+		"h1": {Info: map[types.DeployVer]int{"v1": 1}},
+		"h2": {Info: map[types.DeployVer]int{"v2": 4}},
+	})
+
+	if err := c.drainHost(context.Background(), "h1"); err != nil {
+		t.Fatalf("drainHost() error = %v", err)
+	}
+	if spec := hc.GetHostConfig(testKey, "h1"); spec != nil {
+		t.Errorf("drainHost() host config left: %v", spec.Info)
+	}
+
+	c.state.Phase = types.RolloutUpdating
+	if err := c.drainHost(context.Background(), "h2"); err == nil {
+		t.Errorf("drainHost() during rollout, want error")
+	}
+}
+
+func Test_replicaCtrl_Deploy_busy(t *testing.T) {
+	c, _ := newTestCtrl(t, true, legacySpecs())
+	c.reconciling = true
+
+	dc := c.dc
+	dc.NumOfInstance = 8
+	if err := c.Deploy(context.Background(), &dc); err == nil {
+		t.Fatalf("Deploy() while reconciling, want error")
+	}
+	if cur, _ := c.snapshot(); cur.NumOfInstance != 4 {
+		t.Errorf("Deploy() refused but changed deploy config to %v instances", cur.NumOfInstance)
+	}
+}
+
+func Test_replicaCtrl_removeHostLegacy(t *testing.T) {
+	c, hc := newTestCtrl(t, true, map[types.HostID]types.DeploySpec{
+		"h1": {Info: map[types.DeployVer]int{"v1": 3, "v2": 1}},
+	})
+
+	if err := c.removeHostLegacy(context.Background(), "h1"); err != nil {
+		t.Fatalf("removeHostLegacy() error = %v", err)
+	}
+	spec := hc.GetHostConfig(testKey, "h1")
+	if spec == nil || !reflect.DeepEqual(spec.Info, map[types.DeployVer]int{"v2": 1}) {
+		t.Errorf("removeHostLegacy() left %v, want only v2", spec)
+	}
+}
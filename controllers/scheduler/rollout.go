@@ -0,0 +1,218 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/registry/history"
+	"we.com/dolphin/types"
+)
+
+// rolloutStore  persist rollout states
+type rolloutStore interface {
+	load(key types.DeployKey) (*types.RolloutState, error)
+	save(key types.DeployKey, st *types.RolloutState) error
+	delete(key types.DeployKey) error
+}
+
+// etcdRolloutStore save rollout states in etcd
+type etcdRolloutStore struct {
+	stage types.Stage
+}
+
+func (s etcdRolloutStore) load(key types.DeployKey) (*types.RolloutState, error) {
+	ret := types.RolloutState{}
+	if err := getObject(etcdkey.DeployRolloutPathOf(s.stage, key), &ret); err != nil {
+		if generic.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (s etcdRolloutStore) save(key types.DeployKey, st *types.RolloutState) error {
+	store, err := getStore()
+	if err != nil {
+		return err
+	}
+	return store.Update(context.Background(), etcdkey.DeployRolloutPathOf(s.stage, key), st, nil, 0)
+}
+
+func (s etcdRolloutStore) delete(key types.DeployKey) error {
+	store, err := getStore()
+	if err != nil {
+		return err
+	}
+	err = store.Delete(context.Background(), etcdkey.DeployRolloutPathOf(s.stage, key), nil)
+	if generic.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// loadDeployConfig  load deploy config of key, if it is not exist, use the latest revision
+func loadDeployConfig(stage types.Stage, key types.DeployKey) (*types.DeployConfig, error) {
+	dc := types.DeployConfig{}
+	err := getObject(etcdkey.DepoyConfigOfKey(stage, key), &dc)
+	if err == nil {
+		return &dc, nil
+	}
+	if !generic.IsNotFound(err) {
+		return nil, err
+	}
+
+	hr, err := history.NewRegistry(stage)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := hr.Latest(key)
+	if err != nil {
+		return nil, err
+	}
+	if rev == nil {
+		return nil, errors.Errorf("sched: no deploy config of %v", key)
+	}
+	return &rev.Config, nil
+}
+
+// saveStateLocked  persist rollout state, caller must hold c.stateLock
+func (c *replicaCtrl) saveStateLocked() {
+	if c.states == nil {
+		return
+	}
+	c.state.UpdateTime = time.Now()
+	if err := c.states.save(c.key, &c.state); err != nil {
+		glog.Errorf("sched: save rollout state of %v: %v", c.key, err)
+	}
+}
+
+// beginRollout  record a rollout from prev, prev of an unfinished rollout of the same version is kept
+func (c *replicaCtrl) beginRollout(prev types.DeployConfig) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	if c.state.Version != c.expectVersion {
+		c.state.HostsDone = nil
+	}
+	if c.state.Version != c.expectVersion || c.state.Phase == types.RolloutDone || c.state.Prev == nil {
+		c.state.Prev = &prev
+	}
+	c.state.Phase = types.RolloutUpdating
+	c.state.Version = c.expectVersion
+	c.state.Message = ""
+	c.reconcileFails = 0
+	c.saveStateLocked()
+}
+
+func (c *replicaCtrl) finishRollout(err error) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	switch {
+	case err == nil && c.legacyTimer != nil:
+		c.state.Phase = types.RolloutWaitingLegacy
+	case err == nil:
+		c.state.Phase = types.RolloutDone
+	case errors.Cause(err) == ErrRolloutTimeout:
+		c.state.Phase = types.RolloutPaused
+		c.state.Message = err.Error()
+	default:
+		c.state.Phase = types.RolloutFailed
+		c.state.Message = err.Error()
+	}
+	c.saveStateLocked()
+}
+
+func (c *replicaCtrl) markHostDone(hostID types.HostID) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	for _, h := range c.state.HostsDone {
+		if h == hostID {
+			return
+		}
+	}
+	c.state.HostsDone = append(c.state.HostsDone, hostID)
+	c.saveStateLocked()
+}
+
+// scheduleLegacyRemoval remove legacy instances at deadline, if it is already scheduled, nothing happens
+func (c *replicaCtrl) scheduleLegacyRemoval(deadline time.Time) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	if c.legacyTimer != nil {
+		return
+	}
+
+	// legacy instances are shown in plans, no need to remove them
+	if c.opt.dryMode {
+		c.state.LegacyDeadline = deadline
+		return
+	}
+
+	c.legacyTimer = time.AfterFunc(deadline.Sub(time.Now()), c.onLegacyTimeout)
+	c.state.LegacyDeadline = deadline
+	c.state.Phase = types.RolloutWaitingLegacy
+	c.saveStateLocked()
+}
+
+func (c *replicaCtrl) onLegacyTimeout() {
+	err := c.removeLegacyInstanceConfigs()
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	c.legacyTimer = nil
+	c.state.LegacyDeadline = time.Time{}
+	if err != nil {
+		glog.Errorf("sched: remove legacy instances of %v: %v", c.key, err)
+		c.state.Phase = types.RolloutFailed
+		c.state.Message = err.Error()
+	} else {
+		c.state.Phase = types.RolloutDone
+	}
+	c.saveStateLocked()
+}
+
+// resume  continue the rollout recorded in st, after server restarts
+func (c *replicaCtrl) resume(ctx context.Context, st *types.RolloutState) {
+	if st == nil {
+		return
+	}
+
+	c.stateLock.Lock()
+	c.state = *st
+	c.stateLock.Unlock()
+
+	if st.Version != c.expectVersion {
+		glog.Warningf("sched: %v rollout state version %v differs with deploy config %v", c.key, st.Version, c.expectVersion)
+	}
+
+	switch st.Phase {
+	case types.RolloutUpdating:
+		glog.Infof("sched: resume rollout of %v, version %v, %d hosts done", c.key, st.Version, len(st.HostsDone))
+		dc, _ := c.snapshot()
+		if st.Prev == nil {
+			glog.Warningf("sched: %v rollout state has no previous deploy config, resume from the current one", c.key)
+		}
+		go func() {
+			if err := c.rollout(ctx, &dc, st.Prev); err != nil {
+				glog.Errorf("sched: resume rollout of %v: %v", c.key, err)
+			}
+		}()
+	case types.RolloutWaitingLegacy:
+		glog.Infof("sched: resume legacy removal of %v at %v", c.key, st.LegacyDeadline)
+		c.scheduleLegacyRemoval(st.LegacyDeadline)
+	}
+}
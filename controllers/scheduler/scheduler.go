@@ -7,11 +7,9 @@ package scheduler
 
 import (
 	"context"
-	"math/rand"
-	"os"
+	"sort"
 
 	"github.com/golang/glog"
-	"github.com/pkg/errors"
 	ctypes "we.com/dolphin/controllers/types"
 	"we.com/dolphin/registry/etcdkey"
 	"we.com/dolphin/registry/generic"
@@ -34,15 +32,20 @@ type scheduler struct {
 	bestEffect bool
 	stage      types.Stage
 	info       ctypes.InstanceInfor
+	hcManager  ctypes.HostConfigManager
 	key        types.DeployKey
+	evaluators []evaluatorFactory
 }
 
-func newScheduler(stage types.Stage, key types.DeployKey, rq *ctypes.Require, info ctypes.InstanceInfor) ctypes.Scheduler {
+func newScheduler(stage types.Stage, key types.DeployKey, rq *ctypes.Require,
+	info ctypes.InstanceInfor, hcManager ctypes.HostConfigManager) ctypes.Scheduler {
 	return &scheduler{
-		require: rq,
-		stage:   stage,
-		key:     key,
-		info:    info,
+		require:    rq,
+		stage:      stage,
+		key:        key,
+		info:       info,
+		hcManager:  hcManager,
+		evaluators: defaultEvaluators,
 	}
 }
 
@@ -71,94 +74,115 @@ func (s *scheduler) selectHost(r labels.Selector) ([]types.HostID, error) {
 	if len(hinfs) == 0 {
 		return nil, nil
 	}
-	ret := make([]types.HostID, len(hinfs))
-	for k, v := range hinfs {
-		ret[k] = types.HostID(v.HostID)
-	}
-
-	return ret, nil
-}
 
-func checkHostStatus(stage types.Stage, hid types.HostID, require types.DeployResource, ins []*types.Instance) error {
-	st, err := getHostStatus(stage, hid)
+	cordoned, err := cordonedHosts(s.stage)
 	if err != nil {
-		return errors.Wrap(err, "scheduler")
+		return nil, err
 	}
 
-	inf, err := getHostInfo(stage, hid)
-	if err != nil {
-		return errors.Wrap(err, "scheduler")
+	ret := make([]types.HostID, 0, len(hinfs))
+	for _, v := range hinfs {
+		if _, ok := cordoned[v.HostName]; ok {
+			glog.V(4).Infof("sched: %v skip cordoned host %v", s.key, v.HostName)
+			continue
+		}
+		ret = append(ret, types.HostID(v.HostID))
 	}
 
-	if st == nil || inf == nil {
-		return errors.Errorf("scheduler: %v both host info and status is nil", hid)
-	}
+	return ret, nil
+}
 
-	free := inf.GetResource()
-	rsv := inf.ResourceReserved
-	used := st.ResourceUsed()
+type hostScore struct {
+	hostID types.HostID
+	score  float64
+}
 
-	free.Subtract(rsv)
-	if used != nil {
-		free.Subtract(*used)
+// instanceCounts  num of instances of key on every host, both expected and running are taken
+// into account, as new placed instances may not running yet
+func (s *scheduler) instanceCounts(key types.DeployKey) map[types.HostID]int {
+	counts := map[types.HostID]int{}
+	for _, v := range s.info.RunningInstance(key) {
+		if v.LifeCycle != types.LCStopped {
+			counts[v.HostID]++
+		}
 	}
 
-	sc := free.Devide(require)
-	if sc <= 0 {
-		return ErrHostShortOfResource
+	if s.hcManager == nil {
+		return counts
 	}
 
-	for _, v := range ins {
-		if v.HostID == hid {
-			return errors.New("already exists")
+	for h, spec := range s.hcManager.ListHostConfigs(key) {
+		n := 0
+		for _, num := range spec.Info {
+			n += num
+		}
+		if n > counts[h] {
+			counts[h] = n
 		}
 	}
-	return nil
+
+	return counts
 }
 
-func (s *scheduler) findSuitableHost() (types.HostID, error) {
-	r := s.require
-	allIns := s.info.RunningInstance(s.key)
-	ins := make([]*types.Instance, 0, len(allIns))
-	for _, v := range allIns {
-		if v.LifeCycle != types.LCStopped {
-			ins = append(ins, v)
+// hostsOf hosts which have instances of any of the keys
+func (s *scheduler) hostsOf(keys []types.DeployKey) map[types.HostID]struct{} {
+	ret := map[types.HostID]struct{}{}
+	for _, k := range keys {
+		for h, n := range s.instanceCounts(k) {
+			if n > 0 {
+				ret[h] = struct{}{}
+			}
 		}
 	}
+	return ret
+}
 
-	maxTry := 50
-	best := emptyHost
-
-	avaliable := s.avaliable
-	defer func() { s.avaliable = avaliable }()
-	var err error
-
-	for len(avaliable) > 0 && maxTry > 0 {
-		maxTry--
-
-		idx := rand.Intn(len(avaliable))
-		hid := avaliable[idx]
-
-		avaliable[idx] = avaliable[len(avaliable)-1]
-		avaliable = avaliable[:len(avaliable)-1]
+// rankHosts  score every candidate host, return schedualable hosts order by score desc,
+// hosts with the same score are ordered by host id, so placement is deterministic
+func (s *scheduler) rankHosts(hosts []types.HostID) []hostScore {
+	round := &evalRound{
+		cache:      newHostStateCache(s.stage),
+		require:    s.require.Resource,
+		counts:     s.instanceCounts(s.key),
+		maxPerNode: s.require.MaxPerNode,
+	}
 
-		terr := checkHostStatus(s.stage, hid, r.Resource, ins)
-		if terr == nil {
-			return hid, nil
+	if af := s.require.Affinity; af != nil {
+		round.spreadBy = af.SpreadBy
+		if len(af.With) > 0 {
+			round.with = s.hostsOf(af.With)
 		}
-
-		if os.IsExist(terr) {
-			best = hid
-			err = terr
+		round.notWith = s.hostsOf(af.NotWith)
+	}
+	ev := newEvaluatorChain(round, s.evaluators)
+
+	ret := make([]hostScore, 0, len(hosts))
+	for _, hid := range hosts {
+		sc := ev.Evaluat(hid)
+		glog.V(10).Infof("scheduler: %v score of host %v: %v", s.key, hid, sc)
+		if sc < 0 {
+			continue
 		}
+		ret = append(ret, hostScore{hostID: hid, score: sc})
+	}
 
-		glog.Warningf("scheduler: %v", terr)
-		if best == emptyHost {
-			err = terr
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].score != ret[j].score {
+			return ret[i].score > ret[j].score
 		}
+		return ret[i].hostID < ret[j].hostID
+	})
+
+	return ret
+}
+
+func (s *scheduler) findSuitableHost() (types.HostID, error) {
+	ranked := s.rankHosts(s.avaliable)
+	if len(ranked) == 0 {
+		return emptyHost, ErrNoHostMeetCondition
 	}
 
-	return best, err
+	return ranked[0].hostID, nil
 }
 
 func (s *scheduler) NextHost() (types.HostID, error) {
@@ -37,6 +37,8 @@ func toRequire(dc *types.DeployConfig) *ctypes.Require {
 	ret := &ctypes.Require{
 		HostSelector: s,
 		Resource:     *rr,
+		MaxPerNode:   dc.MaxInstancesPerNode,
+		Affinity:     dc.Affinity,
 	}
 
 	return ret
@@ -54,7 +56,7 @@ func getHostStatus(stage types.Stage, hostID types.HostID) (*types.HostStatus, e
 }
 
 func getHostInfo(stage types.Stage, hostID types.HostID) (*types.HostInfo, error) {
-	path := etcdkey.HostStatPath(stage, hostID)
+	path := etcdkey.HostInfoPath(stage, hostID)
 	ret := types.HostInfo{}
 
 	if err := getObject(path, &ret); err != nil {
@@ -64,6 +66,30 @@ func getHostInfo(stage types.Stage, hostID types.HostID) (*types.HostInfo, error
 	return &ret, nil
 }
 
+// cordonedHosts  names of unschedulable hosts
+func cordonedHosts(stage types.Stage) (map[types.HostName]struct{}, error) {
+	store, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+
+	hcs := []*types.HostConfig{}
+	if err := store.List(context.Background(), etcdkey.HostConfigDir(stage), generic.Everything, &hcs); err != nil {
+		if generic.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ret := map[types.HostName]struct{}{}
+	for _, hc := range hcs {
+		if hc.Unschedulable {
+			ret[types.HostName(hc.HostName)] = struct{}{}
+		}
+	}
+	return ret, nil
+}
+
 func getObject(path string, obj interface{}) error {
 	store, err := getStore()
 	if err != nil {
@@ -76,6 +102,16 @@ func getObject(path string, obj interface{}) error {
 	return nil
 }
 
+func saveDeployConfig(dc *types.DeployConfig) error {
+	store, err := getStore()
+	if err != nil {
+		return err
+	}
+
+	path := etcdkey.DepoyConfigOfKey(dc.Stage, dc.Key())
+	return store.Update(context.Background(), path, dc, nil, 0)
+}
+
 var (
 	errStop = errors.New("stop watch")
 )
@@ -26,6 +26,9 @@ type insManager struct {
 	stage     types.Stage
 	lock      sync.RWMutex
 	instances map[types.DeployKey]map[types.InstanceID]*types.Instance
+	notifiers []chan<- types.DeployKey
+	// marks  conditions set by SetCondition, applied to instances on every update
+	marks map[types.InstanceID]map[types.ConditionType]*types.Condition
 }
 
 // NewInfor create a new instance Infor
@@ -33,6 +36,7 @@ func NewInfor(stage types.Stage) ctypes.InstanceInfor {
 	return &insManager{
 		stage:     stage,
 		instances: map[types.DeployKey]map[types.InstanceID]*types.Instance{},
+		marks:     map[types.InstanceID]map[types.ConditionType]*types.Condition{},
 	}
 }
 
@@ -46,6 +50,21 @@ func (m *insManager) GetInstance(key types.DeployKey, insID types.InstanceID) *t
 	return s[insID]
 }
 
+func (m *insManager) Notify(ch chan<- types.DeployKey) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.notifiers = append(m.notifiers, ch)
+}
+
+func (m *insManager) notify(key types.DeployKey) {
+	for _, ch := range m.notifiers {
+		select {
+		case ch <- key:
+		default:
+		}
+	}
+}
+
 func (m *insManager) Start(ctx context.Context) error {
 	go m.watch(ctx)
 	return nil
@@ -117,6 +136,76 @@ func (m *insManager) RecentStoppedInstance(key types.DeployKey) map[types.Instan
 	return ret
 }
 
+func (m *insManager) SetCondition(key types.DeployKey, insID types.InstanceID, cond *types.Condition) {
+	if cond == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ins := m.instances[key][insID]
+	if ins == nil || ins.LifeCycle == types.LCStopped {
+		return
+	}
+
+	marks := m.marks[insID]
+	if marks == nil {
+		marks = map[types.ConditionType]*types.Condition{}
+		m.marks[insID] = marks
+	}
+	old := marks[cond.Type]
+	marks[cond.Type] = cond
+	m.instances[key][insID] = replaceCondition(ins, old, cond)
+	m.notify(key)
+}
+
+func (m *insManager) ClearCondition(key types.DeployKey, insID types.InstanceID, typ types.ConditionType) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	old := m.marks[insID][typ]
+	if old == nil {
+		return
+	}
+	delete(m.marks[insID], typ)
+	if len(m.marks[insID]) == 0 {
+		delete(m.marks, insID)
+	}
+
+	if ins := m.instances[key][insID]; ins != nil {
+		m.instances[key][insID] = replaceCondition(ins, old, nil)
+		m.notify(key)
+	}
+}
+
+// withMarks  ins with conditions set by SetCondition, ins must be newly received
+func (m *insManager) withMarks(ins *types.Instance) *types.Instance {
+	marks := m.marks[ins.ID]
+	if len(marks) == 0 {
+		return ins
+	}
+	for _, cond := range marks {
+		ins.Conditions = append(ins.Conditions, cond)
+	}
+	return ins
+}
+
+// replaceCondition  copy of ins with condition old replaced by cond, instances returned by
+// RunningInstance may be in use, so they are never modified
+func replaceCondition(ins *types.Instance, old, cond *types.Condition) *types.Instance {
+	ret := *ins
+	ret.Conditions = make([]*types.Condition, 0, len(ins.Conditions)+1)
+	for _, c := range ins.Conditions {
+		if c != old {
+			ret.Conditions = append(ret.Conditions, c)
+		}
+	}
+	if cond != nil {
+		ret.Conditions = append(ret.Conditions, cond)
+	}
+	return &ret
+}
+
 func (m *insManager) watch(ctx context.Context) {
 	path := etcdkey.DeployInstanceDir(m.stage)
 	watchEvent(ctx, path, generic.Everything, reflect.TypeOf(&registry.Instance{}), m.handleInstanceEvent)
@@ -141,12 +230,13 @@ func (m *insManager) handleInstanceEvent(e watch.Event) error {
 			insMap = map[types.InstanceID]*types.Instance{}
 			m.instances[key] = insMap
 		}
-		insMap[insID] = dat
+		insMap[insID] = m.withMarks(dat)
 
 	case watch.Deleted:
 		if dat.LifeCycle != types.LCStopped {
 			glog.Errorf("instance: node %v:%v:%v delete, but it has not stopped", dat.Host, dat.DeployKey(), dat.Pid)
 		}
+		delete(m.marks, insID)
 		insMap := m.instances[key]
 		if insMap == nil {
 			return nil
@@ -154,6 +244,7 @@ func (m *insManager) handleInstanceEvent(e watch.Event) error {
 		delete(insMap, insID)
 	}
 
+	m.notify(key)
 	return nil
 }
 
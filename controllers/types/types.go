@@ -30,6 +30,9 @@ type HostEvaluator interface {
 type Require struct {
 	HostSelector labels.Selector
 	Resource     types.DeployResource
+	// MaxPerNode max instances per host, 0 means 1
+	MaxPerNode int
+	Affinity   *types.Affinity
 }
 
 // Scheduler schedual a depoly to list of hosts
@@ -46,6 +49,38 @@ type InstanceInfor interface {
 	RunningInstance(key types.DeployKey) map[types.InstanceID]*types.Instance
 	RecentStoppedInstance(key types.DeployKey) map[types.InstanceID]*types.Instance
 	GetInstance(key types.DeployKey, insID types.InstanceID) *types.Instance
+	// Notify  deploy keys whose instances changed are sent to ch,  sends never block
+	Notify(ch chan<- types.DeployKey)
+	// SetCondition  add a condition to a running instance, replacing the one of same type set before,
+	// it is kept across updates of the instance, until cleared or the instance is deleted
+	SetCondition(key types.DeployKey, insID types.InstanceID, cond *types.Condition)
+	// ClearCondition  remove condition of type typ set by SetCondition
+	ClearCondition(key types.DeployKey, insID types.InstanceID, typ types.ConditionType)
+}
+
+// HealthChecker  health of running instances of a deployment, eg: probe fail ratio
+type HealthChecker interface {
+	// FailRatio recent fail ratio of instances of key, ok is false if it is unknown
+	FailRatio(key types.DeployKey) (ratio float64, ok bool)
+}
+
+// VersionHealthChecker  health of instances of a version, HealthChecker may implement it
+type VersionHealthChecker interface {
+	// VersionFailRatio recent fail ratio of instances of version ver, ok is false if it is unknown
+	VersionFailRatio(key types.DeployKey, ver types.DeployVer) (ratio float64, ok bool)
+}
+
+// ProbedHealthChecker  if health of a deployment is probed at all, HealthChecker may implement it
+type ProbedHealthChecker interface {
+	// Probed  if instances of key are probed, fail ratio of a deployment not probed is never known
+	Probed(key types.DeployKey) bool
+}
+
+// TrafficShifter  shift traffic of a deployment from legacy version to new version
+type TrafficShifter interface {
+	// Shift shift traffic from version from to version to step by step, if health says new version
+	// is unhealthy, traffic is reverted to from and an error is returned
+	Shift(ctx context.Context, key types.DeployKey, from, to types.DeployVer, health HealthChecker) error
 }
 
 // HostConfigManager host  deploy config manger
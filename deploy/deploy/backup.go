@@ -4,3 +4,366 @@ Sniperkit-Bot
 */
 
 package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	git "gopkg.in/src-d/go-git.v4"
+	"we.com/dolphin/types"
+)
+
+// defaultKeepBackups  backups kept on a host for a deployment
+const defaultKeepBackups = 5
+
+// backupDir  where backup index files are kept, one for each deployment: backupDir/{deployKey}.json
+var backupDir = "/data/backups/"
+
+// backupRecord  a backup, with where it is taken from
+type backupRecord struct {
+	types.DeployBackup
+	Image types.ImageName `json:"image,omitempty"`
+	// Worktree  name of the worktree backed up
+	Worktree string `json:"worktree,omitempty"`
+	// Path  dir of the worktree
+	Path string `json:"path,omitempty"`
+	// Link  symbolic link pointed to Path when it is restored, empty for Inplace
+	Link string `json:"link,omitempty"`
+}
+
+// backupIndex  backups of a deployment on this host
+type backupIndex struct {
+	// Version  version currently deployed
+	Version *types.Version `json:"version,omitempty"`
+	// Backups  newest first
+	Backups []backupRecord `json:"backups,omitempty"`
+}
+
+func indexPath(key types.DeployKey) string {
+	return filepath.Join(backupDir, fmt.Sprintf("%v.json", key))
+}
+
+func loadIndex(path string) (*backupIndex, error) {
+	idx := &backupIndex{}
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "deploy: read backup index")
+	}
+	if err := json.Unmarshal(dat, idx); err != nil {
+		return nil, errors.Wrapf(err, "deploy: decode backup index %v", path)
+	}
+	return idx, nil
+}
+
+// saveIndex  write idx to path, by renaming a temporary file over it
+func saveIndex(path string, idx *backupIndex) error {
+	dat, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "deploy: create backup dir")
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, dat, 0644); err != nil {
+		return errors.Wrap(err, "deploy: write backup index")
+	}
+	return errors.Wrap(os.Rename(tmp, path), "deploy: write backup index")
+}
+
+// prune  drop backups of idx except the newest keep ones, returns dropped ones
+func (idx *backupIndex) prune(keep int) []backupRecord {
+	if keep <= 0 {
+		keep = defaultKeepBackups
+	}
+	if len(idx.Backups) <= keep {
+		return nil
+	}
+	dropped := idx.Backups[keep:]
+	idx.Backups = idx.Backups[:keep]
+	return dropped
+}
+
+func (idx *backupIndex) history() []types.DeployBackup {
+	ret := make([]types.DeployBackup, 0, len(idx.Backups))
+	for _, b := range idx.Backups {
+		ret = append(ret, b.DeployBackup)
+	}
+	return ret
+}
+
+// History  backups of deployment key on this host, newest first
+func (m *manager) History(key types.DeployKey) ([]types.DeployBackup, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	idx, err := loadIndex(indexPath(key))
+	if err != nil {
+		return nil, err
+	}
+	return idx.history(), nil
+}
+
+// current  the worktree dc is currently deployed to, nil if dc has not been deployed on this host
+func (m *manager) current(dc *types.DeployConfig) (*target, error) {
+	dd := dc.GetDeployDir()
+	name := string(dc.Name)
+	link := filepath.Join(dd, name)
+
+	if dc.DeployPolicy == types.ABWorld || dc.DeployPolicy == types.Versioned {
+		cur, err := currentLink(link)
+		if err != nil || cur == "" {
+			return nil, err
+		}
+		name = cur
+	} else {
+		link = ""
+	}
+
+	wt, err := m.imageManager.Worktree(dc.Image.Name, name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &target{wt: wt, path: filepath.Join(dd, name), link: link}, nil
+}
+
+// Backup  take a snapshot of the current deployment of dc, and drop backups beyond KeepBackups of dc,
+// key is empty if dc has not been deployed on this host
+func (m *manager) Backup(dc *types.DeployConfig) (types.UUID, error) {
+	if dc == nil || dc.Image == nil {
+		return "", &terror{code: imageCanntEmpty}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	t, err := m.current(dc)
+	if err != nil || t == nil {
+		return "", err
+	}
+
+	path := indexPath(dc.Key())
+	idx, err := loadIndex(path)
+	if err != nil {
+		return "", err
+	}
+
+	b := backupRecord{
+		DeployBackup: types.DeployBackup{
+			Deploy: dc.Key(),
+			Host:   m.host,
+			Time:   time.Now(),
+		},
+		Image:    dc.Image.Name,
+		Worktree: filepath.Base(t.path),
+		Path:     t.path,
+		Link:     t.link,
+	}
+	if idx.Version != nil {
+		b.Version = *idx.Version
+	}
+
+	h, err := t.wt.Backup(fmt.Sprintf("backup %v version %v before deploy", dc.Key(), b.Version))
+	if err != nil {
+		return "", errors.Wrap(err, "deploy: backup worktree")
+	}
+	b.Key = types.UUID(h)
+
+	idx.Backups = append([]backupRecord{b}, idx.Backups...)
+	for _, v := range idx.prune(dc.KeepBackups) {
+		glog.V(4).Infof("deploy: %v drop backup %v of %v", dc.Key(), v.Key, v.Time)
+	}
+	if err := saveIndex(path, idx); err != nil {
+		return "", err
+	}
+
+	m.reportBackups(dc.Key(), idx)
+	return b.Key, nil
+}
+
+// backedUpWorktrees  names of worktrees backups of key are taken from
+func (m *manager) backedUpWorktrees(key types.DeployKey) (map[string]struct{}, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	idx, err := loadIndex(indexPath(key))
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]struct{}, len(idx.Backups))
+	for _, b := range idx.Backups {
+		ret[b.Worktree] = struct{}{}
+	}
+	return ret, nil
+}
+
+// deployed  record ver as the version of dc currently deployed, used by later backups
+func (m *manager) deployed(dc *types.DeployConfig, ver types.Version) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	path := indexPath(dc.Key())
+	idx, err := loadIndex(path)
+	if err != nil {
+		return err
+	}
+	idx.Version = &ver
+	return saveIndex(path, idx)
+}
+
+// findBackup  index path and record of backup key
+func findBackup(key types.UUID) (string, *backupRecord, error) {
+	var (
+		found string
+		rec   *backupRecord
+	)
+	err := filepath.Walk(backupDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !strings.HasSuffix(path, ".json") || rec != nil {
+			return err
+		}
+		idx, err := loadIndex(path)
+		if err != nil {
+			glog.Warningf("deploy: %v", err)
+			return nil
+		}
+		for i := range idx.Backups {
+			if idx.Backups[i].Key == key {
+				found, rec = path, &idx.Backups[i]
+				return nil
+			}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return "", nil, errors.Wrap(err, "deploy: walk backup dir")
+	}
+	if rec == nil {
+		return "", nil, &terror{code: backupNotExist, msg: string(key)}
+	}
+	return found, rec, nil
+}
+
+// lookupBackup  findBackup with backup index files locked
+func (m *manager) lookupBackup(key types.UUID) (string, *backupRecord, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return findBackup(key)
+}
+
+// Restore  hard reset the worktree backup key is taken from to it,
+// for ABWorld and Versioned the worktree is made current too.
+// local changes after the backup are lost, instances are not restarted.
+// the restore is reported as a deploy of the backup version, and backups are reported after it.
+// it waits for deploys of the same deployment, they never run at the same time
+func (m *manager) Restore(ctx context.Context, key types.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, b, err := m.lookupBackup(key)
+	if err != nil {
+		return err
+	}
+
+	l := m.keyLock(b.Deploy)
+	l.Lock()
+	defer l.Unlock()
+
+	// the backup may be dropped by a deploy finished while waiting
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, b, err := m.lookupBackup(key)
+	if err != nil {
+		return err
+	}
+
+	st := &types.Deployment{
+		Stage:      m.stage,
+		Host:       m.host,
+		HostName:   m.hostname,
+		Version:    b.Version,
+		DeployTime: time.Now(),
+	}
+	if pt, name, err := types.ParseDeployKey(b.Deploy); err == nil {
+		st.Type, st.Name = pt, types.DeployName(name)
+	}
+	m.report(st, types.PhaseApply, nil)
+
+	if err := m.restore(ctx, path, b); err != nil {
+		m.report(st, types.PhaseFailed, err)
+		return err
+	}
+	m.report(st, types.PhaseDone, nil)
+	return nil
+}
+
+func (m *manager) restore(ctx context.Context, path string, b *backupRecord) error {
+	wt, err := m.imageManager.Worktree(b.Image, b.Worktree)
+	if err != nil {
+		return errors.Wrapf(err, "deploy: open worktree %v", b.Worktree)
+	}
+	// nothing is changed yet, it is the last chance to give up
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := wt.Reset(string(b.Key), git.HardReset); err != nil {
+		return err
+	}
+	t := &target{wt: wt, path: b.Path, link: b.Link}
+	if err := t.activate(); err != nil {
+		return err
+	}
+	glog.Infof("deploy: %v restored to backup %v, version %v", b.Deploy, b.Key, b.Version)
+
+	idx, err := m.restored(path, b.Version)
+	if err != nil {
+		return err
+	}
+	m.reportBackups(b.Deploy, idx)
+	return nil
+}
+
+// restored  record ver restored as the version currently deployed in index file path
+func (m *manager) restored(path string, ver types.Version) (*backupIndex, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	idx, err := loadIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	idx.Version = &ver
+	if err := saveIndex(path, idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// reportBackups  report backups of key, it is best effort, errors are only logged
+func (m *manager) reportBackups(key types.DeployKey, idx *backupIndex) {
+	br, ok := m.reporter.(BackupReporter)
+	if !ok {
+		return
+	}
+	if err := br.UpdateBackups(key, m.host, idx.history()); err != nil {
+		glog.Warningf("deploy: report backups of %v: %v", key, err)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package deploy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"we.com/dolphin/types"
+)
+
+func Test_backupIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := backupDir
+	backupDir = dir
+	defer func() { backupDir = old }()
+
+	key := types.DeployKey("java/crm")
+	path := indexPath(key)
+	if path != filepath.Join(dir, "java", "crm.json") {
+		t.Fatalf("indexPath() = %v", path)
+	}
+
+	idx, err := loadIndex(path)
+	if err != nil || len(idx.Backups) != 0 {
+		t.Fatalf("loadIndex() of missing file = %v, %v, want empty", idx, err)
+	}
+
+	now := time.Now()
+	for i, k := range []string{"c3", "c2", "c1"} {
+		idx.Backups = append(idx.Backups, backupRecord{
+			DeployBackup: types.DeployBackup{Key: types.UUID(k), Deploy: key, Time: now.Add(-time.Duration(i) * time.Minute)},
+			Worktree:     "crm",
+		})
+	}
+
+	if dropped := idx.prune(2); len(dropped) != 1 || dropped[0].Key != "c1" {
+		t.Errorf("prune(2) dropped %v, want c1", dropped)
+	}
+	if dropped := idx.prune(0); dropped != nil {
+		t.Errorf("prune(0) should keep %v backups, dropped %v", defaultKeepBackups, dropped)
+	}
+
+	if err := saveIndex(path, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	p, b, err := findBackup("c2")
+	if err != nil || p != path || b.Worktree != "crm" {
+		t.Errorf("findBackup(c2) = %v, %v, %v", p, b, err)
+	}
+	if _, _, err := findBackup("c1"); err == nil {
+		t.Errorf("findBackup() of a pruned backup should fail")
+	}
+
+	h := idx.history()
+	if len(h) != 2 || h[0].Key != "c3" || h[1].Key != "c2" {
+		t.Errorf("history() = %v, want c3, c2", h)
+	}
+}
@@ -8,10 +8,10 @@ package deploy
 import (
 	"context"
 	"fmt"
-	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	git "gopkg.in/src-d/go-git.v4"
 	"we.com/dolphin/deploy/image"
@@ -20,55 +20,124 @@ import (
 
 type manager struct {
 	stage        types.Stage
+	host         types.HostID
+	hostname     types.HostName
 	deployName   types.DeployKey
-	backuper     Backuper
 	imageManager image.Manager
+	reporter     StatusReporter
+	restarter    Restarter
+	// verify  how images are verified before deployed, nil to skip
+	verify *types.ImageVerify
+
+	// lock  guards backup index files and keyLocks
+	lock sync.Mutex
+	// keyLocks  serialize deploys and restores of a deployment
+	keyLocks map[types.DeployKey]*sync.Mutex
 }
 
-// New create a new manager
-//TODO
-func New() (Deployer, error) {
+// restartTimeout  max time to restart instances of a deployment
+const restartTimeout = 5 * time.Minute
 
-	return &manager{}, nil
-}
+// New create a new manager, which deploys images of im on host, after they are verified as iv requires,
+// deploy status is reported to reporter and instances are restarted by restarter, if they are not nil
+func New(stage types.Stage, host types.HostID, hostname types.HostName, im image.Manager,
+	iv *types.ImageVerify, reporter StatusReporter, restarter Restarter) (Deployer, error) {
+	if im == nil {
+		return nil, errors.New("deploy: image manager cannot be nil")
+	}
+	if host == "" {
+		return nil, errors.New("deploy: host id cannot be empty")
+	}
+	if err := iv.Validate(); err != nil {
+		return nil, errors.Wrap(err, "deploy: image verify")
+	}
 
-// Log deploy Log entity
-type Log struct {
-	Time    time.Time
-	Version types.Version
+	return &manager{
+		stage:        stage,
+		host:         host,
+		hostname:     hostname,
+		imageManager: im,
+		verify:       iv,
+		reporter:     reporter,
+		restarter:    restarter,
+	}, nil
 }
 
-func (m *manager) History() []Log {
-
-	return nil
-}
+// Deploy  deploy dc on this host, each phase it goes through is reported,
+// and it ends in PhaseDone or PhaseFailed
+func (m *manager) Deploy(dc *types.DeployConfig) error {
+	if dc == nil {
+		return nil
+	}
 
-// Backup backup current  deployment
-func (m *manager) Backup() (types.UUID, error) {
+	now := time.Now()
+	st := &types.Deployment{
+		Type:       dc.Type,
+		Name:       dc.Name,
+		Stage:      m.stage,
+		Host:       m.host,
+		HostName:   m.hostname,
+		DeployTime: now,
+	}
+	if dc.Image != nil && dc.Image.Version != nil {
+		st.Version = *dc.Image.Version
+	}
+	m.report(st, types.PhaseWaiting, nil)
 
-	return types.UUID(""), nil
-}
+	l := m.keyLock(dc.Key())
+	l.Lock()
+	defer l.Unlock()
 
-func (m *manager) Restore(ctx context.Context, key types.UUID) error {
+	if err := m.deploy(dc, st); err != nil {
+		m.report(st, types.PhaseFailed, err)
+		return err
+	}
 
+	m.report(st, types.PhaseDone, nil)
 	return nil
 }
 
-func (m *manager) Deploy(dc *types.DeployConfig) error {
-	if dc == nil {
-		return nil
+// keyLock  lock held while deployment key is deployed or restored, so a worktree is not reset by both
+func (m *manager) keyLock(key types.DeployKey) *sync.Mutex {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.keyLocks == nil {
+		m.keyLocks = map[types.DeployKey]*sync.Mutex{}
+	}
+	l, ok := m.keyLocks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		m.keyLocks[key] = l
 	}
+	return l
+}
 
+func (m *manager) deploy(dc *types.DeployConfig, st *types.Deployment) error {
 	// update local image
+	m.report(st, types.PhasePullImage, nil)
 	if err := m.pullImage(dc.Image); err != nil {
 		return err
 	}
 
+	m.report(st, types.PhasePrepare, nil)
+	ver, err := getVersion(dc, m.imageManager, m.stage)
+	if err != nil {
+		return err
+	}
+	st.Version = *dc.Image.Version
+
+	// refuse images not trusted, before any worktree is touched
+	if err := m.imageManager.Verify(dc.Image.Name, ver, m.verify); err != nil {
+		return &terror{code: imageNotTrusted, msg: fmt.Sprintf("%v %v: %v", dc.Image.Name, ver, err)}
+	}
+
 	// check if local worktree is clean
-	wt, err := m.getWorktree(dc)
+	t, err := m.getTarget(dc)
 	if err != nil {
 		return err
 	}
+	wt := t.wt
 
 	s, err := wt.Status()
 	if err != nil {
@@ -82,18 +151,64 @@ func (m *manager) Deploy(dc *types.DeployConfig) error {
 		return err
 	}
 
+	// backup  current deployment
+	if _, err := m.Backup(dc); err != nil {
+		return errors.Wrap(err, "backup")
+	}
+
 	// put config and  image file to the desire place
-	if err := wt.Reset(dc.Image.Version.String(), git.HardReset); err != nil {
+	m.report(st, types.PhaseApply, nil)
+	if err := wt.Reset(ver, git.HardReset); err != nil {
 		return err
 	}
+	if err := t.activate(); err != nil {
+		return err
+	}
+	if err := m.deployed(dc, *dc.Image.Version); err != nil {
+		glog.Warningf("deploy: %v record deployed version: %v", dc.Key(), err)
+	}
+	if dc.DeployPolicy == types.Versioned {
+		m.pruneVersions(dc, t)
+	}
 
 	// restart/clean cache if needed
+	m.report(st, types.PhaseRestarting, nil)
+	if m.restarter == nil {
+		return nil
+	}
 
-	// backup  current deployment
-
+	ctx, cf := context.WithTimeout(context.Background(), restartTimeout)
+	defer cf()
+	if err := m.restarter.Restart(ctx, dc); err != nil {
+		return errors.Wrap(err, "restart")
+	}
+	st.RestartCount++
 	return nil
 }
 
+// report  move st to phase, reporting is best effort, errors are only logged
+func (m *manager) report(st *types.Deployment, phase types.Phase, cause error) {
+	st.Status.DeployPhase = phase
+	st.Status.Message = ""
+	if cause != nil {
+		st.Status.Message = cause.Error()
+	}
+	st.UpdateTime = time.Now()
+
+	if cause != nil {
+		glog.Errorf("deploy: %v version %v %v: %v", st.Key(), st.Version, phase, cause)
+	} else {
+		glog.V(4).Infof("deploy: %v version %v %v", st.Key(), st.Version, phase)
+	}
+
+	if m.reporter == nil {
+		return
+	}
+	if err := m.reporter.UpdateDeployment(st); err != nil {
+		glog.Warningf("deploy: report %v %v: %v", st.Key(), phase, err)
+	}
+}
+
 // pullImage update local image repo if need,
 // and checks if the expected version exists
 func (m *manager) pullImage(image *types.Image) error {
@@ -183,6 +298,9 @@ func getVersion(dc *types.DeployConfig, manager image.Manager, stage types.Stage
 	if err != nil {
 		return "", err
 	}
+	if v == nil {
+		return "", &terror{code: imageVersionNotExist, msg: fmt.Sprintf("no version of %v for %v", dc.Image.Name, stage)}
+	}
 
 	// update dc.Image.Version
 	dc.Image.Version = v
@@ -190,79 +308,6 @@ func getVersion(dc *types.DeployConfig, manager image.Manager, stage types.Stage
 	return v.String(), nil
 }
 
-// getWorktree returns a worktree to prepare for the deploy:
-// the caller should make sure that the needed version of image exists
-// if there is not worktree, create a new one
-// this also respect the deploy policy
-// the directory structure for three different deploy policy are same:
-// Inplace:
-//  deployDir/deployName
-// ABWorld:
-// 	deployDir/{deployName-A, deployName-B, deployName}, deployName is a symbolic link to A or B
-// Versioned:
-// 	deployDir/{deployName-Version, deployName}, deployName is a symbolic link to current workdir
-func (m *manager) getWorktree(dc *types.DeployConfig) (image.Worktree, error) {
-	dd := dc.GetDeployDir()
-	var name = string(dc.Name)
-
-	ensureWt := func(ctx context.Context, name string, path string) (image.Worktree, error) {
-		wa, err := m.imageManager.Worktree(dc.Image.Name, name)
-		if os.IsNotExist(err) {
-			return m.imageManager.NewWorktree(context.Background(), dc.Image.Name, name, path)
-		}
-		return wa, err
-	}
-
-	switch dc.DeployPolicy {
-	case types.Inplace:
-		p := filepath.Join(dd, name)
-		return ensureWt(context.Background(), name, p)
-
-	case types.ABWorld:
-		// test current is a or b
-		a := fmt.Sprintf("%v-A", name)
-		b := fmt.Sprintf("%v-B", name)
-
-		p := filepath.Join(dd, a)
-		wa, err := ensureWt(context.Background(), a, p)
-		if err != nil {
-			return nil, err
-		}
-
-		p = filepath.Join(dd, b)
-		wb, err := ensureWt(context.Background(), b, p)
-		if err != nil {
-			return nil, err
-		}
-
-		s := filepath.Join(dd, name)
-		r, err := filepath.EvalSymlinks(s)
-		if err != nil {
-			return nil, err
-		}
-
-		bs := filepath.Base(r)
-		if bs == a {
-			return wb, nil
-		}
-
-		return wa, nil
-
-	case types.Versioned:
-		ver, err := getVersion(dc, m.imageManager, m.stage)
-		if err != nil {
-			return nil, err
-		}
-		a := fmt.Sprintf("%v-%v", name, ver)
-
-		p := filepath.Join(dd, a)
-		return ensureWt(context.Background(), a, p)
-
-	default:
-		return nil, errors.New("unknown Deploy Policy")
-	}
-}
-
 func (m *manager) checkWorktree(dc *types.DeployConfig) error {
 
 	return nil
@@ -5,6 +5,8 @@ Sniperkit-Bot
 
 package deploy
 
+import "github.com/pkg/errors"
+
 type errorCode int
 
 const (
@@ -13,6 +15,9 @@ const (
 	imageVersionNotExist
 	imageCanntEmpty
 	worktreeNotClean
+	backupNotExist
+	imageNotTrusted
+	policyChanged
 )
 
 var (
@@ -22,6 +27,9 @@ var (
 		imageNotExist:        "image not found",
 		imageVersionNotExist: "image verion does not exist",
 		worktreeNotClean:     "work tree not clean",
+		backupNotExist:       "backup not found",
+		imageNotTrusted:      "image not trusted",
+		policyChanged:        "deploy policy changed",
 	}
 )
 
@@ -44,3 +52,9 @@ func (e *terror) Error() string {
 	}
 	return s
 }
+
+// IsImageNotTrusted  returns true if err is caused by an image failed to verify
+func IsImageNotTrusted(err error) bool {
+	e, ok := errors.Cause(err).(*terror)
+	return ok && e.code == imageNotTrusted
+}
@@ -0,0 +1,233 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"we.com/dolphin/deploy/image"
+	"we.com/dolphin/types"
+)
+
+// defaultKeepVersions  versions kept on a host for Versioned deploy policy
+const defaultKeepVersions = 3
+
+// target  where a deploy is applied to
+type target struct {
+	wt image.Worktree
+	// path  dir of the worktree
+	path string
+	// link  symbolic link pointed to path after the worktree is reset, empty for Inplace
+	link string
+}
+
+// activate  make the target current, by switching link to it
+func (t *target) activate() error {
+	if t.link == "" {
+		return nil
+	}
+	if err := switchLink(t.link, t.path); err != nil {
+		return err
+	}
+
+	// mtime of a worktree records when it is activated, see pruneVersions
+	now := time.Now()
+	return errors.Wrap(os.Chtimes(t.path, now, now), "deploy: touch worktree")
+}
+
+// switchLink  point link to path atomically, by renaming a new symbolic link over it,
+// link is relative, so the deploy dir can be moved
+func switchLink(link, path string) error {
+	tmp := link + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "deploy: remove temporary link")
+	}
+
+	if err := os.Symlink(filepath.Base(path), tmp); err != nil {
+		return errors.Wrap(err, "deploy: create link")
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "deploy: switch %v to %v", link, path)
+	}
+	return nil
+}
+
+// currentLink  base name of the dir link points to, empty if link does not exist
+func currentLink(link string) (string, error) {
+	fi, err := os.Lstat(link)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", errors.Errorf("deploy: %v is not a symbolic link", link)
+	}
+
+	r, err := os.Readlink(link)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(r), nil
+}
+
+// checkLink  link of ABWorld or Versioned should be a symbolic link, or not exist yet.
+// anything else is most likely deployed Inplace, and deploy policy cannot be changed after deployed
+func checkLink(link string) error {
+	fi, err := os.Lstat(link)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "deploy: stat link")
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return &terror{
+		code: policyChanged,
+		msg:  fmt.Sprintf("%v is not a symbolic link, it may be deployed inplace, remove it or use another deploy dir", link),
+	}
+}
+
+// getTarget returns a worktree to prepare for the deploy:
+// the caller should make sure that the needed version of image exists
+// if there is not worktree, create a new one
+// this also respect the deploy policy, the directory structures are:
+//   - Inplace: deployDir/deployName
+//   - ABWorld: deployDir/{deployName-A, deployName-B, deployName}, deployName is a symbolic link to A or B,
+//     the one deployName does not point to is deployed
+//   - Versioned: deployDir/{deployName-Version, deployName}, deployName is a symbolic link to current workdir
+func (m *manager) getTarget(dc *types.DeployConfig) (*target, error) {
+	dd := dc.GetDeployDir()
+	var name = string(dc.Name)
+	link := filepath.Join(dd, name)
+
+	ensureWt := func(ctx context.Context, name string, path string, link string) (*target, error) {
+		wt, err := m.imageManager.Worktree(dc.Image.Name, name)
+		if os.IsNotExist(err) {
+			wt, err = m.imageManager.NewWorktree(ctx, dc.Image.Name, name, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &target{wt: wt, path: path, link: link}, nil
+	}
+
+	switch dc.DeployPolicy {
+	case types.Inplace, "":
+		return ensureWt(context.Background(), name, link, "")
+
+	case types.ABWorld:
+		if err := checkLink(link); err != nil {
+			return nil, err
+		}
+		// test current is a or b
+		a := fmt.Sprintf("%v-A", name)
+		b := fmt.Sprintf("%v-B", name)
+
+		cur, err := currentLink(link)
+		if err != nil {
+			return nil, err
+		}
+
+		idle := a
+		if cur == a {
+			idle = b
+		}
+		return ensureWt(context.Background(), idle, filepath.Join(dd, idle), link)
+
+	case types.Versioned:
+		// refuse before any worktree is created
+		if err := checkLink(link); err != nil {
+			return nil, err
+		}
+		ver, err := getVersion(dc, m.imageManager, m.stage)
+		if err != nil {
+			return nil, err
+		}
+		a := fmt.Sprintf("%v-%v", name, ver)
+
+		return ensureWt(context.Background(), a, filepath.Join(dd, a), link)
+
+	default:
+		return nil, errors.New("unknown Deploy Policy")
+	}
+}
+
+// pruneVersions  remove worktrees of versions activated earliest, keeping KeepVersions of dc,
+// the current one and those backups are taken from are never removed, so backups can be restored.
+// errors are only logged, as the deploy has been done
+func (m *manager) pruneVersions(dc *types.DeployConfig, cur *target) {
+	keep := dc.KeepVersions
+	if keep <= 0 {
+		keep = defaultKeepVersions
+	}
+
+	backedUp, err := m.backedUpWorktrees(dc.Key())
+	if err != nil {
+		glog.Warningf("deploy: %v load backups: %v", dc.Key(), err)
+		return
+	}
+	wts, err := m.imageManager.Worktrees(dc.Image.Name)
+	if err != nil {
+		glog.Warningf("deploy: %v list worktrees: %v", dc.Key(), err)
+		return
+	}
+
+	for _, wt := range versionsToPrune(dc.GetDeployDir(), string(dc.Name), wts, filepath.Base(cur.path), keep, backedUp) {
+		if err := m.imageManager.RemoveWorktree(context.Background(), dc.Image.Name, wt); err != nil {
+			glog.Warningf("deploy: %v remove worktree %v: %v", dc.Key(), wt, err)
+			continue
+		}
+		glog.Infof("deploy: %v removed worktree %v", dc.Key(), wt)
+	}
+}
+
+// versionsToPrune  worktrees of versions of name in dir, except the latest keep ones by mtime, cur
+// and backed up ones, which are not counted in keep
+func versionsToPrune(dir, name string, wts []string, cur string, keep int, backedUp map[string]struct{}) []string {
+	prefix := name + "-"
+	mtime := map[string]time.Time{}
+	vers := []string{}
+	for _, wt := range wts {
+		if !strings.HasPrefix(wt, prefix) || wt == cur {
+			continue
+		}
+		if _, ok := backedUp[wt]; ok {
+			continue
+		}
+		// worktrees of other deployments may share the prefix, eg: crm-admin-v1.0.0 and crm
+		if _, err := types.ParseVersion(strings.TrimPrefix(wt, prefix)); err != nil {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(dir, wt))
+		if err != nil {
+			continue
+		}
+		mtime[wt] = fi.ModTime()
+		vers = append(vers, wt)
+	}
+
+	// cur is always kept
+	keep--
+	if len(vers) <= keep {
+		return nil
+	}
+
+	sort.Slice(vers, func(i, j int) bool { return mtime[vers[i]].After(mtime[vers[j]]) })
+	return vers[keep:]
+}
@@ -0,0 +1,131 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package deploy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_switchLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	link := filepath.Join(dir, "crm")
+	for _, v := range []string{"crm-A", "crm-B"} {
+		if err := os.Mkdir(filepath.Join(dir, v), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if cur, err := currentLink(link); err != nil || cur != "" {
+		t.Fatalf("currentLink() = %q, %v, want empty", cur, err)
+	}
+
+	for _, v := range []string{"crm-A", "crm-B", "crm-A"} {
+		if err := switchLink(link, filepath.Join(dir, v)); err != nil {
+			t.Fatalf("switchLink(%v) error = %v", v, err)
+		}
+		if cur, err := currentLink(link); err != nil || cur != v {
+			t.Errorf("currentLink() = %q, %v, want %v", cur, err, v)
+		}
+	}
+
+	if _, err := currentLink(filepath.Join(dir, "crm-A")); err == nil {
+		t.Errorf("currentLink() of a dir should fail")
+	}
+}
+
+func Test_checkLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "crm-A"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("crm-A", filepath.Join(dir, "crm")); err != nil {
+		t.Fatal(err)
+	}
+	// deployed inplace before
+	if err := os.Mkdir(filepath.Join(dir, "admin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		link    string
+		wantErr bool
+	}{
+		{"not deployed", "web", false},
+		{"link", "crm", false},
+		{"inplace dir", "admin", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLink(filepath.Join(dir, tt.link))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkLink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if e, ok := err.(*terror); tt.wantErr && (!ok || e.code != policyChanged) {
+				t.Errorf("checkLink() error = %v, want policy changed", err)
+			}
+		})
+	}
+}
+
+func Test_versionsToPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// activated in this order
+	wts := []string{"crm-v1.0.0", "crm-v1.1.0", "crm-v1.2.0", "crm-v1.3.0", "crm-admin-v1.0.0", "crm-A"}
+	start := time.Now().Add(-time.Hour)
+	for i, v := range wts {
+		p := filepath.Join(dir, v)
+		if err := os.Mkdir(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+		mt := start.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(p, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		cur      string
+		keep     int
+		backedUp map[string]struct{}
+		want     []string
+	}{
+		{"keep 3", "crm-v1.3.0", 3, nil, []string{"crm-v1.0.0"}},
+		{"keep all", "crm-v1.3.0", 4, nil, nil},
+		{"keep current only", "crm-v1.3.0", 1, nil, []string{"crm-v1.2.0", "crm-v1.1.0", "crm-v1.0.0"}},
+		{"rolled back", "crm-v1.0.0", 2, nil, []string{"crm-v1.2.0", "crm-v1.1.0"}},
+		{"backed up", "crm-v1.3.0", 1, map[string]struct{}{"crm-v1.0.0": {}, "crm-v1.2.0": {}}, []string{"crm-v1.1.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := versionsToPrune(dir, "crm", wts, tt.cur, tt.keep, tt.backedUp)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("versionsToPrune() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
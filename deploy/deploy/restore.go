@@ -0,0 +1,126 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package deploy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"we.com/dolphin/types"
+)
+
+const (
+	// restoreRelist  interval of listing restore requests, in case any is missed by the watch
+	restoreRelist     = 5 * time.Minute
+	minRestoreBackoff = time.Second
+	maxRestoreBackoff = time.Minute
+	// restoreTimeout  max time of a restore
+	restoreTimeout = 10 * time.Minute
+)
+
+// restoreRunner  restore deployments of a host as requested
+type restoreRunner struct {
+	host types.HostID
+	b    Backuper
+	reqs RestoreRequests
+
+	// lock  serialize restores, requests are handled by both the watch and relists
+	lock sync.Mutex
+	// handled  request time of the last request handled of each deployment
+	handled map[types.DeployKey]time.Time
+}
+
+// RunRestores  restore deployments of host as requests in reqs ask, until ctx is done.
+// pending requests are handled first, then new ones as they are watched.
+// a request is deleted once it is handled, whether the restore succeeds or not,
+// the result is reported by b as the deploy status of the deployment
+func RunRestores(ctx context.Context, host types.HostID, b Backuper, reqs RestoreRequests) {
+	r := &restoreRunner{
+		host:    host,
+		b:       b,
+		reqs:    reqs,
+		handled: map[types.DeployKey]time.Time{},
+	}
+
+	ticker := time.NewTicker(restoreRelist)
+	defer ticker.Stop()
+	backoff := minRestoreBackoff
+	for {
+		wctx, cancel := context.WithCancel(ctx)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- reqs.WatchRestores(wctx, host, func(key types.DeployKey, req *types.DeployRestore) error {
+				r.handle(ctx, key, req)
+				return nil
+			})
+		}()
+		r.pending(ctx)
+
+		var err error
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return
+			case <-ticker.C:
+				r.pending(ctx)
+			case err = <-errc:
+				break wait
+			}
+		}
+		cancel()
+
+		glog.Warningf("deploy: watch restore requests of %v: %v, rewatch in %v", host, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRestoreBackoff {
+			backoff = maxRestoreBackoff
+		}
+	}
+}
+
+// pending  handle requests not handled yet
+func (r *restoreRunner) pending(ctx context.Context) {
+	rs, err := r.reqs.ListRestores(r.host)
+	if err != nil {
+		glog.Errorf("deploy: list restore requests of %v: %v", r.host, err)
+		return
+	}
+	for key, req := range rs {
+		r.handle(ctx, key, req)
+	}
+}
+
+// handle  restore key as req asks, and delete req
+func (r *restoreRunner) handle(ctx context.Context, key types.DeployKey, req *types.DeployRestore) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if t, ok := r.handled[key]; ok && t.Equal(req.RequestTime) {
+		return
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, restoreTimeout)
+	err := r.b.Restore(rctx, req.Key)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		glog.Errorf("deploy: restore %v to backup %v: %v", key, req.Key, err)
+	}
+
+	r.handled[key] = req.RequestTime
+	if err := r.reqs.DeleteRestore(key, r.host, req); err != nil {
+		glog.Warningf("deploy: delete restore request of %v: %v", key, err)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package deploy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"we.com/dolphin/types"
+)
+
+type fakeBackuper struct {
+	Backuper
+	restored []types.UUID
+}
+
+func (f *fakeBackuper) Restore(ctx context.Context, key types.UUID) error {
+	f.restored = append(f.restored, key)
+	return nil
+}
+
+type fakeRestoreRequests struct {
+	RestoreRequests
+	reqs map[types.DeployKey]*types.DeployRestore
+}
+
+func (f *fakeRestoreRequests) ListRestores(host types.HostID) (map[types.DeployKey]*types.DeployRestore, error) {
+	ret := map[types.DeployKey]*types.DeployRestore{}
+	for k, v := range f.reqs {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+func (f *fakeRestoreRequests) DeleteRestore(key types.DeployKey, host types.HostID, req *types.DeployRestore) error {
+	if cur, ok := f.reqs[key]; ok && cur.RequestTime.Equal(req.RequestTime) {
+		delete(f.reqs, key)
+	}
+	return nil
+}
+
+func Test_restoreRunner_handle(t *testing.T) {
+	now := time.Now()
+	key := types.DeployKey("java/crm")
+	req := &types.DeployRestore{Key: "b1", RequestTime: now}
+
+	b := &fakeBackuper{}
+	reqs := &fakeRestoreRequests{reqs: map[types.DeployKey]*types.DeployRestore{key: req}}
+	r := &restoreRunner{host: "h1", b: b, reqs: reqs, handled: map[types.DeployKey]time.Time{}}
+
+	ctx := context.Background()
+	r.pending(ctx)
+	if len(reqs.reqs) != 0 {
+		t.Errorf("pending() should delete handled requests, left %v", reqs.reqs)
+	}
+
+	// the watch may deliver a request handled by a relist
+	r.handle(ctx, key, req)
+	r.handle(ctx, key, &types.DeployRestore{Key: "b2", RequestTime: now.Add(time.Second)})
+	if want := []types.UUID{"b1", "b2"}; !reflect.DeepEqual(b.restored, want) {
+		t.Errorf("restored %v, want %v", b.restored, want)
+	}
+}
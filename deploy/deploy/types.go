@@ -14,8 +14,12 @@ import (
 // Backuper backup curret deploy or restore a privous deploy
 // on the same host
 type Backuper interface {
-	Backup() (key types.UUID, err error)
+	// Backup  take a snapshot of the current deployment of dc
+	Backup(dc *types.DeployConfig) (key types.UUID, err error)
+	// Restore  reset the deployment backup key is taken from to it
 	Restore(ctx context.Context, key types.UUID) error
+	// History  backups of deployment key on this host, newest first
+	History(key types.DeployKey) ([]types.DeployBackup, error)
 }
 
 type Configer interface {
@@ -27,3 +31,28 @@ type Deployer interface {
 	Backuper
 	Deploy(dc *types.DeployConfig) error
 }
+
+// StatusReporter  receives status of a deploy on this host, every time it moves to another phase
+type StatusReporter interface {
+	UpdateDeployment(d *types.Deployment) error
+}
+
+// BackupReporter  receives backups of a deployment kept on this host, every time they change,
+// a StatusReporter implementing it is used
+type BackupReporter interface {
+	UpdateBackups(key types.DeployKey, host types.HostID, bs []types.DeployBackup) error
+}
+
+// RestoreRequests  restore requests of deployments on a host, they are deleted once handled
+type RestoreRequests interface {
+	ListRestores(host types.HostID) (map[types.DeployKey]*types.DeployRestore, error)
+	// WatchRestores  call h with restore requests of host, until ctx is done or h returns an error
+	WatchRestores(ctx context.Context, host types.HostID, h func(key types.DeployKey, req *types.DeployRestore) error) error
+	// DeleteRestore  delete the request of key after req is handled, unless it is replaced by a newer one
+	DeleteRestore(key types.DeployKey, host types.HostID, req *types.DeployRestore) error
+}
+
+// Restarter  restart running instances of a deployment, after its files are updated
+type Restarter interface {
+	Restart(ctx context.Context, dc *types.DeployConfig) error
+}
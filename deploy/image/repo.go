@@ -8,6 +8,7 @@ package image
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
@@ -18,7 +19,6 @@ import (
 	"github.com/pkg/errors"
 	git "gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/config"
-	"gopkg.in/src-d/go-git.v4/plumbing"
 	wt "gopkg.in/src-d/go-git.v4/plumbing/format/worktree"
 	"we.com/dolphin/deploy/sync"
 	"we.com/dolphin/types"
@@ -72,15 +72,21 @@ var (
 
 // gitStore implements  LocalStorer
 type gitStore struct {
-	LocalRepoBase      string
-	MaxHistory         int
+	LocalRepoBase string
+	// MaxHistory  depth image repos are fetched with, and history is truncated to after each update
+	MaxHistory int
+	// MaxVersions  versions kept in an image repo, deployed ones are always kept
+	MaxVersions        int
 	AutoUpdateInterval time.Duration
 	DefaultTimeout     time.Duration
 }
 
 // NewManager returns a localstore implemented with git
 func NewManager() (Manager, error) {
-	gs := &gitStore{}
+	gs := &gitStore{
+		MaxHistory:  defaultMaxHistory,
+		MaxVersions: defaultMaxVersions,
+	}
 
 	return gs, nil
 }
@@ -118,48 +124,42 @@ func (bv byVersion) Len() int {
 
 // Info returns a list of versions, the given image has
 // versions are tags  while meet the semver spec
-// versions are ordered by  version num descending
+// versions are ordered by  version num descending,
+// DeployCount is num of worktrees the version is checked out in
 func (gs *gitStore) Info(name types.ImageName) ([]Info, error) {
 	if err := name.Validate(); err != nil {
 		return nil, err
 	}
 
-	path := filepath.Join(localBase, chartsBase, fmt.Sprintf("%v.git", name))
+	path := getImagePath(name)
 	r, err := git.PlainOpen(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "image: open local git dir")
 	}
 
-	rfs, err := r.Tags()
+	vrs, err := versionRefs(r)
 	if err != nil {
-		return nil, errors.Wrap(err, "image: get tags of local repo")
+		return nil, err
+	}
+	deployed, err := deployedCommits(r, path)
+	if err != nil {
+		glog.Warningf("image: get deployed versions of %v: %v", name, err)
 	}
 
-	ret := []Info{}
-	rfs.ForEach(func(v *plumbing.Reference) error {
-		n := v.Name().String()
-		n = strings.TrimLeft(n, "refs/tags/")
-		e := Info{
-			Name: name.String(),
-
-			CommitID: v.Hash().String(),
-		}
-		// only tags with semver v2 format
-		ver, err := types.ParseVersion(n)
-		if err != nil {
-			return nil
-		}
-		if co, err := r.CommitObject(v.Hash()); err == nil {
-			e.CreateDate = co.Committer.When
-			e.Author = co.Author.Name
-			e.AuthorDate = co.Author.When
-			e.Msg = co.Message
-			e.Version = *ver
-		} else {
-			glog.Warningf("git: get commit object of hash: %v, %v", name, e.CommitID)
-		}
-		return nil
-	})
+	ret := make([]Info, 0, len(vrs))
+	for _, v := range vrs {
+		co := v.commit
+		ret = append(ret, Info{
+			Name:        name.String(),
+			Version:     v.version,
+			CommitID:    co.Hash.String(),
+			CreateDate:  co.Committer.When,
+			Author:      co.Author.Name,
+			AuthorDate:  co.Author.When,
+			Msg:         co.Message,
+			DeployCount: deployed[co.Hash],
+		})
+	}
 
 	sort.Sort(byVersion(ret))
 
@@ -181,10 +181,17 @@ func (gs *gitStore) Update(ctx context.Context, name types.ImageName) error {
 	// update images
 	ipath := getImagePath(name)
 	iurl := getImageRepo(name)
+	executPool.CheckIn(ipath)
+	defer executPool.CheckOut(ipath)
 	if err := gs.updateFromRemote(ctx, iurl, ipath); err != nil {
 		return err
 	}
 
+	// keep disk usage bounded, the update itself has succeeded
+	if err := pruneHistory(ipath, gs.MaxHistory, gs.MaxVersions); err != nil {
+		glog.Warningf("image: prune history of %v: %v", name, err)
+	}
+
 	// TODO: maybe shoud check consistancy of image and charts
 	return nil
 }
@@ -243,6 +250,26 @@ func (gs *gitStore) NewWorktree(ctx context.Context, name types.ImageName, wtnam
 	return gs.Worktree(name, wtname)
 }
 
+// RemoveWorktree  remove files of the worktree and its admin dir, like git worktree remove --force
+func (gs *gitStore) RemoveWorktree(ctx context.Context, name types.ImageName, wtname string) error {
+	if wtname == "" || wtname == "." || wtname == ".." || filepath.Base(wtname) != wtname {
+		return errors.Errorf("image: invalid worktree name %q", wtname)
+	}
+
+	admin := filepath.Join(getImagePath(name), "worktrees", wtname)
+	// gitdir  records path of .git file in the worktree
+	dat, err := ioutil.ReadFile(filepath.Join(admin, "gitdir"))
+	if err != nil {
+		return errors.Wrapf(err, "image: read gitdir of worktree %v", wtname)
+	}
+
+	path := filepath.Dir(strings.TrimSpace(string(dat)))
+	if err := os.RemoveAll(path); err != nil {
+		return errors.Wrapf(err, "image: remove worktree %v", path)
+	}
+	return errors.Wrap(os.RemoveAll(admin), "image: remove worktree admin dir")
+}
+
 func (gs *gitStore) Delete(ctx context.Context, name types.ImageName) error {
 	return nil
 }
@@ -290,8 +317,16 @@ func (gs *gitStore) updateFromRemote(ctx context.Context, url, path string) erro
 	if err != nil {
 		return errors.Wrap(err, "image: open git dir")
 	}
+	specs, ok, err := fetchSpecs(r, path, "origin")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
 	err = r.FetchContext(ctx, &git.FetchOptions{
 		RemoteName: "origin",
+		RefSpecs:   specs,
 		Depth:      gs.MaxHistory,
 	})
 	if err != nil {
@@ -0,0 +1,468 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"we.com/dolphin/types"
+)
+
+const (
+	// defaultMaxHistory  commits fetched and kept behind each version
+	defaultMaxHistory = 3
+	// defaultMaxVersions  versions kept in a local image repo, deployed ones are kept too
+	defaultMaxVersions = 10
+)
+
+// prunedFile  file in a git dir, which records tags dropped by pruneHistory, one a line,
+// they are not fetched again, see fetchSpecs
+const prunedFile = "pruned-tags"
+
+func loadPruned(path string) (map[plumbing.ReferenceName]struct{}, error) {
+	ret := map[plumbing.ReferenceName]struct{}{}
+	dat, err := ioutil.ReadFile(filepath.Join(path, prunedFile))
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "image: read pruned tags")
+	}
+	for _, v := range strings.Split(string(dat), "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret[plumbing.ReferenceName(v)] = struct{}{}
+		}
+	}
+	return ret, nil
+}
+
+// addPruned  record names as pruned tags of the git dir at path
+func addPruned(path string, names []plumbing.ReferenceName) error {
+	if len(names) == 0 {
+		return nil
+	}
+	pruned, err := loadPruned(path)
+	if err != nil {
+		return err
+	}
+	for _, v := range names {
+		pruned[v] = struct{}{}
+	}
+
+	lines := make([]string, 0, len(pruned))
+	for v := range pruned {
+		lines = append(lines, v.String())
+	}
+	sort.Strings(lines)
+
+	tmp := filepath.Join(path, prunedFile+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return errors.Wrap(err, "image: write pruned tags")
+	}
+	return errors.Wrap(os.Rename(tmp, filepath.Join(path, prunedFile)), "image: write pruned tags")
+}
+
+// fetchSpecs  refspecs to fetch remote of the git dir at path with, nil to fetch as remote is configured.
+// if some tags are pruned, remote refs are listed, and each one matched by remote config is fetched
+// explicitly, except pruned ones, so they are not downloaded and pruned again on every update.
+// ok is false if there is nothing to fetch
+func fetchSpecs(r *git.Repository, path string, remote string) (specs []config.RefSpec, ok bool, err error) {
+	pruned, err := loadPruned(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(pruned) == 0 {
+		return nil, true, nil
+	}
+
+	rm, err := r.Remote(remote)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "image: get remote %v", remote)
+	}
+	refs, err := rm.List(&git.ListOptions{})
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "image: list refs of remote %v", remote)
+	}
+
+	specs = unprunedSpecs(refs, rm.Config().Fetch, pruned)
+	return specs, len(specs) > 0, nil
+}
+
+// unprunedSpecs  a refspec for each of refs matched by specs, except those pruned,
+// either by remote name or by the local name it is fetched to
+func unprunedSpecs(refs []*plumbing.Reference, specs []config.RefSpec, pruned map[plumbing.ReferenceName]struct{}) []config.RefSpec {
+	ret := []config.RefSpec{}
+	for _, ref := range refs {
+		n := ref.Name()
+		for _, s := range specs {
+			if !s.Match(n) {
+				continue
+			}
+			dst := s.Dst(n)
+			if _, ok := pruned[n]; ok {
+				break
+			}
+			if _, ok := pruned[dst]; ok {
+				break
+			}
+			force := ""
+			if s.IsForceUpdate() {
+				force = "+"
+			}
+			ret = append(ret, config.RefSpec(fmt.Sprintf("%v%v:%v", force, n, dst)))
+			break
+		}
+	}
+	return ret
+}
+
+// versionRef  a tag of a version
+type versionRef struct {
+	ref     *plumbing.Reference
+	version types.Version
+	commit  *object.Commit
+}
+
+// versionRefs  tags of r in semver v2 format, ordered by version descending
+func versionRefs(r *git.Repository) ([]versionRef, error) {
+	rfs, err := r.Tags()
+	if err != nil {
+		return nil, errors.Wrap(err, "image: get tags of local repo")
+	}
+
+	ret := []versionRef{}
+	err = rfs.ForEach(func(v *plumbing.Reference) error {
+		ver, err := types.ParseVersion(strings.TrimPrefix(v.Name().String(), "refs/tags/"))
+		if err != nil {
+			return nil
+		}
+		co, err := refCommit(r, v.Hash())
+		if err != nil {
+			return errors.Wrapf(err, "image: get commit of %v", v.Name())
+		}
+		ret = append(ret, versionRef{ref: v, version: *ver, commit: co})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[j].version.LT(ret[i].version) })
+	return ret, nil
+}
+
+// refCommit  commit h points to, h is a commit or an annotated tag
+func refCommit(r *git.Repository, h plumbing.Hash) (*object.Commit, error) {
+	if co, err := r.CommitObject(h); err == nil {
+		return co, nil
+	}
+	tag, err := r.TagObject(h)
+	if err != nil {
+		return nil, err
+	}
+	return tag.Commit()
+}
+
+// deployedCommits  num of worktrees of the repo at path checked out at each commit
+func deployedCommits(r *git.Repository, path string) (map[plumbing.Hash]int, error) {
+	heads, err := filepath.Glob(filepath.Join(path, "worktrees", "*", "HEAD"))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[plumbing.Hash]int{}
+	for _, v := range heads {
+		dat, err := ioutil.ReadFile(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "image: read worktree HEAD")
+		}
+
+		s := strings.TrimSpace(string(dat))
+		if strings.HasPrefix(s, "ref: ") {
+			ref, err := r.Reference(plumbing.ReferenceName(strings.TrimPrefix(s, "ref: ")), true)
+			if err != nil {
+				continue
+			}
+			ret[ref.Hash()]++
+			continue
+		}
+		ret[plumbing.NewHash(s)]++
+	}
+	return ret, nil
+}
+
+// versionsToDrop  versions beyond the newest max ones, which are not deployed
+func versionsToDrop(vrs []versionRef, deployed map[plumbing.Hash]int, max int) []versionRef {
+	ret := []versionRef{}
+	for i, v := range vrs {
+		if i < max || deployed[v.commit.Hash] > 0 {
+			continue
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+// historyWalker  collects objects reachable within depth commits from the refs it walks
+type historyWalker struct {
+	r     *git.Repository
+	depth int
+	// backups  backups kept on each backup branch
+	backups int
+	// commits  least depth each commit is reached at
+	commits map[plumbing.Hash]int
+	objects map[plumbing.Hash]struct{}
+	// shallow  commits whose parents are dropped
+	shallow map[plumbing.Hash]struct{}
+}
+
+// walkBackups  walk the newest backups of a backup branch, whose first parent is the previous backup
+func (w *historyWalker) walkBackups(h plumbing.Hash) error {
+	for i := 0; i < w.backups; i++ {
+		co, err := w.r.CommitObject(h)
+		if err != nil {
+			return err
+		}
+		if err := w.walkCommit(h, 1); err != nil {
+			return err
+		}
+		if co.NumParents() == 0 {
+			return nil
+		}
+		h = co.ParentHashes[0]
+	}
+	return nil
+}
+
+func (w *historyWalker) walkRef(h plumbing.Hash) error {
+	if tag, err := w.r.TagObject(h); err == nil {
+		w.objects[h] = struct{}{}
+		h = tag.Target
+	}
+	return w.walkCommit(h, 1)
+}
+
+func (w *historyWalker) walkCommit(h plumbing.Hash, depth int) error {
+	if d, ok := w.commits[h]; ok && d <= depth {
+		return nil
+	}
+	co, err := w.r.CommitObject(h)
+	if err != nil {
+		return err
+	}
+	w.commits[h] = depth
+	w.objects[h] = struct{}{}
+	delete(w.shallow, h)
+
+	tree, err := co.Tree()
+	if err != nil {
+		return err
+	}
+	if err := w.walkTree(tree); err != nil {
+		return err
+	}
+
+	if depth >= w.depth && co.NumParents() > 0 {
+		w.shallow[h] = struct{}{}
+		return nil
+	}
+	for _, p := range co.ParentHashes {
+		err := w.walkCommit(p, depth+1)
+		// parents of an already shallow commit are not fetched
+		if err == plumbing.ErrObjectNotFound {
+			w.shallow[h] = struct{}{}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *historyWalker) walkTree(t *object.Tree) error {
+	if _, ok := w.objects[t.Hash]; ok {
+		return nil
+	}
+	w.objects[t.Hash] = struct{}{}
+
+	for _, e := range t.Entries {
+		switch e.Mode {
+		case filemode.Submodule:
+		case filemode.Dir:
+			st, err := w.r.TreeObject(e.Hash)
+			if err != nil {
+				return err
+			}
+			if err := w.walkTree(st); err != nil {
+				return err
+			}
+		default:
+			w.objects[e.Hash] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// pruneHistory  drop versions of the repo at path beyond the newest maxVersions, except deployed ones,
+// and commits deeper than depth from any reference or worktree, like it is fetched with depth.
+// dropped versions are recorded, and are not fetched again.
+// the newest maxVersions backups on each backup branch are kept too
+func pruneHistory(path string, depth, maxVersions int) error {
+	if depth <= 0 || maxVersions <= 0 {
+		return nil
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return errors.Wrap(err, "image: open git dir")
+	}
+
+	deployed, err := deployedCommits(r, path)
+	if err != nil {
+		return err
+	}
+
+	vrs, err := versionRefs(r)
+	if err != nil {
+		return err
+	}
+	dropped := []plumbing.ReferenceName{}
+	for _, v := range versionsToDrop(vrs, deployed, maxVersions) {
+		if err := r.Storer.RemoveReference(v.ref.Name()); err != nil {
+			return errors.Wrapf(err, "image: remove tag %v", v.ref.Name())
+		}
+		dropped = append(dropped, v.ref.Name())
+	}
+	// so later updates do not fetch them again
+	if err := addPruned(path, dropped); err != nil {
+		return err
+	}
+
+	w := &historyWalker{
+		r:       r,
+		depth:   depth,
+		backups: maxVersions,
+		commits: map[plumbing.Hash]int{},
+		objects: map[plumbing.Hash]struct{}{},
+		shallow: map[plumbing.Hash]struct{}{},
+	}
+	refs, err := r.References()
+	if err != nil {
+		return errors.Wrap(err, "image: list references")
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if strings.HasPrefix(ref.Name().String(), backupPrefix) {
+			return w.walkBackups(ref.Hash())
+		}
+		return w.walkRef(ref.Hash())
+	})
+	if err != nil {
+		return errors.Wrap(err, "image: walk references")
+	}
+	for h := range deployed {
+		if err := w.walkCommit(h, 1); err != nil {
+			return errors.Wrap(err, "image: walk worktree HEAD")
+		}
+	}
+
+	shallow := make([]plumbing.Hash, 0, len(w.shallow))
+	for h := range w.shallow {
+		shallow = append(shallow, h)
+	}
+	if err := r.Storer.SetShallow(shallow); err != nil {
+		return errors.Wrap(err, "image: set shallow commits")
+	}
+
+	return repack(r, w.objects)
+}
+
+// repack  rewrite objects of r into a single pack, with only objects in keep,
+// nothing is done if r has no other objects
+func repack(r *git.Repository, keep map[plumbing.Hash]struct{}) (err error) {
+	iter, err := r.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return err
+	}
+	total := 0
+	err = iter.ForEach(func(plumbing.EncodedObject) error {
+		total++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if total <= len(keep) {
+		return nil
+	}
+
+	pos, ok := r.Storer.(storer.PackedObjectStorer)
+	if !ok {
+		return git.ErrPackedObjectsNotSupported
+	}
+	pfw, ok := r.Storer.(storer.PackfileWriter)
+	if !ok {
+		return errors.New("image: storer is not a PackfileWriter")
+	}
+
+	olds, err := pos.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	objs := make([]plumbing.Hash, 0, len(keep))
+	for h := range keep {
+		objs = append(objs, h)
+	}
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return err
+	}
+
+	wc, err := pfw.PackfileWriter()
+	if err != nil {
+		return err
+	}
+	nh, err := packfile.NewEncoder(wc, r.Storer, false).Encode(objs, cfg.Pack.Window)
+	if cerr := wc.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "image: write pack")
+	}
+
+	for _, h := range olds {
+		if h == nh {
+			continue
+		}
+		if err := pos.DeleteOldObjectPackAndIndex(h, time.Time{}); err != nil {
+			return errors.Wrap(err, "image: delete old pack")
+		}
+	}
+
+	// all objects kept are packed now
+	if los, ok := r.Storer.(storer.LooseObjectStorer); ok {
+		return los.ForEachObjectHash(los.DeleteLooseObject)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"we.com/dolphin/types"
+)
+
+func Test_versionsToDrop(t *testing.T) {
+	vrs := []versionRef{}
+	for i, v := range []string{"v1.3.0", "v1.2.0", "v1.1.0", "v1.0.0"} {
+		h := plumbing.NewHash(string('a'+byte(i)) + "000000000000000000000000000000000000000")
+		vrs = append(vrs, versionRef{
+			ref:     plumbing.NewHashReference(plumbing.ReferenceName("refs/tags/"+v), h),
+			version: *types.MustParseVersion(v),
+			commit:  &object.Commit{Hash: h},
+		})
+	}
+	deployedV110 := map[plumbing.Hash]int{vrs[2].commit.Hash: 2}
+
+	names := func(vrs []versionRef) []string {
+		ret := []string{}
+		for _, v := range vrs {
+			ret = append(ret, v.ref.Name().String())
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name     string
+		deployed map[plumbing.Hash]int
+		max      int
+		want     []string
+	}{
+		{"keep all", nil, 4, []string{}},
+		{"keep newest 2", nil, 2, []string{"refs/tags/v1.1.0", "refs/tags/v1.0.0"}},
+		{"keep deployed", deployedV110, 1, []string{"refs/tags/v1.2.0", "refs/tags/v1.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := names(versionsToDrop(vrs, tt.deployed, tt.max))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("versionsToDrop() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_addPruned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := addPruned(dir, []plumbing.ReferenceName{"refs/tags/v1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addPruned(dir, []plumbing.ReferenceName{"refs/tags/v1.1.0", "refs/tags/v1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadPruned(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[plumbing.ReferenceName]struct{}{"refs/tags/v1.0.0": {}, "refs/tags/v1.1.0": {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadPruned() = %v, want %v", got, want)
+	}
+}
+
+func Test_unprunedSpecs(t *testing.T) {
+	h := plumbing.NewHash("a000000000000000000000000000000000000000")
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/tags/prod/v1.0.0", h),
+		plumbing.NewHashReference("refs/tags/prod/v1.1.0", h),
+		plumbing.NewHashReference("refs/tags/prod/v1.2.0", h),
+		plumbing.NewHashReference("refs/heads/master", h),
+	}
+	specs := []config.RefSpec{"+refs/tags/prod/*:refs/origin/tags/prod/*"}
+
+	tests := []struct {
+		name   string
+		pruned map[plumbing.ReferenceName]struct{}
+		want   []config.RefSpec
+	}{
+		{
+			name: "nothing pruned",
+			want: []config.RefSpec{
+				"+refs/tags/prod/v1.0.0:refs/origin/tags/prod/v1.0.0",
+				"+refs/tags/prod/v1.1.0:refs/origin/tags/prod/v1.1.0",
+				"+refs/tags/prod/v1.2.0:refs/origin/tags/prod/v1.2.0",
+			},
+		},
+		{
+			name:   "pruned by local name",
+			pruned: map[plumbing.ReferenceName]struct{}{"refs/origin/tags/prod/v1.0.0": {}},
+			want: []config.RefSpec{
+				"+refs/tags/prod/v1.1.0:refs/origin/tags/prod/v1.1.0",
+				"+refs/tags/prod/v1.2.0:refs/origin/tags/prod/v1.2.0",
+			},
+		},
+		{
+			name: "pruned by remote name",
+			pruned: map[plumbing.ReferenceName]struct{}{
+				"refs/tags/prod/v1.0.0": {},
+				"refs/tags/prod/v1.1.0": {},
+			},
+			want: []config.RefSpec{"+refs/tags/prod/v1.2.0:refs/origin/tags/prod/v1.2.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unprunedSpecs(refs, specs, tt.pruned); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unprunedSpecs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
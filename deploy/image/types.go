@@ -40,6 +40,10 @@ type Manager interface {
 	Worktrees(name types.ImageName) (wts []string, err error)
 	Worktree(name types.ImageName, wtname string) (wt Worktree, err error)
 	NewWorktree(ctx context.Context, name types.ImageName, wtname string, path string) (Worktree, error)
+	// RemoveWorktree remove worktree wtname of image name, and its files
+	RemoveWorktree(ctx context.Context, name types.ImageName, wtname string) error
+	// Verify check version ver of image name is trustworthy, as iv requires, nothing is checked if iv is nil
+	Verify(name types.ImageName, ver string, iv *types.ImageVerify) error
 }
 
 // Worktree likes a git worktree
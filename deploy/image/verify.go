@@ -0,0 +1,188 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"we.com/dolphin/types"
+)
+
+// Verify  check version ver of image name is trustworthy, as iv requires
+func (gs *gitStore) Verify(name types.ImageName, ver string, iv *types.ImageVerify) error {
+	if iv == nil || iv.Mode == types.VerifyNone {
+		return nil
+	}
+	if err := iv.Validate(); err != nil {
+		return err
+	}
+
+	r, err := git.PlainOpen(getImagePath(name))
+	if err != nil {
+		return errors.Wrap(err, "image: open git dir")
+	}
+
+	switch iv.Mode {
+	case types.VerifySignature:
+		return verifySignature(r, ver, iv.Keyring)
+	default:
+		manifest := iv.Manifest
+		if manifest == "" {
+			manifest = types.DefaultChecksumManifest
+		}
+		co, err := versionCommit(r, ver)
+		if err != nil {
+			return err
+		}
+		return verifyChecksum(co, manifest, iv.Keyring)
+	}
+}
+
+func versionCommit(r *git.Repository, ver string) (*object.Commit, error) {
+	h, err := r.ResolveRevision(plumbing.Revision(ver))
+	if err != nil {
+		return nil, errors.Errorf("image: unknown version %v", ver)
+	}
+	return r.CommitObject(*h)
+}
+
+// verifySignature  the annotated tag ver, or the commit it points to, is signed by a key in keyring
+func verifySignature(r *git.Repository, ver string, keyring string) error {
+	if ref, err := r.Reference(plumbing.NewTagReferenceName(ver), true); err == nil {
+		if tag, err := r.TagObject(ref.Hash()); err == nil && tag.PGPSignature != "" {
+			if _, err := tag.Verify(keyring); err != nil {
+				return errors.Wrapf(err, "image: verify signature of tag %v", ver)
+			}
+			return nil
+		}
+	}
+
+	co, err := versionCommit(r, ver)
+	if err != nil {
+		return err
+	}
+	if co.PGPSignature == "" {
+		return errors.Errorf("image: neither tag nor commit of %v is signed", ver)
+	}
+	if _, err := co.Verify(keyring); err != nil {
+		return errors.Wrapf(err, "image: verify signature of commit %v", co.Hash)
+	}
+	return nil
+}
+
+// verifyChecksum  every file in co, except the manifest and its signature, is listed in manifest with its sha256,
+// the manifest is in the same commit as the files, so it is trusted only if it is signed by a key in keyring
+func verifyChecksum(co *object.Commit, manifest string, keyring string) error {
+	tree, err := co.Tree()
+	if err != nil {
+		return err
+	}
+
+	dat, err := readTreeFile(tree, manifest)
+	if err != nil {
+		return errors.Wrapf(err, "image: checksum manifest %v", manifest)
+	}
+	sigFile := manifest + types.ChecksumSignatureSuffix
+	sig, err := readTreeFile(tree, sigFile)
+	if err != nil {
+		return errors.Wrapf(err, "image: signature of checksum manifest %v", manifest)
+	}
+	if err := checkManifestSignature(keyring, dat, sig); err != nil {
+		return errors.Wrapf(err, "image: verify signature of checksum manifest %v", manifest)
+	}
+
+	sums, err := parseChecksums(bytes.NewReader(dat))
+	if err != nil {
+		return errors.Wrapf(err, "image: parse checksum manifest %v", manifest)
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if f.Name == manifest || f.Name == sigFile {
+			return nil
+		}
+		want, ok := sums[f.Name]
+		if !ok {
+			return errors.Errorf("image: %v is not in checksum manifest", f.Name)
+		}
+		delete(sums, f.Name)
+
+		fr, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer fr.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, fr); err != nil {
+			return errors.Wrapf(err, "image: read %v", f.Name)
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			return errors.Errorf("image: checksum of %v mismatch, got %v, want %v", f.Name, got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name := range sums {
+		return errors.Errorf("image: %v in checksum manifest is missing", name)
+	}
+	return nil
+}
+
+func readTreeFile(tree *object.Tree, name string) ([]byte, error) {
+	f, err := tree.File(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// checkManifestSignature  sig is an armored detached signature of manifest, by a key in keyring
+func checkManifestSignature(keyring string, manifest, sig []byte) error {
+	kr, err := openpgp.ReadArmoredKeyRing(strings.NewReader(keyring))
+	if err != nil {
+		return errors.Wrap(err, "read keyring")
+	}
+	_, err = openpgp.CheckArmoredDetachedSignature(kr, bytes.NewReader(manifest), bytes.NewReader(sig))
+	return err
+}
+
+// parseChecksums  parse sha256sum output: "<hex>  <path>" a line, path may be prefixed with "*" or "./"
+func parseChecksums(r io.Reader) (map[string]string, error) {
+	ret := map[string]string{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("line %d: invalid checksum line", n)
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], "*"), "./")
+		ret[name] = strings.ToLower(fields[0])
+	}
+	return ret, s.Err()
+}
@@ -0,0 +1,97 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package image
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func Test_parseChecksums(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{
+			"sha256sum output",
+			"# release v1.0.0\n" + sum + "  lib/crm.jar\n" + strings.ToUpper(sum) + " *./bin/start.sh\n",
+			map[string]string{"lib/crm.jar": sum, "bin/start.sh": sum},
+			false,
+		},
+		{"short sum", "abcd  lib/crm.jar\n", nil, true},
+		{"missing path", sum + "\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChecksums(strings.NewReader(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseChecksums() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseChecksums() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkManifestSignature(t *testing.T) {
+	signer, err := openpgp.NewEntity("release", "", "release@we.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@we.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := &bytes.Buffer{}
+	w, err := armor.Encode(keyring, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	manifest := []byte(strings.Repeat("ab", 32) + "  lib/crm.jar\n")
+	sign := func(e *openpgp.Entity) []byte {
+		sig := &bytes.Buffer{}
+		if err := openpgp.ArmoredDetachSign(sig, e, bytes.NewReader(manifest), nil); err != nil {
+			t.Fatal(err)
+		}
+		return sig.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		manifest []byte
+		sig      []byte
+		wantErr  bool
+	}{
+		{"signed", manifest, sign(signer), false},
+		{"untrusted key", manifest, sign(other), true},
+		{"manifest changed", append([]byte("# changed\n"), manifest...), sign(signer), true},
+		{"no signature", manifest, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkManifestSignature(keyring.String(), tt.manifest, tt.sig)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkManifestSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -18,6 +18,24 @@ package etcdkey
 	actual deploy:  actual running instances
 		instances/{deployID}/{instanceID}
 
+	deploy history: deploy config revisions applied by scheduler
+		history/{deployID}/{revision}
+
+	rollout state: progress of the current rollout of a deployment
+		rollout/{deployID}
+
+	deploy status: phase of the latest deploy on each host, reported by agents
+		status/{deployID}/{hostID}
+
+	deploy backups: backups of a deployment kept on each host, reported by agents
+		backup/{deployID}/{hostID}
+
+	restore request: backup a deployment on a host should be restored to, watched by agents
+		restore/{deployID}/{hostID}
+
+	image verify: how agents verify images before deploy, from server config
+		imageverify
+
 	agent should watch host deploy config: to start new or stop running instances
 	agent is alse responable for  updat actual deployments, this information is important for
 	replica controller to schedual deployments
@@ -30,11 +48,17 @@ import (
 )
 
 const (
-	basedir      = "/dolphin/"
-	deploydir    = "deploy/"
-	deployconfig = "config/"
-	deployExpect = "hosts/"
-	deployActual = "instances/"
+	basedir       = "/dolphin/"
+	deploydir     = "deploy/"
+	deployconfig  = "config/"
+	deployExpect  = "hosts/"
+	deployActual  = "instances/"
+	deployHistory = "history/"
+	deployRollout = "rollout/"
+	deployStatus  = "status/"
+	deployBackup  = "backup/"
+	deployRestore = "restore/"
+	imageVerify   = "imageverify"
 )
 
 // BaseDir returns  etcd base dir
@@ -77,3 +101,59 @@ func DeployHostExpectDirOf(stage types.Stage, hostID types.HostID) string {
 func DeployHostExpectPathOf(stage types.Stage, hostID types.HostID, key types.DeployKey) string {
 	return fmt.Sprintf("%v%v", DeployHostExpectDirOf(stage, hostID), key)
 }
+
+func DeployHistoryDir(stage types.Stage) string {
+	return DeployDir(stage) + deployHistory
+}
+
+func DeployHistoryDirOfKey(stage types.Stage, key types.DeployKey) string {
+	return fmt.Sprintf("%v%v/", DeployHistoryDir(stage), key)
+}
+
+func DeployHistoryPathOf(stage types.Stage, key types.DeployKey, revision int64) string {
+	return fmt.Sprintf("%v%d", DeployHistoryDirOfKey(stage, key), revision)
+}
+
+func DeployRolloutDir(stage types.Stage) string {
+	return DeployDir(stage) + deployRollout
+}
+
+func DeployRolloutPathOf(stage types.Stage, key types.DeployKey) string {
+	return fmt.Sprintf("%v%v", DeployRolloutDir(stage), key)
+}
+
+func DeployStatusDir(stage types.Stage) string {
+	return DeployDir(stage) + deployStatus
+}
+
+func DeployStatusDirOfKey(stage types.Stage, key types.DeployKey) string {
+	return fmt.Sprintf("%v%v/", DeployStatusDir(stage), key)
+}
+
+func DeployStatusPathOf(stage types.Stage, key types.DeployKey, hostID types.HostID) string {
+	return fmt.Sprintf("%v%v", DeployStatusDirOfKey(stage, key), hostID)
+}
+
+func DeployBackupDir(stage types.Stage) string {
+	return DeployDir(stage) + deployBackup
+}
+
+func DeployBackupDirOfKey(stage types.Stage, key types.DeployKey) string {
+	return fmt.Sprintf("%v%v/", DeployBackupDir(stage), key)
+}
+
+func DeployBackupPathOf(stage types.Stage, key types.DeployKey, hostID types.HostID) string {
+	return fmt.Sprintf("%v%v", DeployBackupDirOfKey(stage, key), hostID)
+}
+
+func DeployRestoreDir(stage types.Stage) string {
+	return DeployDir(stage) + deployRestore
+}
+
+func DeployRestorePathOf(stage types.Stage, key types.DeployKey, hostID types.HostID) string {
+	return fmt.Sprintf("%v%v/%v", DeployRestoreDir(stage), key, hostID)
+}
+
+func DeployImageVerifyPath(stage types.Stage) string {
+	return DeployDir(stage) + imageVerify
+}
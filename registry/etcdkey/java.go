@@ -19,6 +19,13 @@ const (
 	javaZKRoute     = "java/zk/route/"
 	javaZKInstance  = "java/zk/instances/"
 	javaZKConfig    = "java/zk/config/"
+	javaZKSyncBase  = "java/zksync/base/"
+	javaZKConflict  = "java/zksync/conflicts/"
+	javaDiscoIns    = "java/discovery/instances/"
+	javaDiscoRoute  = "java/discovery/routes/"
+	javaInstanceGC  = "java/gc/"
+	javaSLO         = "java/slo/"
+	javaSLOState    = "java/slostate/"
 )
 
 // JavaProbeDir Probe config dir
@@ -66,3 +73,38 @@ func JavaZKRelInstanceDir() string {
 func JavaZKRelRouteDir() string {
 	return javaZKRoute
 }
+
+// JavaZKSyncBaseDir  last synced content of zk nodes mirrored in etcd
+func JavaZKSyncBaseDir(stage types.Stage) string {
+	return StageBaseDir(stage) + javaZKSyncBase
+}
+
+// JavaZKConflictDir  zk nodes whose etcd mirror cannot be synced
+func JavaZKConflictDir(stage types.Stage) string {
+	return StageBaseDir(stage) + javaZKConflict
+}
+
+// JavaDiscoveryInstanceDir  instances registered by java services using etcd discovery, {dir}{name}/{node}
+func JavaDiscoveryInstanceDir(stage types.Stage) string {
+	return StageBaseDir(stage) + javaDiscoIns
+}
+
+// JavaDiscoveryRoutePath  route of java services using etcd discovery
+func JavaDiscoveryRoutePath(stage types.Stage, name types.DeployName) string {
+	return StageBaseDir(stage) + javaDiscoRoute + string(name)
+}
+
+// JavaInstanceGCDir  per deployment policies of stale instance collection, {dir}{name}
+func JavaInstanceGCDir(stage types.Stage) string {
+	return StageBaseDir(stage) + javaInstanceGC
+}
+
+// JavaSLODir  per deployment service level objectives, {dir}{name}
+func JavaSLODir(stage types.Stage) string {
+	return StageBaseDir(stage) + javaSLO
+}
+
+// JavaSLOStateDir  hourly probe counts of deployments having an SLO, {dir}{name}, so error budgets survive restart
+func JavaSLOStateDir(stage types.Stage) string {
+	return StageBaseDir(stage) + javaSLOState
+}
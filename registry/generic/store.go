@@ -126,6 +126,15 @@ type Interface interface {
 	// ListKeys  ls keys under a  prefix
 	ListKeys(ctx context.Context, key string) ([]string, error)
 	Update(ctx context.Context, key string, in, out interface{}, ttl int64) error
+
+	// GetVersion like Get, and returns mod revision of key as its version
+	GetVersion(ctx context.Context, key string, out interface{}) (int64, error)
+	// UpdateVersion update key only if its mod revision is still version, 0 means key must not exist.
+	// returns the new version, or a resource version conflicts error if key has been changed
+	UpdateVersion(ctx context.Context, key string, in interface{}, version int64) (int64, error)
+	// DeleteVersion delete key only if its mod revision is still version,
+	// returns a resource version conflicts error if key has been changed, or NotFound if it is gone
+	DeleteVersion(ctx context.Context, key string, version int64) error
 }
 
 // New returns an etcd3 implementation of storage.Interface.
@@ -174,6 +183,66 @@ func (s *store) Get(ctx context.Context, key string, out interface{}, ignoreNotF
 	return decode(kv.Value, out)
 }
 
+// GetVersion implements storage.Interface.GetVersion.
+func (s *store) GetVersion(ctx context.Context, key string, out interface{}) (int64, error) {
+	key = keyWithPrefix(s.pathPrefix, key)
+	getResp, err := s.client.KV.Get(ctx, key, s.getOps...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(getResp.Kvs) == 0 {
+		return 0, NewKeyNotFoundError(key, 0)
+	}
+	kv := getResp.Kvs[0]
+	return kv.ModRevision, decode(kv.Value, out)
+}
+
+// UpdateVersion implements storage.Interface.UpdateVersion.
+func (s *store) UpdateVersion(ctx context.Context, key string, in interface{}, version int64) (int64, error) {
+	data, err := encode(in)
+	if err != nil {
+		return 0, err
+	}
+	key = keyWithPrefix(s.pathPrefix, key)
+
+	txnResp, err := s.client.KV.Txn(ctx).If(
+		clientv3.Compare(clientv3.ModRevision(key), "=", version),
+	).Then(
+		clientv3.OpPut(key, string(data)),
+	).Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !txnResp.Succeeded {
+		return 0, NewResourceVersionConflictsError(key, version)
+	}
+	return txnResp.Header.Revision, nil
+}
+
+// DeleteVersion implements storage.Interface.DeleteVersion.
+func (s *store) DeleteVersion(ctx context.Context, key string, version int64) error {
+	key = keyWithPrefix(s.pathPrefix, key)
+
+	txnResp, err := s.client.KV.Txn(ctx).If(
+		clientv3.Compare(clientv3.ModRevision(key), "=", version),
+	).Then(
+		clientv3.OpDelete(key),
+	).Else(
+		clientv3.OpGet(key),
+	).Commit()
+	if err != nil {
+		return err
+	}
+	if txnResp.Succeeded {
+		return nil
+	}
+	if len(txnResp.Responses[0].GetResponseRange().Kvs) == 0 {
+		return NewKeyNotFoundError(key, 0)
+	}
+	return NewResourceVersionConflictsError(key, version)
+}
+
 // Create implements storage.Interface.Create.
 func (s *store) Create(ctx context.Context, key string, obj, out interface{}, ttl uint64) error {
 	data, err := encode(obj)
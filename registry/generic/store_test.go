@@ -266,6 +266,35 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestDeleteVersion(t *testing.T) {
+	ctx, store, cluster := testSetup(t)
+	defer cluster.Terminate(t)
+	key, _ := testPropogateStore(ctx, t, store, &types.Instance{UUID: "testDeleteVersion"})
+
+	ver, err := store.GetVersion(ctx, key, &types.Instance{})
+	if err != nil {
+		t.Fatalf("GetVersion failed: %v", err)
+	}
+	if _, err := store.UpdateVersion(ctx, key, &types.Instance{UUID: "changed"}, ver); err != nil {
+		t.Fatalf("UpdateVersion failed: %v", err)
+	}
+
+	if err := store.DeleteVersion(ctx, key, ver); !IsTestFailed(err) {
+		t.Errorf("DeleteVersion() of a changed key, expecting conflict error, but get: %v", err)
+	}
+
+	ver, err = store.GetVersion(ctx, key, &types.Instance{})
+	if err != nil {
+		t.Fatalf("GetVersion failed: %v", err)
+	}
+	if err := store.DeleteVersion(ctx, key, ver); err != nil {
+		t.Fatalf("DeleteVersion() failed: %v", err)
+	}
+	if err := store.DeleteVersion(ctx, key, ver); !IsNotFound(err) {
+		t.Errorf("DeleteVersion() of a deleted key, expecting not found error, but get: %v", err)
+	}
+}
+
 func testSetup(t *testing.T) (context.Context, *store, *integration.ClusterV3) {
 	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
 	store := newStore(cluster.RandClient(), false, "", nil)
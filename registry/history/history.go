@@ -0,0 +1,134 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package history
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+)
+
+const (
+	// max times to retry, when revision is taken by others
+	maxCreateTries = 3
+)
+
+// Registry deploy history client
+type Registry struct {
+	stage types.Stage
+	store generic.Interface
+}
+
+// NewRegistry returns a Registry
+func NewRegistry(stage types.Stage) (*Registry, error) {
+	store, err := generic.GetStoreInstance(etcdkey.DeployHistoryDir(stage), false)
+	if err != nil {
+		return nil, err
+	}
+	return New(stage, store), nil
+}
+
+// New returns a Registry keeping deploy history of stage in store
+func New(stage types.Stage, store generic.Interface) *Registry {
+	return &Registry{
+		stage: stage,
+		store: store,
+	}
+}
+
+// List  return all revisions of key, order by revision asc
+func (r *Registry) List(key types.DeployKey) ([]*types.DeployRevision, error) {
+	path := etcdkey.DeployHistoryDirOfKey(r.stage, key)
+	ret := []*types.DeployRevision{}
+
+	if err := r.store.List(context.Background(), path, generic.Everything, &ret); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Revision < ret[j].Revision })
+	return ret, nil
+}
+
+// Get return revision of key
+func (r *Registry) Get(key types.DeployKey, revision int64) (*types.DeployRevision, error) {
+	path := etcdkey.DeployHistoryPathOf(r.stage, key, revision)
+	ret := types.DeployRevision{}
+
+	if err := r.store.Get(context.Background(), path, &ret, false); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// Latest  return the latest revision of key, nil if there is none
+func (r *Registry) Latest(key types.DeployKey) (*types.DeployRevision, error) {
+	rev, err := r.lastRevision(key)
+	if err != nil || rev == 0 {
+		return nil, err
+	}
+	return r.Get(key, rev)
+}
+
+// Add  save dc as a new revision
+func (r *Registry) Add(dc *types.DeployConfig, rollbackOf int64) (*types.DeployRevision, error) {
+	if dc == nil {
+		return nil, errors.New("history: deploy config cannot be nil")
+	}
+	key := dc.Key()
+
+	var err error
+	for i := 0; i < maxCreateTries; i++ {
+		var last int64
+		if last, err = r.lastRevision(key); err != nil {
+			return nil, err
+		}
+
+		rev := &types.DeployRevision{
+			Revision:   last + 1,
+			Config:     *dc,
+			Version:    dc.ImageVersion(),
+			RollbackOf: rollbackOf,
+			CreateTime: time.Now(),
+		}
+
+		path := etcdkey.DeployHistoryPathOf(r.stage, key, rev.Revision)
+		err = r.store.Create(context.Background(), path, rev, nil, 0)
+		if err == nil {
+			return rev, nil
+		}
+		if !generic.IsNodeExist(err) {
+			return nil, err
+		}
+	}
+
+	return nil, errors.Wrapf(err, "history: add revision of %v", key)
+}
+
+func (r *Registry) lastRevision(key types.DeployKey) (int64, error) {
+	path := etcdkey.DeployHistoryDirOfKey(r.stage, key)
+	keys, err := r.store.ListKeys(context.Background(), path)
+	if err != nil {
+		return 0, err
+	}
+
+	var last int64
+	for _, k := range keys {
+		v, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			continue
+		}
+		if v > last {
+			last = v
+		}
+	}
+	return last, nil
+}
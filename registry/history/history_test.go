@@ -0,0 +1,88 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/integration"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+)
+
+func testRegistry(t *testing.T) (*Registry, *integration.ClusterV3) {
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	stage := types.Dev
+	store := generic.New(cluster.RandClient(), etcdkey.DeployHistoryDir(stage))
+	return New(stage, store), cluster
+}
+
+func testConfig(ver string) *types.DeployConfig {
+	return &types.DeployConfig{
+		Stage: types.Dev,
+		Type:  types.ProjectType("java"),
+		Name:  types.DeployName("crm-server"),
+		Image: &types.Image{Version: types.MustParseVersion(ver)},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r, cluster := testRegistry(t)
+	defer cluster.Terminate(t)
+
+	dc := testConfig("v1.0.0")
+	key := dc.Key()
+
+	if rev, err := r.Latest(key); err != nil || rev != nil {
+		t.Fatalf("Latest() of empty history = %v, %v, want nil", rev, err)
+	}
+
+	for i, v := range []string{"v1.0.0", "v1.1.0"} {
+		rev, err := r.Add(testConfig(v), 0)
+		if err != nil {
+			t.Fatalf("Add(%v) error = %v", v, err)
+		}
+		if rev.Revision != int64(i+1) || rev.Version != v {
+			t.Errorf("Add(%v) = revision %v version %v, want %v %v", v, rev.Revision, rev.Version, i+1, v)
+		}
+	}
+
+	// rollback to revision 1 is recorded as a new revision
+	old, err := r.Get(key, 1)
+	if err != nil {
+		t.Fatalf("Get(1) error = %v", err)
+	}
+	rev, err := r.Add(&old.Config, old.Revision)
+	if err != nil {
+		t.Fatalf("Add() of rollback error = %v", err)
+	}
+	if rev.Revision != 3 || rev.RollbackOf != 1 || rev.Version != "v1.0.0" {
+		t.Errorf("Add() of rollback = %+v, want revision 3 rollback of 1", rev)
+	}
+
+	revs, err := r.List(key)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(revs) != 3 {
+		t.Fatalf("List() got %d revisions, want 3", len(revs))
+	}
+	for i, v := range revs {
+		if v.Revision != int64(i+1) {
+			t.Errorf("List()[%d].Revision = %v, want %v", i, v.Revision, i+1)
+		}
+	}
+
+	latest, err := r.Latest(key)
+	if err != nil || latest == nil || latest.Revision != 3 {
+		t.Errorf("Latest() = %v, %v, want revision 3", latest, err)
+	}
+
+	if _, err := r.Get(key, 4); !generic.IsNotFound(err) {
+		t.Errorf("Get() of unknown revision error = %v, want not found", err)
+	}
+}
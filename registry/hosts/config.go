@@ -8,10 +8,15 @@ package hosts
 import (
 	"context"
 
+	"github.com/pkg/errors"
 	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
 	"we.com/dolphin/types"
 )
 
+// max times to update a host config, when it is changed by others
+const maxUpdateTries = 3
+
 // SaveConfig save or overwrite host config
 func (r *Registry) SaveConfig(hc *types.HostConfig) error {
 	key := etcdkey.HostConfigPath(r.stage, hc.HostName)
@@ -28,3 +33,54 @@ func (r *Registry) GetConfig(hostname string) (*types.HostConfig, error) {
 	}
 	return &ret, nil
 }
+
+// Cordon mark host unschedulable, config is created if not exist
+func (r *Registry) Cordon(hostname string, reason string) (*types.HostConfig, error) {
+	return r.updateConfig(hostname, func(hc *types.HostConfig) {
+		hc.Unschedulable = true
+		hc.CordonReason = reason
+	})
+}
+
+// Uncordon mark host schedulable
+func (r *Registry) Uncordon(hostname string) (*types.HostConfig, error) {
+	return r.updateConfig(hostname, func(hc *types.HostConfig) {
+		hc.Unschedulable = false
+		hc.CordonReason = ""
+	})
+}
+
+// updateConfig  update config of hostname by update, compare and swap, retry if it is changed by others
+func (r *Registry) updateConfig(hostname string, update func(hc *types.HostConfig)) (*types.HostConfig, error) {
+	key := etcdkey.HostConfigPath(r.stage, hostname)
+
+	var err error
+	for i := 0; i < maxUpdateTries; i++ {
+		hc := &types.HostConfig{}
+		var ver int64
+		ver, err = r.store.GetVersion(context.TODO(), key, hc)
+		switch {
+		case generic.IsNotFound(err):
+			hc = &types.HostConfig{
+				HostName: hostname,
+				Stage:    r.stage,
+			}
+			update(hc)
+			err = r.store.Create(context.TODO(), key, hc, nil, 0)
+		case err != nil:
+			return nil, err
+		default:
+			update(hc)
+			_, err = r.store.UpdateVersion(context.TODO(), key, hc, ver)
+		}
+
+		if err == nil {
+			return hc, nil
+		}
+		if !generic.IsNodeExist(err) && !generic.IsTestFailed(err) {
+			return nil, err
+		}
+	}
+
+	return nil, errors.Wrapf(err, "hosts: update config of %v", hostname)
+}
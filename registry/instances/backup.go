@@ -0,0 +1,168 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package instances
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/registry/watch"
+	"we.com/dolphin/types"
+)
+
+// UpdateBackups  save backups of key kept on host, newest first
+func (r *Registry) UpdateBackups(key types.DeployKey, host types.HostID, bs []types.DeployBackup) error {
+	if host == "" {
+		return errors.Errorf("backups of %v: host cannot be empty", key)
+	}
+
+	path := etcdkey.DeployBackupPathOf(r.stage, key, host)
+	return r.store.Update(context.Background(), path, bs, nil, 0)
+}
+
+// GetBackups  backups of key kept on every host, order by host, and newest first on the same host
+func (r *Registry) GetBackups(key types.DeployKey) ([]types.DeployBackup, error) {
+	path := etcdkey.DeployBackupDirOfKey(r.stage, key)
+	bss := [][]types.DeployBackup{}
+
+	if err := r.store.List(context.Background(), path, generic.Everything, &bss); err != nil {
+		return nil, err
+	}
+
+	ret := []types.DeployBackup{}
+	for _, bs := range bss {
+		ret = append(ret, bs...)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Host != ret[j].Host {
+			return ret[i].Host < ret[j].Host
+		}
+		return ret[i].Time.After(ret[j].Time)
+	})
+	return ret, nil
+}
+
+// RequestRestore  ask the agent on host to restore key to backup, the backup must be kept on host
+func (r *Registry) RequestRestore(key types.DeployKey, host types.HostID, backup types.UUID) (*types.DeployRestore, error) {
+	bs, err := r.GetBackups(key)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, b := range bs {
+		if b.Host == host && b.Key == backup {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("backup %v of %v not found on host %v", backup, key, host)
+	}
+
+	req := &types.DeployRestore{
+		Key:         backup,
+		RequestTime: time.Now(),
+	}
+	path := etcdkey.DeployRestorePathOf(r.stage, key, host)
+	if err := r.store.Update(context.Background(), path, req, nil, 0); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// DeleteRestore  remove the restore request of key on host after req is handled,
+// it is kept if it has been replaced by a newer request
+func (r *Registry) DeleteRestore(key types.DeployKey, host types.HostID, req *types.DeployRestore) error {
+	path := etcdkey.DeployRestorePathOf(r.stage, key, host)
+	cur := types.DeployRestore{}
+	ver, err := r.store.GetVersion(context.Background(), path, &cur)
+	if generic.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cur.Key != req.Key || !cur.RequestTime.Equal(req.RequestTime) {
+		return nil
+	}
+
+	err = r.store.DeleteVersion(context.Background(), path, ver)
+	if generic.IsNotFound(err) || generic.IsTestFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// ListRestores  pending restore requests of host, by deployment
+func (r *Registry) ListRestores(host types.HostID) (map[types.DeployKey]*types.DeployRestore, error) {
+	dat := map[string]types.DeployRestore{}
+	if err := r.store.List(context.Background(), etcdkey.DeployRestoreDir(r.stage), generic.Everything, dat); err != nil {
+		return nil, err
+	}
+
+	// key is {deployID}/{hostID}
+	suffix := fmt.Sprintf("/%v", host)
+	ret := map[types.DeployKey]*types.DeployRestore{}
+	for k, v := range dat {
+		k = strings.TrimPrefix(k, "/")
+		if !strings.HasSuffix(k, suffix) {
+			continue
+		}
+		req := v
+		ret[types.DeployKey(strings.TrimSuffix(k, suffix))] = &req
+	}
+	return ret, nil
+}
+
+// WatchRestores  call h with restore requests of host, until ctx is done or h returns an error
+func (r *Registry) WatchRestores(ctx context.Context, host types.HostID, h func(key types.DeployKey, req *types.DeployRestore) error) error {
+	typ := reflect.TypeOf(types.DeployRestore{})
+	w, err := r.store.Watch(ctx, etcdkey.DeployRestoreDir(r.stage), generic.Everything, true, typ)
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+	c := w.ResultChan()
+
+	suffix := fmt.Sprintf("/%v", host)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-c:
+			if !ok {
+				return errors.New("restore watcher closed")
+			}
+			switch event.Type {
+			case watch.Error:
+				glog.Warningf("watch restore requests: %v", event.Object)
+			case watch.Added, watch.Modified:
+				// key is {deployID}/{hostID}
+				k := strings.TrimPrefix(event.Key, "/")
+				if !strings.HasSuffix(k, suffix) {
+					continue
+				}
+				req, ok := event.Object.(*types.DeployRestore)
+				if !ok || req == nil {
+					glog.Warningf("restore request of %v: unexpected object %T", k, event.Object)
+					continue
+				}
+				if err := h(types.DeployKey(strings.TrimSuffix(k, suffix)), req); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package instances
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+)
+
+// UpdateDeployment  save status of the latest deploy of d on its host
+func (r *Registry) UpdateDeployment(d *types.Deployment) error {
+	if d == nil {
+		return errors.New("deployment cannot be nil")
+	}
+	if d.Host == "" {
+		return errors.Errorf("deployment %v: host cannot be empty", d.Key())
+	}
+
+	path := etcdkey.DeployStatusPathOf(r.stage, d.Key(), d.Host)
+	return r.store.Update(context.Background(), path, d, nil, 0)
+}
+
+// GetDeployments  status of the latest deploy of key on every host, order by host
+func (r *Registry) GetDeployments(key types.DeployKey) ([]*types.Deployment, error) {
+	path := etcdkey.DeployStatusDirOfKey(r.stage, key)
+	ret := []*types.Deployment{}
+
+	if err := r.store.List(context.Background(), path, generic.Everything, &ret); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Host < ret[j].Host })
+	return ret, nil
+}
@@ -0,0 +1,46 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package instances
+
+import (
+	"context"
+	"os"
+
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+)
+
+// SetImageVerify  save how agents should verify images before deploy, nil removes it
+func (r *Registry) SetImageVerify(iv *types.ImageVerify) error {
+	path := etcdkey.DeployImageVerifyPath(r.stage)
+	if iv == nil {
+		err := r.store.Delete(context.Background(), path, nil)
+		if generic.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := iv.Validate(); err != nil {
+		return err
+	}
+	return r.store.Update(context.Background(), path, iv, nil, 0)
+}
+
+// GetImageVerify  how agents should verify images before deploy, nil if not set
+func (r *Registry) GetImageVerify() (*types.ImageVerify, error) {
+	path := etcdkey.DeployImageVerifyPath(r.stage)
+	ret := types.ImageVerify{}
+
+	if err := r.store.Get(context.Background(), path, &ret, false); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &ret, nil
+}
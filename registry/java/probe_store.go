@@ -0,0 +1,65 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package java
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"we.com/dolphin/registry/etcdkey"
+	"we.com/dolphin/registry/generic"
+	"we.com/dolphin/types"
+	"we.com/dolphin/types/ins/java"
+)
+
+// times to retry when probe interfaces are modified concurrently
+const modifyRetry = 3
+
+// GetProbeInterfaces  probe interfaces of name, and version of them, version is 0 if none is configed
+func GetProbeInterfaces(stage types.Stage, name types.DeployName) (java.ProbeInterfaces, int64, error) {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ret := java.ProbeInterfaces{}
+	ver, err := store.GetVersion(context.Background(), etcdkey.JavaProbePath(stage, name), &ret)
+	if generic.IsNotFound(err) {
+		return java.ProbeInterfaces{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return ret, ver, nil
+}
+
+// ModifyProbeInterfaces  apply fn to probe interfaces of name and save them,
+// fn is called again if they are changed by others meanwhile
+func ModifyProbeInterfaces(stage types.Stage, name types.DeployName, fn func(java.ProbeInterfaces) error) (java.ProbeInterfaces, error) {
+	store, err := generic.GetStoreInstance("", false)
+	if err != nil {
+		return nil, err
+	}
+
+	key := etcdkey.JavaProbePath(stage, name)
+	for i := 0; i < modifyRetry; i++ {
+		ifs, ver, err := GetProbeInterfaces(stage, name)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(ifs); err != nil {
+			return nil, err
+		}
+
+		_, err = store.UpdateVersion(context.Background(), key, ifs, ver)
+		if generic.IsTestFailed(err) {
+			continue
+		}
+		return ifs, err
+	}
+
+	return nil, errors.Errorf("probe interfaces of %v are changing too frequently, please try again", name)
+}
@@ -8,6 +8,7 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -151,6 +152,49 @@ func (s Selector) ToSelector() (labels.Selector, error) {
 	return labels.Parse(raw)
 }
 
+// Affinity placement rules of a deployment
+type Affinity struct {
+	// SpreadBy spread instances across the values of these host labels, eg: dc
+	// hosts without the label are treated as one group
+	SpreadBy []string `json:"spreadBy,omitempty"`
+	// With only schedual to hosts where instances of these deploy keys are placed
+	With []DeployKey `json:"with,omitempty"`
+	// NotWith never co-locate with instances of these deploy keys
+	NotWith []DeployKey `json:"notWith,omitempty"`
+}
+
+// Validate check affinity rules are valid
+func (af *Affinity) Validate() error {
+	if af == nil {
+		return nil
+	}
+
+	for _, v := range af.SpreadBy {
+		if v == "" {
+			return errors.New("spreadBy label cannot be empty")
+		}
+	}
+
+	notWith := make(map[DeployKey]struct{}, len(af.NotWith))
+	for _, k := range af.NotWith {
+		if _, _, err := ParseDeployKey(k); err != nil {
+			return errors.Wrapf(err, "notWith %v", k)
+		}
+		notWith[k] = struct{}{}
+	}
+
+	for _, k := range af.With {
+		if _, _, err := ParseDeployKey(k); err != nil {
+			return errors.Wrapf(err, "with %v", k)
+		}
+		if _, ok := notWith[k]; ok {
+			return errors.Errorf("%v is in both with and notWith", k)
+		}
+	}
+
+	return nil
+}
+
 var (
 	// OneTime 执行一次,  程序退出后(正常或异常)， 什么都不干
 	OneTime RestartType = "onetime"
@@ -192,13 +236,18 @@ type DeployConfig struct {
 	NumOfInstance       int         `json:"numOfInstance,omitempty"`
 	ServiceType         ServiceType `json:"serviceType,omitempty"`
 	Stage               Stage       `json:"stage,omitempty"`
-	MaxInstancesPerNode int         `json:"maxInstancesPerNode,omitempty"`
+	MaxInstancesPerNode int         `json:"maxInstancesPerNode,omitempty"` // 0 means 1, an instance per host
 
 	Image     *Image `json:"image,omitempty"`
 	DeployDir string `json:"deployDir,omitempty"`
 	// Values is config map used to render the config files
 	Values       map[string]interface{} `json:"values,omitempty"`
 	DeployPolicy DeployPolicy           `json:"deployPolicy,omitempty"`
+	// KeepVersions  num of versions kept on a host for Versioned deploy policy, 3 if not set,
+	// versions backups are taken from are kept too, until the backups are dropped
+	KeepVersions int `json:"keepVersions,omitempty"`
+	// KeepBackups  num of backups kept on a host, taken before each deploy, 5 if not set
+	KeepBackups int `json:"keepBackups,omitempty"`
 
 	Labels map[string]string `json:"labels,omitempty"` // used for query
 	// these fields used to select which hosts can start this project
@@ -206,6 +255,8 @@ type DeployConfig struct {
 	selector         labels.Selector `json:"selector,omitempty"`
 	ResourceQuota    *ResourceSize   `json:"resourceQuota,omitempty"`
 	ResourceRequired *DeployResource `json:"resourceRequired,omitempty"`
+	// Affinity placement rules of instances, relative to  other instances
+	Affinity *Affinity `json:"affinity,omitempty"`
 
 	// RestartPolicy action taken, when process exits,
 	// default always restart
@@ -243,6 +294,22 @@ func (dc *DeployConfig) Validate() error {
 		return errors.New("unknown env")
 	}
 
+	if dc.MaxInstancesPerNode < 0 {
+		return errors.New("maxInstancesPerNode cannot less than 0")
+	}
+
+	if dc.KeepVersions < 0 {
+		return errors.New("keepVersions cannot less than 0")
+	}
+
+	if dc.KeepBackups < 0 {
+		return errors.New("keepBackups cannot less than 0")
+	}
+
+	if err := dc.Affinity.Validate(); err != nil {
+		return errors.Wrap(err, "affinity")
+	}
+
 	if len(dc.Selector) > 0 && dc.selector == nil {
 		s, err := dc.Selector.ToSelector()
 		if err != nil {
@@ -324,6 +391,83 @@ func ParseDeployKey(key DeployKey) (ProjectType, string, error) {
 	return pt, name, nil
 }
 
+// DeployRevision  a deploy config applied by scheduler
+type DeployRevision struct {
+	Revision int64        `json:"revision,omitempty"`
+	Config   DeployConfig `json:"config,omitempty"`
+	Version  string       `json:"version,omitempty"`
+	// RollbackOf  revision rollbacked to, 0 if this is not a rollback
+	RollbackOf int64     `json:"rollbackOf,omitempty"`
+	CreateTime time.Time `json:"createTime,omitempty"`
+}
+
+// RolloutPhase phase of a rollout
+type RolloutPhase string
+
+const (
+	// RolloutUpdating  instances are being added or updated
+	RolloutUpdating RolloutPhase = "updating"
+	// RolloutPaused rollout timeout, and is paused
+	RolloutPaused RolloutPhase = "paused"
+	// RolloutFailed rollout failed
+	RolloutFailed RolloutPhase = "failed"
+	// RolloutWaitingLegacy  new instances are ready, wait legacy instances to be removed
+	RolloutWaitingLegacy RolloutPhase = "waitingLegacy"
+	// RolloutDone  rollout finished
+	RolloutDone RolloutPhase = "done"
+)
+
+// RolloutState progress of the current rollout of a deployment
+type RolloutState struct {
+	Phase     RolloutPhase `json:"phase,omitempty"`
+	Version   DeployVer    `json:"version,omitempty"`
+	HostsDone []HostID     `json:"hostsDone,omitempty"`
+	// Prev  deploy config before the rollout, used to revert or shift traffic from when it is resumed
+	Prev *DeployConfig `json:"prev,omitempty"`
+	// LegacyDeadline legacy instances are removed after it, zero if not scheduled
+	LegacyDeadline time.Time `json:"legacyDeadline,omitempty"`
+	Message        string    `json:"message,omitempty"`
+	UpdateTime     time.Time `json:"updateTime,omitempty"`
+}
+
+// PlanAction change to a host's DeploySpec
+type PlanAction string
+
+const (
+	// PlanAddHost  host has no instance of the deployment yet
+	PlanAddHost PlanAction = "add"
+	// PlanUpdateHost  instances on host are added, removed or replaced
+	PlanUpdateHost PlanAction = "update"
+	// PlanRemoveHost  all instances on host are removed
+	PlanRemoveHost PlanAction = "remove"
+)
+
+// HostPlan  DeploySpec change of a host
+type HostPlan struct {
+	HostID HostID     `json:"hostID"`
+	Action PlanAction `json:"action"`
+	Before DeploySpec `json:"before"`
+	After  DeploySpec `json:"after"`
+	// Legacy instances left in After, which are stopped when legacy lease expires
+	Legacy map[DeployVer]int `json:"legacy,omitempty"`
+}
+
+// DeployPlan  host config changes a deployment would make
+type DeployPlan struct {
+	Key     DeployKey        `json:"key"`
+	Version DeployVer        `json:"version"`
+	Policy  UpdatePolicyName `json:"policy"`
+	Hosts   []HostPlan       `json:"hosts"`
+}
+
+// ImageVersion version of the image to deploy, empty if not set
+func (dc *DeployConfig) ImageVersion() string {
+	if dc.Image != nil && dc.Image.Version != nil {
+		return dc.Image.Version.String()
+	}
+	return ""
+}
+
 type DeployVer string
 type DeploySpec struct {
 	Info map[DeployVer]int `json:"info,omitempty"`
@@ -343,6 +487,7 @@ const (
 	PhaseApply      Phase = "apply Patch"
 	PhaseRestarting Phase = "restarting service"
 	PhaseDone       Phase = "done"
+	PhaseFailed     Phase = "failed"
 )
 
 type ProcessStatus string
@@ -357,6 +502,8 @@ const (
 type DeployStatus struct {
 	DeployPhase   Phase         `json:"deployPhase,omitempty"`
 	ProcessStatus ProcessStatus `json:"processStatus,omitempty"`
+	// Message  why the deploy failed
+	Message string `json:"message,omitempty"`
 }
 
 // Deployment reprents an actual deploy on a host
@@ -373,6 +520,26 @@ type Deployment struct {
 	UpdateTime   time.Time    `json:"updateTime,omitempty"`
 }
 
+// Key get deployKey of this deployment
+func (d *Deployment) Key() DeployKey {
+	return DeployKey(fmt.Sprintf("%v/%v", d.Type, d.Name))
+}
+
+// DeployBackup  a snapshot of a deployment on a host, taken before it is deployed
+type DeployBackup struct {
+	Key     UUID      `json:"key,omitempty"`
+	Deploy  DeployKey `json:"deploy,omitempty"`
+	Host    HostID    `json:"host,omitempty"`
+	Version Version   `json:"version,omitempty"`
+	Time    time.Time `json:"time,omitempty"`
+}
+
+// DeployRestore  a request to restore a deployment on a host to backup Key
+type DeployRestore struct {
+	Key         UUID      `json:"key,omitempty"`
+	RequestTime time.Time `json:"requestTime,omitempty"`
+}
+
 // UpdatePolicyName how to update
 type UpdatePolicyName string
 
@@ -387,14 +554,21 @@ const (
 	// first create half the num (round to ceiling) of deployment,
 	// then rollingupdate the other half
 	MixedUpdate UpdatePolicyName = "mixed"
+	// CanaryUpdate first deploy n% new version instances as canaries,
+	// watch them for a bake time, then finish the rollout if they are healthy,
+	// or remove the canaries if not
+	CanaryUpdate UpdatePolicyName = "canary"
 )
 
 // UpdateOption  update options
 type UpdateOption struct {
-	Policy     UpdatePolicyName
-	Step       time.Duration
+	Policy UpdatePolicyName
+	Step   time.Duration
+	// NewPercent percent of new instances, for mixed and canary
 	NewPercent float64
 	Timeout    time.Duration
+	// Bake time to watch canaries before promote them
+	Bake time.Duration
 }
 
 // Validate  check updateOption is valid
@@ -411,8 +585,18 @@ func (uo *UpdateOption) Validate() error {
 		if uo.NewPercent > 1 || uo.NewPercent < 0 {
 			return errors.New("udate policy config: mixed, new ratio invalid, must between [0, 100]")
 		}
+	case CanaryUpdate:
+		if uo.NewPercent > 1 || uo.NewPercent <= 0 {
+			return errors.New("udate policy config: canary, canary ratio invalid, must between (0, 100]")
+		}
+		if uo.Bake <= 0 {
+			return errors.New("udate policy config: canary, bake time must greater than 0")
+		}
+		if uo.Step > uo.Timeout {
+			return errors.New("update policy config: step should not greater then  timout")
+		}
 	default:
-		return errors.Errorf("unknonwn update policy, valids are %v, %v, %v", RollingUpdate, NewDeploy, MixedUpdate)
+		return errors.Errorf("unknonwn update policy, valids are %v, %v, %v, %v", RollingUpdate, NewDeploy, MixedUpdate, CanaryUpdate)
 	}
 	return nil
 }
@@ -426,6 +610,44 @@ func (uo *UpdateOption) UnmarshalJSON(data []byte) error {
 	}
 
 	parts := strings.Split(s, ":")
+	p := UpdatePolicyName(parts[0])
+	var timeout = 5 * time.Minute
+
+	// canary[:n%[:bake[[:step]:timeout]]], step and timeout are of updating the others after bake
+	if p == CanaryUpdate {
+		if len(parts) > 5 {
+			return errors.New("update policy config: format error")
+		}
+		uo.NewPercent = 0.1
+		uo.Bake = 5 * time.Minute
+		if len(parts) >= 2 {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(parts[1], "%"), 64)
+			if err != nil {
+				return err
+			}
+			uo.NewPercent = v / 100
+		}
+		if len(parts) >= 3 {
+			if uo.Bake, err = time.ParseDuration(parts[2]); err != nil {
+				return err
+			}
+		}
+		var step = 30 * time.Second
+		if len(parts) == 5 {
+			if step, err = time.ParseDuration(parts[3]); err != nil {
+				return err
+			}
+		}
+		if len(parts) >= 4 {
+			if timeout, err = time.ParseDuration(parts[len(parts)-1]); err != nil {
+				return err
+			}
+		}
+		uo.Policy = p
+		uo.Step = step
+		uo.Timeout = timeout
+		return uo.Validate()
+	}
 
 	var tos string
 	if len(parts) > 3 {
@@ -436,9 +658,6 @@ func (uo *UpdateOption) UnmarshalJSON(data []byte) error {
 		tos = parts[1]
 	}
 
-	p := UpdatePolicyName(parts[0])
-	var timeout = 5 * time.Minute
-
 	switch p {
 	case RollingUpdate, NewDeploy:
 		var step = 30 * time.Second
@@ -471,6 +690,11 @@ func (uo *UpdateOption) UnmarshalJSON(data []byte) error {
 	return uo.Validate()
 }
 
+// percent  f in percent, rounded, as 100*f is not exact, eg: 100*0.29 is 28.999999999999996
+func percent(f float64) int {
+	return int(math.Round(100 * f))
+}
+
 // MarshalJSON implements json.Marshaler interface
 func (uo UpdateOption) MarshalJSON() (data []byte, err error) {
 	if err := uo.Validate(); err != nil {
@@ -481,7 +705,9 @@ func (uo UpdateOption) MarshalJSON() (data []byte, err error) {
 	case NewDeploy, RollingUpdate:
 		ret = fmt.Sprintf("%v:%v:%v", uo.Policy, uo.Step.String(), uo.Timeout.String())
 	case MixedUpdate:
-		ret = fmt.Sprintf("%v:%v:%v", uo.Policy, int(100*uo.NewPercent), uo.Timeout.String())
+		ret = fmt.Sprintf("%v:%v:%v", uo.Policy, percent(uo.NewPercent), uo.Timeout.String())
+	case CanaryUpdate:
+		ret = fmt.Sprintf("%v:%v%%:%v:%v:%v", uo.Policy, percent(uo.NewPercent), uo.Bake.String(), uo.Step.String(), uo.Timeout.String())
 	default:
 		return nil, errors.New("update policy config: invalid policy name")
 	}
@@ -64,3 +64,163 @@ func TestDeployConfig_Key(t *testing.T) {
 		t.Errorf("%s", bs)
 	}
 }
+
+func TestAffinity_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		af      *Affinity
+		wantErr bool
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name: "valid",
+			af: &Affinity{
+				SpreadBy: []string{"dc"},
+				With:     []DeployKey{"java/crm-db"},
+				NotWith:  []DeployKey{"java/crm-job"},
+			},
+		},
+		{
+			name:    "empty label",
+			af:      &Affinity{SpreadBy: []string{""}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid key",
+			af:      &Affinity{NotWith: []DeployKey{"crm-job"}},
+			wantErr: true,
+		},
+		{
+			name: "conflict",
+			af: &Affinity{
+				With:    []DeployKey{"java/crm-job"},
+				NotWith: []DeployKey{"java/crm-job"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.af.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Affinity.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdateOption_Canary(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    UpdateOption
+		wantErr bool
+	}{
+		{
+			name: "default",
+			data: `"canary"`,
+			want: UpdateOption{Policy: CanaryUpdate, NewPercent: 0.1, Bake: 5 * time.Minute, Step: 30 * time.Second, Timeout: 5 * time.Minute},
+		},
+		{
+			name: "percent and bake",
+			data: `"canary:20%:10m"`,
+			want: UpdateOption{Policy: CanaryUpdate, NewPercent: 0.2, Bake: 10 * time.Minute, Step: 30 * time.Second, Timeout: 5 * time.Minute},
+		},
+		{
+			name: "percent without sign",
+			data: `"canary:50"`,
+			want: UpdateOption{Policy: CanaryUpdate, NewPercent: 0.5, Bake: 5 * time.Minute, Step: 30 * time.Second, Timeout: 5 * time.Minute},
+		},
+		{
+			name: "timeout",
+			data: `"canary:20%:10m:20m"`,
+			want: UpdateOption{Policy: CanaryUpdate, NewPercent: 0.2, Bake: 10 * time.Minute, Step: 30 * time.Second, Timeout: 20 * time.Minute},
+		},
+		{
+			name: "step and timeout",
+			data: `"canary:20%:10m:1m:20m"`,
+			want: UpdateOption{Policy: CanaryUpdate, NewPercent: 0.2, Bake: 10 * time.Minute, Step: time.Minute, Timeout: 20 * time.Minute},
+		},
+		{
+			name:    "step greater than timeout",
+			data:    `"canary:20%:10m:20m:1m"`,
+			wantErr: true,
+		},
+		{
+			name:    "zero percent",
+			data:    `"canary:0:10m"`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid bake",
+			data:    `"canary:10:abc"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uo := UpdateOption{}
+			err := json.Unmarshal([]byte(tt.data), &uo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateOption.UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if uo != tt.want {
+				t.Errorf("UpdateOption.UnmarshalJSON() = %+v, want %+v", uo, tt.want)
+			}
+
+			d, err := json.Marshal(uo)
+			if err != nil {
+				t.Fatalf("UpdateOption.MarshalJSON() error = %v", err)
+			}
+			back := UpdateOption{}
+			if err := json.Unmarshal(d, &back); err != nil {
+				t.Fatalf("unmarshal %s: %v", d, err)
+			}
+			if back != uo {
+				t.Errorf("round trip %s = %+v, want %+v", d, back, uo)
+			}
+		})
+	}
+}
+
+func TestUpdateOption_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		uo   UpdateOption
+		want string
+	}{
+		{
+			name: "canary",
+			uo:   UpdateOption{Policy: CanaryUpdate, NewPercent: 0.29, Bake: 10 * time.Minute, Step: time.Minute, Timeout: 20 * time.Minute},
+			want: `"canary:29%:10m0s:1m0s:20m0s"`,
+		},
+		{
+			name: "mixed",
+			uo:   UpdateOption{Policy: MixedUpdate, NewPercent: 0.57, Timeout: 5 * time.Minute},
+			want: `"mixed:57:5m0s"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := json.Marshal(tt.uo)
+			if err != nil {
+				t.Fatalf("UpdateOption.MarshalJSON() error = %v", err)
+			}
+			if string(d) != tt.want {
+				t.Errorf("UpdateOption.MarshalJSON() = %s, want %s", d, tt.want)
+			}
+
+			back := UpdateOption{}
+			if err := json.Unmarshal(d, &back); err != nil {
+				t.Fatalf("unmarshal %s: %v", d, err)
+			}
+			if back != tt.uo {
+				t.Errorf("round trip %s = %+v, want %+v", d, back, tt.uo)
+			}
+		})
+	}
+}
@@ -31,6 +31,9 @@ type HostConfig struct {
 	Labels                 map[string]string `json:"labels,omitempty"` // labels are used as selectors
 	ReportTags             map[string]string `json:"reportTags,omitempty"`
 	ResourceReserved       DeployResource    `json:"resourceReserved,omitempty"`
+	// Unschedulable a cordoned host, no new instances are scheduled to it
+	Unschedulable bool   `json:"unschedulable,omitempty"`
+	CordonReason  string `json:"cordonReason,omitempty"`
 }
 
 // HostCondition  host condition happing
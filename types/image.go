@@ -149,6 +149,53 @@ func ParseImageName(name string) (*Image, error) {
 	}, nil
 }
 
+// ImageVerifyMode  how an image version is verified before it is deployed
+type ImageVerifyMode string
+
+const (
+	// VerifyNone  images are not verified
+	VerifyNone ImageVerifyMode = ""
+	// VerifySignature  the tag of a version, or the commit it points to, must be signed by a key in Keyring
+	VerifySignature ImageVerifyMode = "signature"
+	// VerifyChecksum  every file of a version must match the checksum manifest of it,
+	// and the manifest must be signed by a key in Keyring
+	VerifyChecksum ImageVerifyMode = "checksum"
+)
+
+const (
+	// DefaultChecksumManifest  manifest file at the root of an image, in sha256sum format
+	DefaultChecksumManifest = "SHA256SUMS"
+	// ChecksumSignatureSuffix  armored detached signature of a manifest is at manifest path with this suffix
+	ChecksumSignatureSuffix = ".asc"
+)
+
+// ImageVerify  how images are verified before they are deployed
+type ImageVerify struct {
+	Mode ImageVerifyMode `json:"mode,omitempty"`
+	// Keyring  armored PGP public keys trusted, versions or checksum manifests must be signed by one of them
+	Keyring string `json:"keyring,omitempty"`
+	// Manifest  path of the checksum manifest in an image, for VerifyChecksum, DefaultChecksumManifest if not set
+	Manifest string `json:"manifest,omitempty"`
+}
+
+// Validate  check if iv is a valid config
+func (iv *ImageVerify) Validate() error {
+	if iv == nil {
+		return nil
+	}
+
+	switch iv.Mode {
+	case VerifyNone:
+	case VerifySignature, VerifyChecksum:
+		if iv.Keyring == "" {
+			return errors.Errorf("keyring cannot be empty for %v verify mode", iv.Mode)
+		}
+	default:
+		return errors.Errorf("unknown image verify mode: %v", iv.Mode)
+	}
+	return nil
+}
+
 // Template a unparsed template file
 type Template struct {
 	// Name  path where to store the parsed template
@@ -130,3 +130,26 @@ func TestImage_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestImageVerify_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		iv      *ImageVerify
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"none", &ImageVerify{}, false},
+		{"checksum without keyring", &ImageVerify{Mode: VerifyChecksum}, true},
+		{"checksum", &ImageVerify{Mode: VerifyChecksum, Keyring: "-----BEGIN PGP PUBLIC KEY BLOCK-----"}, false},
+		{"signature without keyring", &ImageVerify{Mode: VerifySignature}, true},
+		{"signature", &ImageVerify{Mode: VerifySignature, Keyring: "-----BEGIN PGP PUBLIC KEY BLOCK-----"}, false},
+		{"unknown mode", &ImageVerify{Mode: "md5"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.iv.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ImageVerify.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package java
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AssertSource  what part of a probe response an assertion checks
+type AssertSource string
+
+// assertion sources
+const (
+	// SourceStatus  http status code
+	SourceStatus AssertSource = "status"
+	// SourceLatency  time to receive the whole response, values are durations, e.g. 200ms
+	SourceLatency AssertSource = "latency"
+	// SourceBody  response body as text
+	SourceBody AssertSource = "body"
+	// SourceJSON  value at Path of json response body
+	SourceJSON AssertSource = "json"
+)
+
+// AssertOp  how actual value is compared with Value
+type AssertOp string
+
+// assertion operators
+const (
+	OpEq          AssertOp = "eq"
+	OpNe          AssertOp = "ne"
+	OpLt          AssertOp = "lt"
+	OpLe          AssertOp = "le"
+	OpGt          AssertOp = "gt"
+	OpGe          AssertOp = "ge"
+	OpRange       AssertOp = "range" // Value is "min,max", both inclusive
+	OpContains    AssertOp = "contains"
+	OpNotContains AssertOp = "notContains"
+	OpMatches     AssertOp = "matches" // Value is a regexp
+	OpExists      AssertOp = "exists"
+	OpNotExists   AssertOp = "notExists"
+)
+
+// Assertion  a check on probe response
+type Assertion struct {
+	Name   string       `json:"name,omitempty"`
+	Source AssertSource `json:"source"`
+	// Path  dot separated path of json value, array elements are selected by index, e.g. data.items.0.id
+	Path  string   `json:"path,omitempty"`
+	Op    AssertOp `json:"op"`
+	Value string   `json:"value,omitempty"`
+}
+
+// AssertionResult  result of evaluating an assertion
+type AssertionResult struct {
+	*Assertion
+	OK     bool   `json:"ok"`
+	Actual string `json:"actual,omitempty"`
+	Msg    string `json:"msg,omitempty"`
+}
+
+func (ar *AssertionResult) String() string {
+	a := ar.Assertion
+	target := string(a.Source)
+	if a.Source == SourceJSON {
+		target = fmt.Sprintf("json %v", a.Path)
+	}
+	s := fmt.Sprintf("%v: %v %v %q", a.Name, target, a.Op, a.Value)
+	if ar.Actual != "" {
+		s += fmt.Sprintf(", actual %q", ar.Actual)
+	}
+	if ar.Msg != "" {
+		s += ": " + ar.Msg
+	}
+	return s
+}
+
+var numberOps = map[AssertOp]bool{OpLt: true, OpLe: true, OpGt: true, OpGe: true, OpRange: true}
+
+// Validate  check if the assertion can be evaluated
+func (a *Assertion) Validate() error {
+	switch a.Source {
+	case SourceStatus, SourceLatency, SourceBody:
+	case SourceJSON:
+		if a.Path == "" {
+			return errors.Errorf("assertion %v: json path is required", a.Name)
+		}
+	default:
+		return errors.Errorf("assertion %v: unknown source %q", a.Name, a.Source)
+	}
+
+	switch a.Op {
+	case OpEq, OpNe:
+	case OpContains, OpNotContains:
+		if a.Value == "" {
+			return errors.Errorf("assertion %v: pattern cannot be empty", a.Name)
+		}
+	case OpLt, OpLe, OpGt, OpGe:
+		if _, err := a.number(a.Value); err != nil {
+			return errors.Wrapf(err, "assertion %v", a.Name)
+		}
+	case OpRange:
+		if _, _, err := a.bounds(); err != nil {
+			return errors.Wrapf(err, "assertion %v", a.Name)
+		}
+	case OpMatches:
+		if _, err := regexp.Compile(a.Value); err != nil {
+			return errors.Wrapf(err, "assertion %v", a.Name)
+		}
+	case OpExists, OpNotExists:
+		if a.Source != SourceJSON {
+			return errors.Errorf("assertion %v: %v only applies to json", a.Name, a.Op)
+		}
+	default:
+		return errors.Errorf("assertion %v: unknown op %q", a.Name, a.Op)
+	}
+
+	if a.Source == SourceLatency && !numberOps[a.Op] {
+		return errors.Errorf("assertion %v: latency only supports lt, le, gt, ge and range", a.Name)
+	}
+	return nil
+}
+
+// number  parse a number, or a duration in milliseconds for latency
+func (a *Assertion) number(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if a.Source == SourceLatency {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, err
+		}
+		return float64(d) / float64(time.Millisecond), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (a *Assertion) bounds() (float64, float64, error) {
+	parts := strings.Split(a.Value, ",")
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("range should be min,max, got %q", a.Value)
+	}
+	min, err := a.number(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := a.number(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if min > max {
+		return 0, 0, errors.Errorf("range min %v is greater than max %v", parts[0], parts[1])
+	}
+	return min, max, nil
+}
+
+// response  what assertions are evaluated against
+type response struct {
+	status  int
+	latency time.Duration
+	body    string
+
+	parsed  bool
+	json    interface{}
+	jsonErr error
+}
+
+func (r *response) jsonBody() (interface{}, error) {
+	if !r.parsed {
+		r.parsed = true
+		dec := json.NewDecoder(bytes.NewReader([]byte(r.body)))
+		dec.UseNumber()
+		r.jsonErr = dec.Decode(&r.json)
+	}
+	return r.json, r.jsonErr
+}
+
+// lookup  value at dot separated path of v
+func lookup(v interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return v, true
+	}
+
+	for _, p := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			nv, ok := t[p]
+			if !ok {
+				return nil, false
+			}
+			v = nv
+		case []interface{}:
+			idx, err := strconv.Atoi(p)
+			if err != nil || idx < 0 || idx >= len(t) {
+				return nil, false
+			}
+			v = t[idx]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func jsonString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case nil:
+		return "null"
+	}
+	dat, _ := json.Marshal(v)
+	return string(dat)
+}
+
+// eval  evaluate assertion against resp
+func (a *Assertion) eval(resp *response) *AssertionResult {
+	ret := &AssertionResult{Assertion: a}
+
+	var actual string
+	switch a.Source {
+	case SourceStatus:
+		actual = strconv.Itoa(resp.status)
+	case SourceLatency:
+		actual = resp.latency.String()
+	case SourceBody:
+		actual = resp.body
+	case SourceJSON:
+		v, err := resp.jsonBody()
+		if err != nil {
+			ret.Msg = fmt.Sprintf("body is not json: %v", err)
+			return ret
+		}
+		v, found := lookup(v, a.Path)
+		switch a.Op {
+		case OpExists:
+			ret.OK = found
+			return ret
+		case OpNotExists:
+			ret.OK = !found
+			return ret
+		}
+		if !found {
+			ret.Msg = "path not found"
+			return ret
+		}
+		actual = jsonString(v)
+	}
+	if a.Source != SourceBody {
+		ret.Actual = actual
+	}
+
+	switch a.Op {
+	case OpEq:
+		ret.OK = actual == a.Value
+	case OpNe:
+		ret.OK = actual != a.Value
+	case OpContains:
+		ret.OK = strings.Contains(actual, a.Value)
+	case OpNotContains:
+		ret.OK = !strings.Contains(actual, a.Value)
+	case OpMatches:
+		re, err := regexp.Compile(a.Value)
+		if err != nil {
+			ret.Msg = err.Error()
+			return ret
+		}
+		ret.OK = re.MatchString(actual)
+	case OpLt, OpLe, OpGt, OpGe, OpRange:
+		ret.OK, ret.Msg = a.compare(resp, actual)
+	default:
+		ret.Msg = fmt.Sprintf("unknown op %q", a.Op)
+	}
+	return ret
+}
+
+func (a *Assertion) compare(resp *response, actual string) (bool, string) {
+	var n float64
+	if a.Source == SourceLatency {
+		n = float64(resp.latency) / float64(time.Millisecond)
+	} else {
+		var err error
+		if n, err = strconv.ParseFloat(actual, 64); err != nil {
+			return false, "actual value is not a number"
+		}
+	}
+
+	if a.Op == OpRange {
+		min, max, err := a.bounds()
+		if err != nil {
+			return false, err.Error()
+		}
+		return n >= min && n <= max, ""
+	}
+
+	v, err := a.number(a.Value)
+	if err != nil {
+		return false, err.Error()
+	}
+	switch a.Op {
+	case OpLt:
+		return n < v, ""
+	case OpLe:
+		return n <= v, ""
+	case OpGt:
+		return n > v, ""
+	}
+	return n >= v, ""
+}
+
+// defaultStatus  applied when an interface has no status assertion
+var defaultStatus = Assertion{Name: "status", Source: SourceStatus, Op: OpRange, Value: "200,299"}
+
+// AllAssertions  Matches and DontMatches as body assertions, followed by Assertions,
+// http status should be 2xx if not asserted
+func (pi *ProbeInterface) AllAssertions() []*Assertion {
+	ret := make([]*Assertion, 0, len(pi.Matches)+len(pi.DontMatches)+len(pi.Assertions)+1)
+
+	hasStatus := false
+	for _, a := range pi.Assertions {
+		if a.Source == SourceStatus {
+			hasStatus = true
+		}
+	}
+	if !hasStatus {
+		a := defaultStatus
+		ret = append(ret, &a)
+	}
+
+	legacy := make([]*Assertion, 0, len(pi.Matches)+len(pi.DontMatches))
+	for k, v := range pi.Matches {
+		legacy = append(legacy, &Assertion{Name: k, Source: SourceBody, Op: OpContains, Value: v})
+	}
+	for k, v := range pi.DontMatches {
+		legacy = append(legacy, &Assertion{Name: k, Source: SourceBody, Op: OpNotContains, Value: v})
+	}
+	sort.Slice(legacy, func(i, j int) bool {
+		if legacy[i].Op != legacy[j].Op {
+			return legacy[i].Op == OpContains
+		}
+		return legacy[i].Name < legacy[j].Name
+	})
+
+	ret = append(ret, legacy...)
+	for i, a := range pi.Assertions {
+		c := *a
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("#%d", i)
+		}
+		ret = append(ret, &c)
+	}
+	return ret
+}
@@ -0,0 +1,102 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package java
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAssertion_eval(t *testing.T) {
+	resp := &response{
+		status:  200,
+		latency: 120 * time.Millisecond,
+		body:    `{"code":0,"msg":"success","data":{"items":[{"id":7,"name":"crm"}],"total":1}}`,
+	}
+
+	tests := []struct {
+		name string
+		a    Assertion
+		want bool
+	}{
+		{"status", Assertion{Source: SourceStatus, Op: OpEq, Value: "200"}, true},
+		{"status range", Assertion{Source: SourceStatus, Op: OpRange, Value: "500,599"}, false},
+		{"latency", Assertion{Source: SourceLatency, Op: OpLt, Value: "100ms"}, false},
+		{"latency range", Assertion{Source: SourceLatency, Op: OpRange, Value: "100ms,1s"}, true},
+		{"body contains", Assertion{Source: SourceBody, Op: OpContains, Value: "success"}, true},
+		{"body not contains", Assertion{Source: SourceBody, Op: OpNotContains, Value: "error"}, true},
+		{"json eq", Assertion{Source: SourceJSON, Path: "code", Op: OpEq, Value: "0"}, true},
+		{"json $ path", Assertion{Source: SourceJSON, Path: "$.data.items.0.name", Op: OpEq, Value: "crm"}, true},
+		{"json number", Assertion{Source: SourceJSON, Path: "data.total", Op: OpGe, Value: "1"}, true},
+		{"json not number", Assertion{Source: SourceJSON, Path: "msg", Op: OpGt, Value: "1"}, false},
+		{"json matches", Assertion{Source: SourceJSON, Path: "msg", Op: OpMatches, Value: "^succ"}, true},
+		{"json exists", Assertion{Source: SourceJSON, Path: "data.items.1", Op: OpExists}, false},
+		{"json not exists", Assertion{Source: SourceJSON, Path: "error", Op: OpNotExists}, true},
+		{"json path not found", Assertion{Source: SourceJSON, Path: "data.count", Op: OpEq, Value: "1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.a.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if got := tt.a.eval(resp); got.OK != tt.want {
+				t.Errorf("eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertion_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       Assertion
+		wantErr bool
+	}{
+		{"unknown source", Assertion{Source: "header", Op: OpEq}, true},
+		{"json without path", Assertion{Source: SourceJSON, Op: OpEq}, true},
+		{"bad range", Assertion{Source: SourceStatus, Op: OpRange, Value: "300,200"}, true},
+		{"bad latency", Assertion{Source: SourceLatency, Op: OpLt, Value: "100"}, true},
+		{"latency contains", Assertion{Source: SourceLatency, Op: OpContains, Value: "1"}, true},
+		{"exists on body", Assertion{Source: SourceBody, Op: OpExists}, true},
+		{"bad regexp", Assertion{Source: SourceBody, Op: OpMatches, Value: "("}, true},
+		{"empty contains", Assertion{Source: SourceBody, Op: OpContains}, true},
+		{"valid", Assertion{Source: SourceJSON, Path: "code", Op: OpNe, Value: "0"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.a.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeInterface_AllAssertions(t *testing.T) {
+	pi := &ProbeInterface{
+		Matches:     map[string]string{"ok": "success"},
+		DontMatches: map[string]string{"err": "exception"},
+		Assertions:  []*Assertion{{Source: SourceJSON, Path: "code", Op: OpEq, Value: "0"}},
+	}
+
+	got := pi.AllAssertions()
+	want := []string{"status", "ok", "err", "#0"}
+	if len(got) != len(want) {
+		t.Fatalf("AllAssertions() = %d assertions, want %d", len(got), len(want))
+	}
+	for i, a := range got {
+		if a.Name != want[i] {
+			t.Errorf("AllAssertions()[%d] = %v, want %v", i, a.Name, want[i])
+		}
+	}
+	if pi.Assertions[0].Name != "" {
+		t.Errorf("AllAssertions() changed assertions of interface")
+	}
+
+	pi.Assertions = append(pi.Assertions, &Assertion{Source: SourceStatus, Op: OpEq, Value: "500"})
+	if got := pi.AllAssertions(); got[0].Name == "status" {
+		t.Errorf("AllAssertions() added default status assertion, though status is asserted")
+	}
+}
@@ -6,14 +6,15 @@ Sniperkit-Bot
 package java
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"net/http"
+	"time"
 
 	multierror "github.com/hashicorp/go-multierror"
 	"we.com/dolphin/types"
 	"we.com/jiabiao/common/probe"
-	"we.com/jiabiao/common/probe/java"
 )
 
 // ProbeInterfaceProvider from which get can get interface to dial
@@ -28,7 +29,9 @@ var (
 // ProbeInterfaces probes interfaces of a given deployment
 type ProbeInterfaces map[string]*ProbeInterface
 
-// ProbeInterface  a single  probe interface
+// ProbeInterface  a single  probe interface,
+// response body should contain values of Matches, and should not contain values of DontMatches,
+// and satisfy all Assertions
 type ProbeInterface struct {
 	Name        string            `json:"name,omitempty"`
 	Desc        string            `json:"desc,omitempty"`
@@ -37,6 +40,7 @@ type ProbeInterface struct {
 	Stages      []string          `json:"env,omitempty"`
 	Matches     map[string]string `json:"matches,omitempty"`
 	DontMatches map[string]string `json:"dontMatches,omitempty"`
+	Assertions  []*Assertion      `json:"assertions,omitempty"`
 }
 
 // Validate test if a ProbeInterface is valid
@@ -53,65 +57,69 @@ func (pi *ProbeInterface) Validate() error {
 		err = multierror.Append(err, terr)
 	}
 
+	for _, a := range pi.AllAssertions() {
+		if terr := a.Validate(); terr != nil {
+			err = multierror.Append(err, terr)
+		}
+	}
+
 	return err.ErrorOrNil()
 }
 
+// probeTimeout  timeout of probing an interface once
+const probeTimeout = 5 * time.Second
+
+var probeClient = &http.Client{Timeout: probeTimeout}
+
 // Prober probe a java server
 type Prober struct {
 }
 
-func (p *Prober) lg(ins *types.Instance) (probe.LoadGenerator, error) {
-	if diProvider == nil {
-		return nil, nil
-	}
-	ii, ok := ins.Private.(*InstanceInfo)
-	if !ok {
-		return nil, errors.New("not an java instance")
+func (p *Prober) url(ins *types.Instance) (string, error) {
+	if _, ok := ins.Private.(*InstanceInfo); !ok {
+		return "", errors.New("not an java instance")
 	}
 
 	if len(ins.Listening) == 0 {
-		return nil, nil
+		return "", nil
 	}
 
 	if len(ins.Listening) > 1 {
-		return nil, errors.New("instance is listening to port, probe which")
+		return "", errors.New("instance is listening to port, probe which")
 	}
 
-	return func() interface{} {
-		addr := ins.Listening[0]
-		url := fmt.Sprintf("http://%v:%v", addr.IP, addr.Port)
-		dis := diProvider.GetProbeInterfaces(ins.DeployName)
-
-		ret := make([]*java.Args, 0, len(dis))
-		for _, di := range dis {
-			args := java.Args{
-				Name:    di.Name,
-				Cluster: string(ii.NodeName),
-				Data:    strings.NewReader(di.Data),
-				URL:     url,
-				Headers: di.Headers,
-			}
-			ret = append(ret, &args)
-		}
-		return ret
-	}, nil
+	addr := ins.Listening[0]
+	return fmt.Sprintf("http://%v:%v", addr.IP, addr.Port), nil
 }
 
-// Probe probe backend java server
+// Probe probe backend java server with all its interfaces,
+// the returned error tells which assertions failed
 func (p *Prober) Probe(ins *types.Instance) (probe.Result, error) {
-	if ins == nil {
+	if ins == nil || diProvider == nil {
 		return probe.Success, nil
 	}
 
-	lg, err := p.lg(ins)
+	url, err := p.url(ins)
 	if err != nil {
 		return probe.Unknown, err
 	}
 
-	if lg == nil {
+	if url == "" {
 		return probe.Success, nil
 	}
 
-	ret, _, err := java.Probe(lg)
-	return ret, err
+	var merr *multierror.Error
+	for _, di := range diProvider.GetProbeInterfaces(ins.DeployName) {
+		ctx, cf := context.WithTimeout(context.Background(), probeTimeout)
+		pd := di.Run(ctx, probeClient, url)
+		cf()
+		if err := pd.Err(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	if err := merr.ErrorOrNil(); err != nil {
+		return probe.Failure, err
+	}
+	return probe.Success, nil
 }
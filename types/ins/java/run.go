@@ -0,0 +1,117 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package java
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"we.com/jiabiao/common/probe"
+)
+
+// maxDetailBody  response body longer than this is truncated in ProbeDetail
+const maxDetailBody = 4096
+
+// ProbeDetail  result of probing an interface once
+type ProbeDetail struct {
+	Interface  string             `json:"interface"`
+	URL        string             `json:"url"`
+	Result     probe.Result       `json:"result"`
+	StatusCode int                `json:"statusCode,omitempty"`
+	Latency    time.Duration      `json:"latency"`
+	Body       string             `json:"body,omitempty"`
+	Assertions []*AssertionResult `json:"assertions,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// Failed  assertions not satisfied
+func (pd *ProbeDetail) Failed() []*AssertionResult {
+	var ret []*AssertionResult
+	for _, a := range pd.Assertions {
+		if !a.OK {
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
+
+// Err  why the probe failed, nil if succeeded
+func (pd *ProbeDetail) Err() error {
+	if pd.Result == probe.Success {
+		return nil
+	}
+	if pd.Error != "" {
+		return errors.Errorf("probe %v %v: %v", pd.Interface, pd.URL, pd.Error)
+	}
+
+	failed := pd.Failed()
+	msgs := make([]string, 0, len(failed))
+	for _, a := range failed {
+		msgs = append(msgs, a.String())
+	}
+	return errors.Errorf("probe %v %v: assertion failed: %v", pd.Interface, pd.URL, strings.Join(msgs, "; "))
+}
+
+// Run  post Data of the interface to url, and evaluate all assertions against the response
+func (pi *ProbeInterface) Run(ctx context.Context, client *http.Client, url string) *ProbeDetail {
+	ret := &ProbeDetail{
+		Interface: pi.Name,
+		URL:       url,
+		Result:    probe.Failure,
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(pi.Data))
+	if err != nil {
+		ret.Result = probe.Unknown
+		ret.Error = err.Error()
+		return ret
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range pi.Headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		ret.Latency = time.Since(start)
+		ret.Error = err.Error()
+		return ret
+	}
+	defer resp.Body.Close()
+
+	dat, err := ioutil.ReadAll(resp.Body)
+	ret.Latency = time.Since(start)
+	ret.StatusCode = resp.StatusCode
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+
+	body := string(dat)
+	ret.Body = body
+	if len(ret.Body) > maxDetailBody {
+		ret.Body = ret.Body[:maxDetailBody]
+	}
+
+	r := &response{status: resp.StatusCode, latency: ret.Latency, body: body}
+	ok := true
+	for _, a := range pi.AllAssertions() {
+		ar := a.eval(r)
+		ok = ok && ar.OK
+		ret.Assertions = append(ret.Assertions, ar)
+	}
+
+	if ok {
+		ret.Result = probe.Success
+	}
+	return ret
+}
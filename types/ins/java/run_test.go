@@ -0,0 +1,79 @@
+/*
+Sniperkit-Bot
+- Status: analyzed
+*/
+
+package java
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"we.com/jiabiao/common/probe"
+)
+
+func TestProbeInterface_Run(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dat, _ := ioutil.ReadAll(r.Body)
+		if string(dat) == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(`{"code":0,"msg":"success"}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		data        string
+		matches     map[string]string
+		dontMatches map[string]string
+		want        probe.Result
+		wantFailed  []string
+	}{
+		{
+			name:    "matched",
+			data:    "ok",
+			matches: map[string]string{"success": "success"},
+			want:    probe.Success,
+		},
+		{
+			name:        "unmatched",
+			data:        "ok",
+			matches:     map[string]string{"success": "success", "data": `"data"`},
+			dontMatches: map[string]string{"code": `"code":0`},
+			want:        probe.Failure,
+			wantFailed:  []string{"data", "code"},
+		},
+		{
+			name:       "bad status",
+			data:       "fail",
+			want:       probe.Failure,
+			wantFailed: []string{"status"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pi := &ProbeInterface{Name: tt.name, Data: tt.data, Matches: tt.matches, DontMatches: tt.dontMatches}
+			got := pi.Run(context.Background(), http.DefaultClient, srv.URL)
+			if got.Result != tt.want {
+				t.Errorf("Run() result = %v, want %v, detail: %+v", got.Result, tt.want, got)
+			}
+
+			failed := []string{}
+			for _, a := range got.Failed() {
+				failed = append(failed, a.Name)
+			}
+			if len(failed) != len(tt.wantFailed) {
+				t.Fatalf("Run() failed matches = %v, want %v", failed, tt.wantFailed)
+			}
+			for i := range failed {
+				if failed[i] != tt.wantFailed[i] {
+					t.Errorf("Run() failed matches = %v, want %v", failed, tt.wantFailed)
+				}
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -75,8 +76,9 @@ func (dr *DeployResource) Subtract(operand DeployResource) {
 }
 
 // Devide devide  operand resource usage to dr
+// if operand requires nothing, math.MaxInt64 is returned
 func (dr *DeployResource) Devide(operand DeployResource) int64 {
-	min := operand.Memory
+	min := uint64(math.MaxInt64)
 	if operand.Memory != 0 {
 		t := dr.Memory / operand.Memory
 		if t < min {
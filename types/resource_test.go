@@ -6,6 +6,7 @@ Sniperkit-Bot
 package types
 
 import (
+	"math"
 	"testing"
 )
 
@@ -82,3 +83,44 @@ func TestParseResoureValue(t *testing.T) {
 		})
 	}
 }
+
+func TestDeployResource_Devide(t *testing.T) {
+	free := DeployResource{
+		Memory: 8 * 1024,
+		CPU:    4 * CPUUnit,
+	}
+
+	tests := []struct {
+		name    string
+		operand DeployResource
+		want    int64
+	}{
+		{
+			name:    "memory",
+			operand: DeployResource{Memory: 1024},
+			want:    8,
+		},
+		{
+			name:    "cpu bound",
+			operand: DeployResource{Memory: 1024, CPU: CPUUnit},
+			want:    4,
+		},
+		{
+			name:    "short",
+			operand: DeployResource{Memory: 16 * 1024},
+			want:    0,
+		},
+		{
+			name:    "nothing required",
+			operand: DeployResource{},
+			want:    math.MaxInt64,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := free.Devide(tt.operand); got != tt.want {
+				t.Errorf("DeployResource.Devide() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}